@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"forum/db"
+	"os"
+	"strconv"
+)
+
+// runMigrateCommand handles "forum migrate [up|down [steps]|status]"
+func runMigrateCommand(m db.Migrator, args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "up":
+		return m.Migrate()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+			steps = n
+		}
+		return m.Rollback(steps)
+	case "status":
+		statuses, err := m.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			state := "pending"
+			if st.Applied {
+				state = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(os.Stdout, "%4d  %-30s  %s\n", st.Version, st.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate action %q (want up, down [steps] or status)", action)
+	}
+}
+
+// runSetRoleCommand handles "forum set-role <username> <user|moderator|admin>"
+func runSetRoleCommand(store db.Store, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: set-role <username> <%s|%s|%s>", db.RoleUser, db.RoleModerator, db.RoleAdmin)
+	}
+	switch args[1] {
+	case db.RoleUser, db.RoleModerator, db.RoleAdmin:
+	default:
+		return fmt.Errorf("unknown role %q", args[1])
+	}
+	return store.SetUserRole(args[0], args[1])
+}
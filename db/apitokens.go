@@ -0,0 +1,104 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// apiTokenColumns are the columns scanned by scanAPIToken, selected from
+// api_tokens t joined with the owner's row in users u
+const apiTokenColumns = `t.token_id, t.user_id, t.name, t.scopes, t.created_at, t.expires_at, t.last_used_at, u.role`
+
+// scanAPIToken scans a row selected with apiTokenColumns
+func scanAPIToken(row rowScanner) (APIToken, error) {
+	var t APIToken
+	var scopes string
+	var expiresAt sql.NullInt64
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.CreatedAt, &expiresAt, &t.LastUsedAt, &t.Role)
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	if expiresAt.Valid {
+		expires := time.Unix(expiresAt.Int64, 0)
+		t.ExpiresAt = &expires
+	}
+	return t, err
+}
+
+// CreateAPIToken stores a new personal access token under the hash of its
+// secret and sets its ID and creation time
+func (s *SQLStore) CreateAPIToken(token *APIToken, tokenHash string) error {
+	var expiresAt interface{}
+	if token.ExpiresAt != nil {
+		expiresAt = token.ExpiresAt.Unix()
+	}
+	token.CreatedAt = time.Now()
+	return s.db.QueryRow(`
+        INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+        RETURNING token_id`,
+		token.UserID, token.Name, tokenHash, strings.Join(token.Scopes, ","), token.CreatedAt, expiresAt).Scan(&token.ID)
+}
+
+// GetAPIToken returns the unexpired token with the given hash, or
+// sql.ErrNoRows when there is none
+func (s *SQLStore) GetAPIToken(tokenHash string) (*APIToken, error) {
+	token, err := scanAPIToken(s.db.QueryRow(`
+        SELECT `+apiTokenColumns+`
+        FROM api_tokens t
+        JOIN users u ON u.user_id = t.user_id
+        WHERE t.token_hash = ? AND (t.expires_at IS NULL OR t.expires_at > ?)`, tokenHash, time.Now().Unix()))
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetUserAPITokens returns all of the user's tokens, newest first
+func (s *SQLStore) GetUserAPITokens(userID uuid.UUID) ([]APIToken, error) {
+	rows, err := s.db.Query(`
+        SELECT `+apiTokenColumns+`
+        FROM api_tokens t
+        JOIN users u ON u.user_id = t.user_id
+        WHERE t.user_id = ?
+        ORDER BY t.created_at DESC, t.token_id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// TouchAPIToken records when a token was last used
+func (s *SQLStore) TouchAPIToken(tokenID int, lastUsed time.Time) error {
+	_, err := s.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE token_id = ?", lastUsed, tokenID)
+	return err
+}
+
+// DeleteAPIToken revokes one of the user's tokens, returning sql.ErrNoRows
+// if the user has no such token
+func (s *SQLStore) DeleteAPIToken(userID uuid.UUID, tokenID int) error {
+	res, err := s.db.Exec("DELETE FROM api_tokens WHERE token_id = ? AND user_id = ?", tokenID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
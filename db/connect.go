@@ -0,0 +1,133 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// dialect identifies the SQL flavour spoken by the underlying database
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+// rebind rewrites "?" placeholders into the form expected by the dialect
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// conn wraps *sql.DB so queries can be written once with "?" placeholders
+// and run against any supported dialect
+type conn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c *conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Prepare(query string) (*sql.Stmt, error) {
+	return c.DB.Prepare(c.dialect.rebind(query))
+}
+
+func (c *conn) Begin() (*tx, error) {
+	t, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &tx{Tx: t, dialect: c.dialect}, nil
+}
+
+// tx is the transaction counterpart of conn
+type tx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t *tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+func (t *tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+// Open opens the database described by dsn. DSNs starting with
+// postgres:// or postgresql:// select PostgreSQL; anything else is treated
+// as a SQLite file path, optionally prefixed with sqlite://.
+func Open(dsn string) (*SQLStore, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return NewPostgresStore(dsn)
+	}
+	return NewSQLiteStore(strings.TrimPrefix(dsn, "sqlite://"))
+}
+
+// NewSQLiteStore opens the SQLite database at path. Call Migrate to bring
+// the schema up to date before use.
+func NewSQLiteStore(path string) (*SQLStore, error) {
+	return openSQLite("sqlite3", path)
+}
+
+// openSQLite opens path with the named SQLite driver
+func openSQLite(driverName, path string) (*SQLStore, error) {
+	db, err := sql.Open(driverName, path)
+	if err != nil {
+		return nil, err
+	}
+	var fts5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if !fts5 {
+		log.Printf("SQLite was built without FTS5, search falls back to LIKE matching; build with -tags sqlite_fts5 for ranked search")
+	}
+	return &SQLStore{db: &conn{DB: db, dialect: dialectSQLite}, fts5: fts5}, nil
+}
+
+// NewPostgresStore connects to the PostgreSQL database at dsn. Call Migrate
+// to bring the schema up to date before use.
+func NewPostgresStore(dsn string) (*SQLStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLStore{db: &conn{DB: db, dialect: dialectPostgres}}, nil
+}
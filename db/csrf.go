@@ -0,0 +1,56 @@
+package db
+
+import "time"
+
+// MaxCSRFTokensPerKey is how many tokens a session or pre-session keeps.
+// Issuing another one drops the oldest, so repeated requests for tokens
+// cannot grow the table without bound.
+const MaxCSRFTokensPerKey = 10
+
+// CreateCSRFToken stores a CSRF token for the session or pre-session key
+// with hash keyHash, keeping only the newest MaxCSRFTokensPerKey of its
+// tokens
+func (s *SQLStore) CreateCSRFToken(tokenHash, keyHash string, expiresAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("INSERT INTO csrf_tokens (token_hash, key_hash, created_at, expires_at) VALUES (?, ?, ?, ?)", tokenHash, keyHash, time.Now(), expiresAt.Unix())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+        DELETE FROM csrf_tokens
+        WHERE key_hash = ? AND token_hash NOT IN (
+            SELECT token_hash FROM csrf_tokens WHERE key_hash = ?
+            ORDER BY created_at DESC LIMIT ?)`, keyHash, keyHash, MaxCSRFTokensPerKey)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CheckCSRFToken reports whether the token is unexpired and was issued for
+// keyHash
+func (s *SQLStore) CheckCSRFToken(tokenHash, keyHash string) (bool, error) {
+	var ok bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM csrf_tokens WHERE token_hash = ? AND key_hash = ? AND expires_at > ?)", tokenHash, keyHash, time.Now().Unix()).Scan(&ok)
+	return ok, err
+}
+
+// DeleteCSRFTokens removes every token issued for keyHash
+func (s *SQLStore) DeleteCSRFTokens(keyHash string) error {
+	_, err := s.db.Exec("DELETE FROM csrf_tokens WHERE key_hash = ?", keyHash)
+	return err
+}
+
+// DeleteExpiredCSRFTokens removes expired tokens and returns how many were
+// deleted
+func (s *SQLStore) DeleteExpiredCSRFTokens() (int64, error) {
+	res, err := s.db.Exec("DELETE FROM csrf_tokens WHERE expires_at <= ?", time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
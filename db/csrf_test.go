@@ -0,0 +1,42 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCSRFTokensPerKeyAreBounded(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *SQLStore) {
+		expires := time.Now().Add(time.Hour)
+		n := MaxCSRFTokensPerKey + 5
+		for i := 0; i < n; i++ {
+			if err := s.CreateCSRFToken(fmt.Sprintf("token-%d", i), "key", expires); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.CreateCSRFToken("other-token", "other-key", expires); err != nil {
+			t.Fatal(err)
+		}
+
+		var count int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM csrf_tokens WHERE key_hash = 'key'").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != MaxCSRFTokensPerKey {
+			t.Fatalf("key has %d tokens, want %d", count, MaxCSRFTokensPerKey)
+		}
+		for i := 0; i < n; i++ {
+			ok, err := s.CheckCSRFToken(fmt.Sprintf("token-%d", i), "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := i >= n-MaxCSRFTokensPerKey; ok != want {
+				t.Errorf("token %d valid = %v, want %v", i, ok, want)
+			}
+		}
+		if ok, _ := s.CheckCSRFToken("other-token", "other-key"); !ok {
+			t.Error("tokens of other keys were dropped")
+		}
+	})
+}
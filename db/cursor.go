@@ -0,0 +1,39 @@
+package db
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// Encode returns the opaque string form of the cursor handed to clients
+func (c PostCursor) Encode() string {
+	raw := c.CreatedAt.Format(time.RFC3339Nano) + "|" + c.PostID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodePostCursor parses a cursor previously produced by PostCursor.Encode
+func DecodePostCursor(s string) (*PostCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	createdAt, postID, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	id, err := uuid.FromString(postID)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return &PostCursor{CreatedAt: t, PostID: id}, nil
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// CreateOIDCState stores a login started with an identity provider under
+// the hash of its state parameter
+func (s *SQLStore) CreateOIDCState(stateHash, provider, nonce, codeVerifier string, expiresAt time.Time) error {
+	_, err := s.db.Exec("INSERT INTO oidc_states (state_hash, provider, nonce, code_verifier, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		stateHash, provider, nonce, codeVerifier, time.Now(), expiresAt.Unix())
+	return err
+}
+
+// ConsumeOIDCState removes a pending provider login and returns it. Unknown
+// and expired states return ErrInvalidToken.
+func (s *SQLStore) ConsumeOIDCState(stateHash string) (provider, nonce, codeVerifier string, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", "", "", err
+	}
+	defer tx.Rollback()
+
+	var expiresAt int64
+	err = tx.QueryRow("SELECT provider, nonce, code_verifier, expires_at FROM oidc_states WHERE state_hash = ?", stateHash).
+		Scan(&provider, &nonce, &codeVerifier, &expiresAt)
+	if err == sql.ErrNoRows {
+		return "", "", "", ErrInvalidToken
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	if _, err := tx.Exec("DELETE FROM oidc_states WHERE state_hash = ? OR expires_at <= ?", stateHash, time.Now().Unix()); err != nil {
+		return "", "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", "", err
+	}
+	if expiresAt <= time.Now().Unix() {
+		return "", "", "", ErrInvalidToken
+	}
+	return provider, nonce, codeVerifier, nil
+}
+
+// GetIdentityUser returns the user linked to an identity provider account,
+// or sql.ErrNoRows if it is not linked
+func (s *SQLStore) GetIdentityUser(provider, subject string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := s.db.QueryRow("SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?", provider, subject).Scan(&userID)
+	return userID, err
+}
+
+// LinkIdentity links an identity provider account to a user
+func (s *SQLStore) LinkIdentity(provider, subject, email string, userID uuid.UUID) error {
+	_, err := s.db.Exec("INSERT INTO user_identities (provider, subject, user_id, email, created_at) VALUES (?, ?, ?, ?, ?)",
+		provider, subject, userID, email, time.Now())
+	return err
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// AddLoginEvent records a login attempt
+func (s *SQLStore) AddLoginEvent(event LoginEvent) error {
+	_, err := s.db.Exec("INSERT INTO login_events (user_id, success, reason, ip, user_agent, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		event.UserID, event.Success, event.Reason, event.IP, event.UserAgent, time.Now())
+	return err
+}
+
+// GetLoginEvents returns the user's most recent login attempts, newest first
+func (s *SQLStore) GetLoginEvents(userID uuid.UUID, limit int) ([]LoginEvent, error) {
+	rows, err := s.db.Query(`
+        SELECT event_id, user_id, success, reason, ip, user_agent, created_at
+        FROM login_events
+        WHERE user_id = ?
+        ORDER BY created_at DESC, event_id DESC
+        LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []LoginEvent
+	for rows.Next() {
+		var e LoginEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Success, &e.Reason, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// CheckLoginDevice reports whether the user has logged in successfully
+// before with the given user agent, and whether they have logged in before
+// at all
+func (s *SQLStore) CheckLoginDevice(userID uuid.UUID, userAgent string) (knownDevice, anyLogin bool, err error) {
+	err = s.db.QueryRow(`
+        SELECT
+            EXISTS (SELECT 1 FROM login_events WHERE user_id = ? AND success = ? AND user_agent = ?),
+            EXISTS (SELECT 1 FROM login_events WHERE user_id = ? AND success = ?)`,
+		userID, true, userAgent, userID, true).Scan(&knownDevice, &anyLogin)
+	return knownDevice, anyLogin, err
+}
+
+// AddFailedLogin counts a failed login on the account and returns the
+// number of consecutive failures
+func (s *SQLStore) AddFailedLogin(userID uuid.UUID) (int, error) {
+	var failures int
+	err := s.db.QueryRow(`
+        INSERT INTO account_lockouts (user_id, failed_attempts) VALUES (?, 1)
+        ON CONFLICT (user_id) DO UPDATE SET failed_attempts = account_lockouts.failed_attempts + 1
+        RETURNING failed_attempts`, userID).Scan(&failures)
+	return failures, err
+}
+
+// LockAccount refuses logins to the account until the given time
+func (s *SQLStore) LockAccount(userID uuid.UUID, until time.Time) error {
+	_, err := s.db.Exec("UPDATE account_lockouts SET locked_until = ? WHERE user_id = ?", until.Unix(), userID)
+	return err
+}
+
+// GetAccountLock returns when the account's lockout ends, which is in the
+// past when it is not locked
+func (s *SQLStore) GetAccountLock(userID uuid.UUID) (time.Time, error) {
+	var lockedUntil int64
+	err := s.db.QueryRow("SELECT locked_until FROM account_lockouts WHERE user_id = ?", userID).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(lockedUntil, 0), nil
+}
+
+// ClearFailedLogins resets the account's failure count after a successful
+// login
+func (s *SQLStore) ClearFailedLogins(userID uuid.UUID) error {
+	_, err := s.db.Exec("DELETE FROM account_lockouts WHERE user_id = ?", userID)
+	return err
+}
@@ -0,0 +1,664 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Migration is a single numbered schema change with its rollback
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// Migrator is implemented by stores that manage their own schema
+type Migrator interface {
+	Migrate() error
+	Rollback(steps int) error
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY NOT NULL,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+// ftsMigrationVersion is the SQLite migration creating the FTS5 tables
+const ftsMigrationVersion = 2
+
+// sqliteMigrations is the ordered list of schema changes for SQLite.
+// New migrations must be appended with the next version number; applied
+// migrations must never be edited.
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up:      createtables,
+		Down: `
+DROP TABLE IF EXISTS user_status;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS post_categories;
+DROP TABLE IF EXISTS likes;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS categories;`,
+	},
+	{
+		// FTS5 needs go-sqlite3 built with -tags sqlite_fts5. Without it the
+		// migration is recorded without its tables; see SQLStore.migrations.
+		Version: ftsMigrationVersion,
+		Name:    "full-text search",
+		Up: `
+CREATE VIRTUAL TABLE posts_fts USING fts5(post_id UNINDEXED, subject, content);
+INSERT INTO posts_fts (post_id, subject, content) SELECT post_id, subject, content FROM posts;
+CREATE TRIGGER posts_fts_insert AFTER INSERT ON posts BEGIN
+	INSERT INTO posts_fts (post_id, subject, content) VALUES (new.post_id, new.subject, new.content);
+END;
+CREATE TRIGGER posts_fts_update AFTER UPDATE OF subject, content ON posts BEGIN
+	UPDATE posts_fts SET subject = new.subject, content = new.content WHERE post_id = old.post_id;
+END;
+CREATE TRIGGER posts_fts_delete AFTER DELETE ON posts BEGIN
+	DELETE FROM posts_fts WHERE post_id = old.post_id;
+END;
+CREATE VIRTUAL TABLE comments_fts USING fts5(comment_id UNINDEXED, content);
+INSERT INTO comments_fts (comment_id, content) SELECT comment_id, content FROM comments;
+CREATE TRIGGER comments_fts_insert AFTER INSERT ON comments BEGIN
+	INSERT INTO comments_fts (comment_id, content) VALUES (new.comment_id, new.content);
+END;
+CREATE TRIGGER comments_fts_update AFTER UPDATE OF content ON comments BEGIN
+	UPDATE comments_fts SET content = new.content WHERE comment_id = old.comment_id;
+END;
+CREATE TRIGGER comments_fts_delete AFTER DELETE ON comments BEGIN
+	DELETE FROM comments_fts WHERE comment_id = old.comment_id;
+END;
+CREATE VIRTUAL TABLE messages_fts USING fts5(message_id UNINDEXED, content);
+INSERT INTO messages_fts (message_id, content) SELECT message_id, content FROM messages;
+CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+	INSERT INTO messages_fts (message_id, content) VALUES (new.message_id, new.content);
+END;
+CREATE TRIGGER messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+	UPDATE messages_fts SET content = new.content WHERE message_id = old.message_id;
+END;
+CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+	DELETE FROM messages_fts WHERE message_id = old.message_id;
+END;`,
+		Down: `
+DROP TRIGGER IF EXISTS messages_fts_delete;
+DROP TRIGGER IF EXISTS messages_fts_update;
+DROP TRIGGER IF EXISTS messages_fts_insert;
+DROP TABLE IF EXISTS messages_fts;
+DROP TRIGGER IF EXISTS comments_fts_delete;
+DROP TRIGGER IF EXISTS comments_fts_update;
+DROP TRIGGER IF EXISTS comments_fts_insert;
+DROP TABLE IF EXISTS comments_fts;
+DROP TRIGGER IF EXISTS posts_fts_delete;
+DROP TRIGGER IF EXISTS posts_fts_update;
+DROP TRIGGER IF EXISTS posts_fts_insert;
+DROP TABLE IF EXISTS posts_fts;`,
+	},
+	{
+		Version: 3,
+		Name:    "roles, edits and revisions",
+		Up: `
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE posts ADD COLUMN edited_at TIMESTAMP;
+ALTER TABLE posts ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE comments ADD COLUMN edited_at TIMESTAMP;
+ALTER TABLE comments ADD COLUMN deleted_at TIMESTAMP;
+CREATE TABLE IF NOT EXISTS revisions (
+	revision_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	post_id UUID,
+	comment_id UUID,
+	editor_id UUID NOT NULL,
+	subject TEXT,
+	content TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(post_id) REFERENCES posts(post_id),
+	FOREIGN KEY(comment_id) REFERENCES comments(comment_id),
+	FOREIGN KEY(editor_id) REFERENCES users(user_id)
+);
+CREATE INDEX IF NOT EXISTS revisions_post_idx ON revisions(post_id);
+CREATE INDEX IF NOT EXISTS revisions_comment_idx ON revisions(comment_id);`,
+		Down: `
+DROP TABLE IF EXISTS revisions;
+ALTER TABLE comments DROP COLUMN deleted_at;
+ALTER TABLE comments DROP COLUMN edited_at;
+ALTER TABLE posts DROP COLUMN deleted_at;
+ALTER TABLE posts DROP COLUMN edited_at;
+ALTER TABLE users DROP COLUMN role;`,
+	},
+	{
+		Version: 4,
+		Name:    "threaded comments",
+		Up: `
+ALTER TABLE comments ADD COLUMN parent_comment_id UUID REFERENCES comments(comment_id);
+CREATE INDEX IF NOT EXISTS comments_parent_idx ON comments(parent_comment_id);`,
+		Down: `
+DROP INDEX IF EXISTS comments_parent_idx;
+ALTER TABLE comments DROP COLUMN parent_comment_id;`,
+	},
+	{
+		Version: 5,
+		Name:    "one reaction per user",
+		Up: `
+DELETE FROM likes WHERE post_id IS NOT NULL AND like_id NOT IN (
+	SELECT MAX(like_id) FROM likes WHERE post_id IS NOT NULL GROUP BY user_id, post_id
+);
+DELETE FROM likes WHERE comment_id IS NOT NULL AND like_id NOT IN (
+	SELECT MAX(like_id) FROM likes WHERE comment_id IS NOT NULL GROUP BY user_id, comment_id
+);
+CREATE UNIQUE INDEX IF NOT EXISTS likes_user_post_idx ON likes(user_id, post_id);
+CREATE UNIQUE INDEX IF NOT EXISTS likes_user_comment_idx ON likes(user_id, comment_id);`,
+		Down: `
+DROP INDEX IF EXISTS likes_user_comment_idx;
+DROP INDEX IF EXISTS likes_user_post_idx;`,
+	},
+	{
+		// likes.type was declared INTEGER but always held reaction names;
+		// the table is rebuilt to reference reaction_types instead
+		Version: 6,
+		Name:    "reaction types",
+		Up: `
+CREATE TABLE IF NOT EXISTS reaction_types (
+	reaction_type_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	name TEXT NOT NULL UNIQUE,
+	emoji TEXT NOT NULL,
+	position INTEGER NOT NULL DEFAULT 0,
+	enabled BOOLEAN NOT NULL DEFAULT 1
+);
+INSERT INTO reaction_types (name, emoji, position) VALUES
+	('like', '👍', 1),
+	('dislike', '👎', 2),
+	('love', '❤️', 3),
+	('laugh', '😂', 4),
+	('wow', '😮', 5);
+CREATE TABLE likes_new (
+	like_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	post_id UUID,
+	comment_id UUID,
+	user_id UUID NOT NULL,
+	reaction_type_id INTEGER NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(post_id) REFERENCES posts(post_id),
+	FOREIGN KEY(comment_id) REFERENCES comments(comment_id),
+	FOREIGN KEY(user_id) REFERENCES users(user_id),
+	FOREIGN KEY(reaction_type_id) REFERENCES reaction_types(reaction_type_id)
+);
+INSERT INTO likes_new (like_id, post_id, comment_id, user_id, reaction_type_id, created_at)
+	SELECT l.like_id, l.post_id, l.comment_id, l.user_id, rt.reaction_type_id, l.created_at
+	FROM likes l JOIN reaction_types rt ON rt.name = CAST(l.type AS TEXT);
+DROP TABLE likes;
+ALTER TABLE likes_new RENAME TO likes;
+CREATE UNIQUE INDEX IF NOT EXISTS likes_user_post_idx ON likes(user_id, post_id);
+CREATE UNIQUE INDEX IF NOT EXISTS likes_user_comment_idx ON likes(user_id, comment_id);`,
+		Down: `
+CREATE TABLE likes_old (
+	like_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	post_id UUID,
+	comment_id UUID,
+	user_id UUID NOT NULL,
+	type TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(post_id) REFERENCES posts(post_id),
+	FOREIGN KEY(comment_id) REFERENCES comments(comment_id),
+	FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+INSERT INTO likes_old (like_id, post_id, comment_id, user_id, type, created_at)
+	SELECT l.like_id, l.post_id, l.comment_id, l.user_id, rt.name, l.created_at
+	FROM likes l JOIN reaction_types rt ON rt.reaction_type_id = l.reaction_type_id
+	WHERE rt.name IN ('like', 'dislike');
+DROP TABLE likes;
+ALTER TABLE likes_old RENAME TO likes;
+CREATE UNIQUE INDEX IF NOT EXISTS likes_user_post_idx ON likes(user_id, post_id);
+CREATE UNIQUE INDEX IF NOT EXISTS likes_user_comment_idx ON likes(user_id, comment_id);
+DROP TABLE IF EXISTS reaction_types;`,
+	},
+	{
+		Version: 7,
+		Name:    "session indexes",
+		Up: `
+CREATE UNIQUE INDEX IF NOT EXISTS sessions_token_idx ON sessions(token);
+CREATE INDEX IF NOT EXISTS sessions_expires_idx ON sessions(expires_at);`,
+		Down: `
+DROP INDEX IF EXISTS sessions_expires_idx;
+DROP INDEX IF EXISTS sessions_token_idx;`,
+	},
+	{
+		Version: 8,
+		Name:    "session devices",
+		Up: `
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN last_seen_at TIMESTAMP;
+UPDATE sessions SET last_seen_at = created_at;
+CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions(user_id);`,
+		Down: `
+DROP INDEX IF EXISTS sessions_user_idx;
+ALTER TABLE sessions DROP COLUMN last_seen_at;
+ALTER TABLE sessions DROP COLUMN ip;
+ALTER TABLE sessions DROP COLUMN user_agent;`,
+	},
+	{
+		Version: 9,
+		Name:    "password resets",
+		Up: `
+CREATE TABLE IF NOT EXISTS password_resets (
+	token_hash TEXT PRIMARY KEY NOT NULL,
+	user_id UUID NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT NOT NULL,
+	used_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX IF NOT EXISTS password_resets_user_idx ON password_resets(user_id);`,
+		Down: `
+DROP TABLE IF EXISTS password_resets;`,
+	},
+	{
+		// Accounts created before verification existed are treated as verified
+		Version: 10,
+		Name:    "email verification",
+		Up: `
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+UPDATE users SET email_verified_at = CURRENT_TIMESTAMP;
+CREATE TABLE IF NOT EXISTS email_verifications (
+	token_hash TEXT PRIMARY KEY NOT NULL,
+	user_id UUID NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT NOT NULL,
+	used_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX IF NOT EXISTS email_verifications_user_idx ON email_verifications(user_id);`,
+		Down: `
+DROP TABLE IF EXISTS email_verifications;
+ALTER TABLE users DROP COLUMN email_verified_at;`,
+	},
+	{
+		Version: 11,
+		Name:    "two factor",
+		Up: `
+CREATE TABLE IF NOT EXISTS two_factor (
+	user_id UUID PRIMARY KEY NOT NULL,
+	secret TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	enabled_at TIMESTAMP,
+	last_step BIGINT NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	code_hash TEXT PRIMARY KEY NOT NULL,
+	user_id UUID NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	used_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX IF NOT EXISTS recovery_codes_user_idx ON recovery_codes(user_id);
+CREATE TABLE IF NOT EXISTS pending_logins (
+	token_hash TEXT PRIMARY KEY NOT NULL,
+	user_id UUID NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE TABLE IF NOT EXISTS role_policies (
+	role TEXT PRIMARY KEY NOT NULL,
+	require_2fa BOOLEAN NOT NULL DEFAULT FALSE
+);`,
+		Down: `
+DROP TABLE IF EXISTS role_policies;
+DROP TABLE IF EXISTS pending_logins;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS two_factor;`,
+	},
+	{
+		Version: 12,
+		Name:    "case-insensitive users",
+		Up: `
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_idx ON users(LOWER(username));
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx ON users(LOWER(email));`,
+		Down: `
+DROP INDEX IF EXISTS users_email_lower_idx;
+DROP INDEX IF EXISTS users_username_lower_idx;`,
+	},
+	{
+		Version: 13,
+		Name:    "login events",
+		Up: `
+CREATE TABLE IF NOT EXISTS login_events (
+	event_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	user_id UUID NOT NULL,
+	success BOOLEAN NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX IF NOT EXISTS login_events_user_idx ON login_events(user_id, created_at);
+CREATE TABLE IF NOT EXISTS account_lockouts (
+	user_id UUID PRIMARY KEY NOT NULL,
+	failed_attempts INTEGER NOT NULL DEFAULT 0,
+	locked_until BIGINT NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(user_id)
+);`,
+		Down: `
+DROP TABLE IF EXISTS account_lockouts;
+DROP TABLE IF EXISTS login_events;`,
+	},
+	{
+		Version: 14,
+		Name:    "api tokens",
+		Up: `
+CREATE TABLE IF NOT EXISTS api_tokens (
+	token_id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+	user_id UUID NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT,
+	last_used_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX IF NOT EXISTS api_tokens_user_idx ON api_tokens(user_id);`,
+		Down: `
+DROP TABLE IF EXISTS api_tokens;`,
+	},
+	{
+		Version: 15,
+		Name:    "oidc identities",
+		Up: `
+CREATE TABLE IF NOT EXISTS user_identities (
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	user_id UUID NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (provider, subject),
+	FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities(user_id);
+CREATE TABLE IF NOT EXISTS oidc_states (
+	state_hash TEXT PRIMARY KEY NOT NULL,
+	provider TEXT NOT NULL,
+	nonce TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT NOT NULL
+);`,
+		Down: `
+DROP TABLE IF EXISTS oidc_states;
+DROP TABLE IF EXISTS user_identities;`,
+	},
+	{
+		Version: 16,
+		Name:    "csrf tokens",
+		Up: `
+CREATE TABLE IF NOT EXISTS csrf_tokens (
+	token_hash TEXT PRIMARY KEY NOT NULL,
+	key_hash TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS csrf_tokens_key_idx ON csrf_tokens(key_hash);
+CREATE INDEX IF NOT EXISTS csrf_tokens_expires_idx ON csrf_tokens(expires_at);`,
+		Down: `
+DROP TABLE IF EXISTS csrf_tokens;`,
+	},
+	{
+		Version: 17,
+		Name:    "rate limits",
+		Up: `
+CREATE TABLE IF NOT EXISTS rate_limits (
+	bucket_key TEXT PRIMARY KEY NOT NULL,
+	tat BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS rate_limits_tat_idx ON rate_limits(tat);`,
+		Down: `
+DROP TABLE IF EXISTS rate_limits;`,
+	},
+	{
+		// used_at becomes a unix time like expires_at. Times that cannot be
+		// parsed count as used at expiry, so no used token becomes valid.
+		Version: 18,
+		Name:    "password reset unix times",
+		Up: `
+ALTER TABLE password_resets ADD COLUMN used_at_unix BIGINT;
+UPDATE password_resets SET used_at_unix = COALESCE(CAST(strftime('%s', used_at) AS INTEGER), expires_at) WHERE used_at IS NOT NULL;
+ALTER TABLE password_resets DROP COLUMN used_at;
+ALTER TABLE password_resets RENAME COLUMN used_at_unix TO used_at;`,
+		Down: `
+ALTER TABLE password_resets ADD COLUMN used_at_time TIMESTAMP;
+UPDATE password_resets SET used_at_time = datetime(used_at, 'unixepoch') WHERE used_at IS NOT NULL;
+ALTER TABLE password_resets DROP COLUMN used_at;
+ALTER TABLE password_resets RENAME COLUMN used_at_time TO used_at;`,
+	},
+}
+
+// migrator applies a list of migrations to a database
+type migrator struct {
+	db         *conn
+	migrations []Migration
+}
+
+func newMigrator(db *conn, migrations []Migration) (*migrator, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", sorted[i].Version)
+		}
+	}
+	if _, err := db.Exec(createMigrationsTable); err != nil {
+		return nil, err
+	}
+	return &migrator{db: db, migrations: sorted}, nil
+}
+
+// applied returns the applied migration versions with their timestamps
+func (m *migrator) applied() (map[int]time.Time, error) {
+	rows, err := m.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+	return versions, rows.Err()
+}
+
+// up applies every pending migration, each in its own transaction
+func (m *migrator) up() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		tx, err := m.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := execScript(tx, mig.Up); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Name, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", mig.Version, mig.Name); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Applied migration %d: %s", mig.Version, mig.Name)
+	}
+	return nil
+}
+
+// execScript runs a migration script; empty scripts do nothing
+func execScript(t *tx, script string) error {
+	if script == "" {
+		return nil
+	}
+	_, err := t.Exec(script)
+	return err
+}
+
+// down rolls back the most recently applied migrations, up to steps of them
+func (m *migrator) down(steps int) error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		tx, err := m.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := execScript(tx, mig.Down); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("rollback %d (%s): %w", mig.Version, mig.Name, err)
+		}
+		if _, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", mig.Version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Rolled back migration %d: %s", mig.Version, mig.Name)
+		steps--
+	}
+	return nil
+}
+
+// status lists every known migration and whether it has been applied
+func (m *migrator) status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []MigrationStatus
+	for _, mig := range m.migrations {
+		st := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if at, ok := applied[mig.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = &at
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// Migrate applies all pending schema migrations
+func (s *SQLStore) Migrate() error {
+	m, err := newMigrator(s.db, s.migrations())
+	if err != nil {
+		return err
+	}
+	if err := m.up(); err != nil {
+		return err
+	}
+	return s.buildSearchIndex()
+}
+
+// buildSearchIndex creates the FTS5 tables of a SQLite database that was
+// migrated by a build without FTS5, once a build with it opens the database
+func (s *SQLStore) buildSearchIndex() error {
+	if s.db.dialect != dialectSQLite || !s.fts5 {
+		return nil
+	}
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE name = 'posts_fts')").Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	statuses, err := s.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		if st.Version == ftsMigrationVersion && !st.Applied {
+			return nil
+		}
+	}
+	for _, mig := range sqliteMigrations {
+		if mig.Version == ftsMigrationVersion {
+			if _, err := s.db.Exec(mig.Up); err != nil {
+				return fmt.Errorf("build search index: %w", err)
+			}
+			log.Printf("Built the full-text search index")
+		}
+	}
+	return nil
+}
+
+// Rollback reverts the last steps applied schema migrations
+func (s *SQLStore) Rollback(steps int) error {
+	m, err := newMigrator(s.db, s.migrations())
+	if err != nil {
+		return err
+	}
+	return m.down(steps)
+}
+
+// MigrationStatus lists all schema migrations and whether they are applied
+func (s *SQLStore) MigrationStatus() ([]MigrationStatus, error) {
+	m, err := newMigrator(s.db, s.migrations())
+	if err != nil {
+		return nil, err
+	}
+	return m.status()
+}
+
+// migrations returns the migration list matching the store's dialect
+func (s *SQLStore) migrations() []Migration {
+	if s.db.dialect == dialectPostgres {
+		return postgresMigrations
+	}
+	if s.fts5 {
+		return sqliteMigrations
+	}
+	// Record the FTS5 migration without running it, so the schema stays in
+	// step with builds that have FTS5 and Search matches with LIKE instead
+	migrations := make([]Migration, len(sqliteMigrations))
+	copy(migrations, sqliteMigrations)
+	for i := range migrations {
+		if migrations[i].Version == ftsMigrationVersion {
+			migrations[i].Up, migrations[i].Down = "", ""
+		}
+	}
+	return migrations
+}
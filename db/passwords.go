@@ -0,0 +1,62 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// ErrInvalidToken is returned for unknown, expired or already used tokens
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// CreatePasswordReset stores the hash of a password reset token
+func (s *SQLStore) CreatePasswordReset(userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	_, err := s.db.Exec("INSERT INTO password_resets (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)", tokenHash, userID, time.Now(), expiresAt.Unix())
+	return err
+}
+
+// GetPasswordResetUser returns the user of an unused, unexpired reset
+// token without consuming it, or ErrInvalidToken
+func (s *SQLStore) GetPasswordResetUser(tokenHash string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := s.db.QueryRow("SELECT user_id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now().Unix()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, ErrInvalidToken
+	}
+	return userID, err
+}
+
+// ResetPassword consumes a password reset token and sets the user's password
+// hash. Every other outstanding reset token of the user is used up as well.
+// It returns the user whose password changed. The token is claimed by a
+// single conditional update, so of two concurrent resets with the same
+// token only one succeeds.
+func (s *SQLStore) ResetPassword(tokenHash, passwordHash string) (uuid.UUID, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	var userID uuid.UUID
+	err = tx.QueryRow(`
+        UPDATE password_resets SET used_at = ?
+        WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+        RETURNING user_id`, now, tokenHash, now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, ErrInvalidToken
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := tx.Exec("UPDATE users SET password = ? WHERE user_id = ?", passwordHash, userID); err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := tx.Exec("UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL", now, userID); err != nil {
+		return uuid.Nil, err
+	}
+	return userID, tx.Commit()
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResetPasswordConsumesTokenOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *SQLStore) {
+		users := seedForum(t, s, 1)
+		if err := s.CreatePasswordReset(users[0], "token", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreatePasswordReset(users[0], "other", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Of concurrent resets with the same token exactly one wins
+		const racers = 8
+		var wg sync.WaitGroup
+		results := make(chan error, racers)
+		for i := 0; i < racers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				id, err := s.ResetPassword("token", "hash")
+				if err == nil && id != users[0] {
+					t.Errorf("reset returned user %v, want %v", id, users[0])
+				}
+				results <- err
+			}()
+		}
+		wg.Wait()
+		close(results)
+		won := 0
+		for err := range results {
+			switch err {
+			case nil:
+				won++
+			case ErrInvalidToken:
+			default:
+				t.Errorf("reset: %v", err)
+			}
+		}
+		if won != 1 {
+			t.Fatalf("%d resets succeeded, want 1", won)
+		}
+
+		// The user's other tokens are used up by the reset
+		if _, err := s.ResetPassword("other", "hash"); err != ErrInvalidToken {
+			t.Fatalf("resetting with another token of the user: %v", err)
+		}
+		if _, err := s.GetPasswordResetUser("other"); err != ErrInvalidToken {
+			t.Fatalf("looking up another token of the user: %v", err)
+		}
+	})
+}
+
+func TestResetPasswordRejectsExpiredToken(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *SQLStore) {
+		users := seedForum(t, s, 1)
+		if err := s.CreatePasswordReset(users[0], "token", time.Now().Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetPasswordResetUser("token"); err != ErrInvalidToken {
+			t.Fatalf("looking up an expired token: %v", err)
+		}
+		if _, err := s.ResetPassword("token", "hash"); err != ErrInvalidToken {
+			t.Fatalf("resetting with an expired token: %v", err)
+		}
+		if _, err := s.ResetPassword("unknown", "hash"); err != ErrInvalidToken {
+			t.Fatalf("resetting with an unknown token: %v", err)
+		}
+	})
+}
+
+func TestPasswordResetUsedAtMigration(t *testing.T) {
+	s := newTestStore(t)
+	users := seedForum(t, s, 1)
+	if err := s.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Before version 18 used_at held a timestamp
+	usedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	expires := time.Now().Add(time.Hour).Unix()
+	insert := "INSERT INTO password_resets (token_hash, user_id, created_at, expires_at, used_at) VALUES (?, ?, ?, ?, ?)"
+	if _, err := s.db.Exec(insert, "used", users[0], time.Now(), expires, usedAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(insert, "unused", users[0], time.Now(), expires, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got int64
+	if err := s.db.QueryRow("SELECT used_at FROM password_resets WHERE token_hash = 'used'").Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != usedAt.Unix() {
+		t.Fatalf("used_at = %d, want %d", got, usedAt.Unix())
+	}
+	if _, err := s.GetPasswordResetUser("used"); err != ErrInvalidToken {
+		t.Fatalf("used token after migrating: %v", err)
+	}
+	id, err := s.GetPasswordResetUser("unused")
+	if err != nil || id != users[0] {
+		t.Fatalf("unused token after migrating: %v, %v", id, err)
+	}
+}
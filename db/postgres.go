@@ -0,0 +1,381 @@
+package db
+
+// postgresMigrations mirrors sqliteMigrations for PostgreSQL. Versions must
+// stay aligned so both backends describe the same logical schema.
+var postgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up: `
+CREATE TABLE IF NOT EXISTS categories (
+	category_id SERIAL PRIMARY KEY,
+	category TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS users (
+	user_id UUID PRIMARY KEY NOT NULL,
+	username TEXT NOT NULL UNIQUE,
+	age INTEGER,
+	gender TEXT,
+	firstname TEXT NOT NULL,
+	lastname TEXT NOT NULL,
+	email TEXT NOT NULL UNIQUE,
+	password TEXT DEFAULT NULL
+);
+CREATE TABLE IF NOT EXISTS posts (
+	post_id UUID PRIMARY KEY NOT NULL,
+	user_id UUID NOT NULL REFERENCES users(user_id),
+	subject TEXT NOT NULL,
+	content TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS comments (
+	comment_id UUID PRIMARY KEY NOT NULL,
+	post_id UUID NOT NULL REFERENCES posts(post_id),
+	user_id UUID NOT NULL REFERENCES users(user_id),
+	content TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS likes (
+	like_id SERIAL PRIMARY KEY,
+	post_id UUID REFERENCES posts(post_id),
+	comment_id UUID REFERENCES comments(comment_id),
+	user_id UUID NOT NULL REFERENCES users(user_id),
+	type TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS post_categories (
+	post_id UUID NOT NULL REFERENCES posts(post_id),
+	category_id INTEGER NOT NULL REFERENCES categories(category_id)
+);
+CREATE TABLE IF NOT EXISTS sessions (
+	session_id SERIAL PRIMARY KEY,
+	token TEXT NOT NULL,
+	user_id UUID NOT NULL REFERENCES users(user_id),
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS messages (
+	message_id UUID PRIMARY KEY NOT NULL,
+	sender_id UUID NOT NULL REFERENCES users(user_id),
+	receiver_id UUID NOT NULL REFERENCES users(user_id),
+	content TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	is_read BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE TABLE IF NOT EXISTS user_status (
+	user_id UUID PRIMARY KEY NOT NULL REFERENCES users(user_id),
+	is_online BOOLEAN NOT NULL DEFAULT FALSE,
+	last_activity TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);`,
+		Down: `
+DROP TABLE IF EXISTS user_status;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS post_categories;
+DROP TABLE IF EXISTS likes;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS categories;`,
+	},
+	{
+		Version: 2,
+		Name:    "full-text search",
+		Up: `
+CREATE INDEX IF NOT EXISTS posts_search_idx ON posts USING GIN (to_tsvector('simple', subject || ' ' || content));
+CREATE INDEX IF NOT EXISTS comments_search_idx ON comments USING GIN (to_tsvector('simple', content));
+CREATE INDEX IF NOT EXISTS messages_search_idx ON messages USING GIN (to_tsvector('simple', content));`,
+		Down: `
+DROP INDEX IF EXISTS messages_search_idx;
+DROP INDEX IF EXISTS comments_search_idx;
+DROP INDEX IF EXISTS posts_search_idx;`,
+	},
+	{
+		Version: 3,
+		Name:    "roles, edits and revisions",
+		Up: `
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE posts ADD COLUMN edited_at TIMESTAMPTZ;
+ALTER TABLE posts ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE comments ADD COLUMN edited_at TIMESTAMPTZ;
+ALTER TABLE comments ADD COLUMN deleted_at TIMESTAMPTZ;
+CREATE TABLE IF NOT EXISTS revisions (
+	revision_id SERIAL PRIMARY KEY,
+	post_id UUID REFERENCES posts(post_id),
+	comment_id UUID REFERENCES comments(comment_id),
+	editor_id UUID NOT NULL REFERENCES users(user_id),
+	subject TEXT,
+	content TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS revisions_post_idx ON revisions(post_id);
+CREATE INDEX IF NOT EXISTS revisions_comment_idx ON revisions(comment_id);`,
+		Down: `
+DROP TABLE IF EXISTS revisions;
+ALTER TABLE comments DROP COLUMN deleted_at;
+ALTER TABLE comments DROP COLUMN edited_at;
+ALTER TABLE posts DROP COLUMN deleted_at;
+ALTER TABLE posts DROP COLUMN edited_at;
+ALTER TABLE users DROP COLUMN role;`,
+	},
+	{
+		Version: 4,
+		Name:    "threaded comments",
+		Up: `
+ALTER TABLE comments ADD COLUMN parent_comment_id UUID REFERENCES comments(comment_id);
+CREATE INDEX IF NOT EXISTS comments_parent_idx ON comments(parent_comment_id);`,
+		Down: `
+DROP INDEX IF EXISTS comments_parent_idx;
+ALTER TABLE comments DROP COLUMN parent_comment_id;`,
+	},
+	{
+		Version: 5,
+		Name:    "one reaction per user",
+		Up: `
+DELETE FROM likes WHERE post_id IS NOT NULL AND like_id NOT IN (
+	SELECT MAX(like_id) FROM likes WHERE post_id IS NOT NULL GROUP BY user_id, post_id
+);
+DELETE FROM likes WHERE comment_id IS NOT NULL AND like_id NOT IN (
+	SELECT MAX(like_id) FROM likes WHERE comment_id IS NOT NULL GROUP BY user_id, comment_id
+);
+CREATE UNIQUE INDEX IF NOT EXISTS likes_user_post_idx ON likes(user_id, post_id);
+CREATE UNIQUE INDEX IF NOT EXISTS likes_user_comment_idx ON likes(user_id, comment_id);`,
+		Down: `
+DROP INDEX IF EXISTS likes_user_comment_idx;
+DROP INDEX IF EXISTS likes_user_post_idx;`,
+	},
+	{
+		Version: 6,
+		Name:    "reaction types",
+		Up: `
+CREATE TABLE IF NOT EXISTS reaction_types (
+	reaction_type_id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	emoji TEXT NOT NULL,
+	position INTEGER NOT NULL DEFAULT 0,
+	enabled BOOLEAN NOT NULL DEFAULT TRUE
+);
+INSERT INTO reaction_types (name, emoji, position) VALUES
+	('like', '👍', 1),
+	('dislike', '👎', 2),
+	('love', '❤️', 3),
+	('laugh', '😂', 4),
+	('wow', '😮', 5);
+ALTER TABLE likes ADD COLUMN reaction_type_id INTEGER REFERENCES reaction_types(reaction_type_id);
+UPDATE likes SET reaction_type_id = rt.reaction_type_id FROM reaction_types rt WHERE rt.name = likes.type;
+DELETE FROM likes WHERE reaction_type_id IS NULL;
+ALTER TABLE likes ALTER COLUMN reaction_type_id SET NOT NULL;
+ALTER TABLE likes DROP COLUMN type;`,
+		Down: `
+ALTER TABLE likes ADD COLUMN type TEXT;
+UPDATE likes SET type = rt.name FROM reaction_types rt WHERE rt.reaction_type_id = likes.reaction_type_id;
+DELETE FROM likes WHERE type NOT IN ('like', 'dislike');
+ALTER TABLE likes ALTER COLUMN type SET NOT NULL;
+ALTER TABLE likes DROP COLUMN reaction_type_id;
+DROP TABLE IF EXISTS reaction_types;`,
+	},
+	{
+		Version: 7,
+		Name:    "session indexes",
+		Up: `
+CREATE UNIQUE INDEX IF NOT EXISTS sessions_token_idx ON sessions(token);
+CREATE INDEX IF NOT EXISTS sessions_expires_idx ON sessions(expires_at);`,
+		Down: `
+DROP INDEX IF EXISTS sessions_expires_idx;
+DROP INDEX IF EXISTS sessions_token_idx;`,
+	},
+	{
+		Version: 8,
+		Name:    "session devices",
+		Up: `
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN last_seen_at TIMESTAMPTZ;
+UPDATE sessions SET last_seen_at = created_at;
+CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions(user_id);`,
+		Down: `
+DROP INDEX IF EXISTS sessions_user_idx;
+ALTER TABLE sessions DROP COLUMN last_seen_at;
+ALTER TABLE sessions DROP COLUMN ip;
+ALTER TABLE sessions DROP COLUMN user_agent;`,
+	},
+	{
+		Version: 9,
+		Name:    "password resets",
+		Up: `
+CREATE TABLE IF NOT EXISTS password_resets (
+	token_hash TEXT PRIMARY KEY NOT NULL,
+	user_id UUID NOT NULL REFERENCES users(user_id),
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT NOT NULL,
+	used_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS password_resets_user_idx ON password_resets(user_id);`,
+		Down: `
+DROP TABLE IF EXISTS password_resets;`,
+	},
+	{
+		Version: 10,
+		Name:    "email verification",
+		Up: `
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+UPDATE users SET email_verified_at = CURRENT_TIMESTAMP;
+CREATE TABLE IF NOT EXISTS email_verifications (
+	token_hash TEXT PRIMARY KEY NOT NULL,
+	user_id UUID NOT NULL REFERENCES users(user_id),
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT NOT NULL,
+	used_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS email_verifications_user_idx ON email_verifications(user_id);`,
+		Down: `
+DROP TABLE IF EXISTS email_verifications;
+ALTER TABLE users DROP COLUMN email_verified_at;`,
+	},
+	{
+		Version: 11,
+		Name:    "two factor",
+		Up: `
+CREATE TABLE IF NOT EXISTS two_factor (
+	user_id UUID PRIMARY KEY NOT NULL REFERENCES users(user_id),
+	secret TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	enabled_at TIMESTAMPTZ,
+	last_step BIGINT NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	code_hash TEXT PRIMARY KEY NOT NULL,
+	user_id UUID NOT NULL REFERENCES users(user_id),
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	used_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS recovery_codes_user_idx ON recovery_codes(user_id);
+CREATE TABLE IF NOT EXISTS pending_logins (
+	token_hash TEXT PRIMARY KEY NOT NULL,
+	user_id UUID NOT NULL REFERENCES users(user_id),
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS role_policies (
+	role TEXT PRIMARY KEY NOT NULL,
+	require_2fa BOOLEAN NOT NULL DEFAULT FALSE
+);`,
+		Down: `
+DROP TABLE IF EXISTS role_policies;
+DROP TABLE IF EXISTS pending_logins;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS two_factor;`,
+	},
+	{
+		Version: 12,
+		Name:    "case-insensitive users",
+		Up: `
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_idx ON users(LOWER(username));
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx ON users(LOWER(email));`,
+		Down: `
+DROP INDEX IF EXISTS users_email_lower_idx;
+DROP INDEX IF EXISTS users_username_lower_idx;`,
+	},
+	{
+		Version: 13,
+		Name:    "login events",
+		Up: `
+CREATE TABLE IF NOT EXISTS login_events (
+	event_id SERIAL PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(user_id),
+	success BOOLEAN NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS login_events_user_idx ON login_events(user_id, created_at);
+CREATE TABLE IF NOT EXISTS account_lockouts (
+	user_id UUID PRIMARY KEY NOT NULL REFERENCES users(user_id),
+	failed_attempts INTEGER NOT NULL DEFAULT 0,
+	locked_until BIGINT NOT NULL DEFAULT 0
+);`,
+		Down: `
+DROP TABLE IF EXISTS account_lockouts;
+DROP TABLE IF EXISTS login_events;`,
+	},
+	{
+		Version: 14,
+		Name:    "api tokens",
+		Up: `
+CREATE TABLE IF NOT EXISTS api_tokens (
+	token_id SERIAL PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(user_id),
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT,
+	last_used_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS api_tokens_user_idx ON api_tokens(user_id);`,
+		Down: `
+DROP TABLE IF EXISTS api_tokens;`,
+	},
+	{
+		Version: 15,
+		Name:    "oidc identities",
+		Up: `
+CREATE TABLE IF NOT EXISTS user_identities (
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	user_id UUID NOT NULL REFERENCES users(user_id),
+	email TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (provider, subject)
+);
+CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities(user_id);
+CREATE TABLE IF NOT EXISTS oidc_states (
+	state_hash TEXT PRIMARY KEY NOT NULL,
+	provider TEXT NOT NULL,
+	nonce TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT NOT NULL
+);`,
+		Down: `
+DROP TABLE IF EXISTS oidc_states;
+DROP TABLE IF EXISTS user_identities;`,
+	},
+	{
+		Version: 16,
+		Name:    "csrf tokens",
+		Up: `
+CREATE TABLE IF NOT EXISTS csrf_tokens (
+	token_hash TEXT PRIMARY KEY NOT NULL,
+	key_hash TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS csrf_tokens_key_idx ON csrf_tokens(key_hash);
+CREATE INDEX IF NOT EXISTS csrf_tokens_expires_idx ON csrf_tokens(expires_at);`,
+		Down: `
+DROP TABLE IF EXISTS csrf_tokens;`,
+	},
+	{
+		Version: 17,
+		Name:    "rate limits",
+		Up: `
+CREATE TABLE IF NOT EXISTS rate_limits (
+	bucket_key TEXT PRIMARY KEY NOT NULL,
+	tat BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS rate_limits_tat_idx ON rate_limits(tat);`,
+		Down: `
+DROP TABLE IF EXISTS rate_limits;`,
+	},
+	{
+		Version: 18,
+		Name:    "password reset unix times",
+		Up: `
+ALTER TABLE password_resets ALTER COLUMN used_at TYPE BIGINT USING EXTRACT(EPOCH FROM used_at)::BIGINT;`,
+		Down: `
+ALTER TABLE password_resets ALTER COLUMN used_at TYPE TIMESTAMPTZ USING to_timestamp(used_at);`,
+	},
+}
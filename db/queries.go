@@ -1,18 +1,22 @@
 package db
 
 import (
-	"database/sql"
 	"errors"
-	"fmt"
 	"log"
 	"strings"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
-	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Errors returned by RegisterUser when the username or email is already
+// registered, ignoring case
+var (
+	ErrUsernameTaken = errors.New("username is already taken")
+	ErrEmailTaken    = errors.New("email is already registered")
+)
+
 // allowedUserFields is a whitelist of allowed field names for user queries
 var allowedUserFields = map[string]bool{
 	"username": true,
@@ -107,51 +111,80 @@ CREATE TABLE IF NOT EXISTS user_status (
 	FOREIGN KEY(user_id) REFERENCES users(user_id)
 );`
 
-var DB *sql.DB
+// SQLStore implements Store on top of database/sql. The same queries are
+// used for SQLite and PostgreSQL; see Open.
+type SQLStore struct {
+	db *conn
+	// fts5 is set when the SQLite driver was built with FTS5
+	fts5 bool
+}
 
-func ConnectDatabase() error {
-	db, err := sql.Open("sqlite3", "./db/database.db")
-	if err != nil {
-		log.Fatal(err)
-	}
-	DB = db
-	_, err = DB.Exec(createtables)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return nil
+// Close closes the underlying database connection
+func (s *SQLStore) Close() error {
+	return s.db.Close()
 }
-func RegisterUser(data []interface{}) ([]User, error) {
-	userList := []User{}
-	if DB == nil {
-		return nil, fmt.Errorf("db connection failed")
-	}
-	stmt, err := DB.Prepare(`INSERT INTO users (user_id, username, age, gender, firstname, lastname, email, password) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+
+// RegisterUser creates an unverified account and returns its ID
+func (s *SQLStore) RegisterUser(user User) (uuid.UUID, error) {
+	stmt, err := s.db.Prepare(`INSERT INTO users (user_id, username, age, gender, firstname, lastname, email, password) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		log.Println("Prepare statement error:", err)
-		return nil, err
+		return uuid.Nil, err
 	}
 	defer stmt.Close()
 	userID, err := uuid.NewV4()
 	if err != nil {
 		log.Println("UUID generation error:", err)
-		return nil, err
+		return uuid.Nil, err
 	}
-	data = append([]interface{}{userID.String()}, data...)
-	_, err = stmt.Exec(data...)
-	if err != nil {
+	_, err = stmt.Exec(userID.String(), user.Username, user.Age, user.Gender, user.FirstName, user.LastName, user.Email, user.Password)
+	switch {
+	case uniqueViolation(err, "users_username_lower_idx", "users.username", "users_username_key"):
+		return uuid.Nil, ErrUsernameTaken
+	case uniqueViolation(err, "users_email_lower_idx", "users.email", "users_email_key"):
+		return uuid.Nil, ErrEmailTaken
+	case err != nil:
 		log.Println("Exec statement error:", err)
-		return nil, err
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+// CheckUserAvailability reports whether username and email are already
+// registered, ignoring case
+func (s *SQLStore) CheckUserAvailability(username, email string) (usernameTaken, emailTaken bool, err error) {
+	err = s.db.QueryRow(`
+        SELECT
+            EXISTS (SELECT 1 FROM users WHERE LOWER(username) = LOWER(?)),
+            EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER(?))`, username, email).Scan(&usernameTaken, &emailTaken)
+	return usernameTaken, emailTaken, err
+}
+
+// uniqueViolation reports whether err is a unique constraint failure on one
+// of the given indexes or columns. SQLite and Postgres both name them in the
+// error message.
+func uniqueViolation(err error, names ...string) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "UNIQUE constraint failed") && !strings.Contains(msg, "duplicate key") {
+		return false
+	}
+	for _, name := range names {
+		if strings.Contains(msg, name) {
+			return true
+		}
 	}
-	return userList, nil
+	return false
 }
-func LoginUser(db *sql.DB, usernameOrEmail, password string) (Login, error) {
+func (s *SQLStore) LoginUser(usernameOrEmail, password string) (Login, error) {
 	var login Login
 	fieldname, err := getUserFieldName(usernameOrEmail)
 	if err != nil {
 		return login, errors.New("invalid login field")
 	}
-	err = db.QueryRow("SELECT username, email, password FROM users WHERE "+fieldname+" = ?", usernameOrEmail).Scan(&login.Username, &login.Email, &login.Password)
+	err = s.db.QueryRow("SELECT username, email, password FROM users WHERE LOWER("+fieldname+") = LOWER(?)", usernameOrEmail).Scan(&login.Username, &login.Email, &login.Password)
 	if err != nil {
 		return login, errors.New("can't find username or email")
 	}
@@ -161,8 +194,8 @@ func LoginUser(db *sql.DB, usernameOrEmail, password string) (Login, error) {
 	}
 	return login, nil
 }
-func CreatePostDB(db *sql.DB, userID uuid.UUID, subject, content string, categoryIDs []int, createdAt time.Time) error {
-	tx, err := db.Begin()
+func (s *SQLStore) CreatePost(userID uuid.UUID, subject, content string, categoryIDs []int, createdAt time.Time) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
@@ -185,78 +218,212 @@ func CreatePostDB(db *sql.DB, userID uuid.UUID, subject, content string, categor
 	return tx.Commit()
 }
 
-func GetPosts() ([]Post, error) {
-	rows, err := DB.Query(`
-        SELECT p.post_id, p.user_id, p.subject, p.content, p.created_at, 
-               COALESCE(SUM(CASE WHEN pr.type = 'like' THEN 1 ELSE 0 END), 0) AS like_count,
-               COALESCE(SUM(CASE WHEN pr.type = 'dislike' THEN 1 ELSE 0 END), 0) AS dislike_count
+const (
+	// DefaultPostPageSize is used when a feed request does not set a limit
+	DefaultPostPageSize = 20
+	// MaxPostPageSize caps the number of posts returned per page
+	MaxPostPageSize = 100
+)
+
+// userColumns selects the fields of a user joined as "u"
+const userColumns = `u.user_id, u.username, u.firstname, u.lastname, u.age, u.gender, u.email, u.role, u.email_verified_at`
+
+// authorColumns selects the public fields of an author joined as "u"
+const authorColumns = `u.user_id, u.username, u.role`
+
+// postQuery selects posts joined with their author and reaction counts.
+// Callers append an optional WHERE clause followed by postGroupBy.
+const postQuery = `
+        SELECT p.post_id, p.user_id, p.subject, p.content, p.created_at, p.edited_at,
+               p.deleted_at IS NOT NULL,
+               ` + authorColumns + `,
+               COALESCE(SUM(CASE WHEN prt.name = 'like' THEN 1 ELSE 0 END), 0) AS like_count,
+               COALESCE(SUM(CASE WHEN prt.name = 'dislike' THEN 1 ELSE 0 END), 0) AS dislike_count,
+               (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.post_id) AS comment_count
         FROM posts p
+        JOIN users u ON p.user_id = u.user_id
         LEFT JOIN likes pr ON p.post_id = pr.post_id
-        GROUP BY p.post_id
-        ORDER BY p.created_at DESC`)
+        LEFT JOIN reaction_types prt ON prt.reaction_type_id = pr.reaction_type_id`
+
+const postGroupBy = `
+        GROUP BY p.post_id, u.user_id`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// userScanDest returns scan destinations matching userColumns
+func userScanDest(u *User) []interface{} {
+	return []interface{}{&u.Id, &u.Username, &u.FirstName, &u.LastName, &u.Age, &u.Gender, &u.Email, &u.Role, &u.EmailVerifiedAt}
+}
+
+// authorScanDest returns scan destinations matching authorColumns
+func authorScanDest(a *Author) []interface{} {
+	return []interface{}{&a.Id, &a.Username, &a.Role}
+}
+
+// scanPost scans a row selected with postQuery
+func scanPost(row rowScanner) (Post, error) {
+	var p Post
+	u := &Author{}
+	dest := []interface{}{&p.ID, &p.UserID, &p.Subject, &p.Content, &p.CreatedAt, &p.EditedAt, &p.Deleted}
+	dest = append(dest, authorScanDest(u)...)
+	dest = append(dest, &p.LikeCount, &p.DislikeCount, &p.CommentCount)
+	if err := row.Scan(dest...); err != nil {
+		return p, err
+	}
+	p.User = u
+	return p, nil
+}
+
+// GetPosts returns one page of the feed, newest first, ordered by
+// (created_at, post_id) so the cursor is stable. Posts carry their author,
+// categories, reaction counts and comment count but not the comments
+// themselves. The returned cursor is nil on the last page.
+func (s *SQLStore) GetPosts(filter PostFilter) ([]Post, *PostCursor, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultPostPageSize
+	}
+	if filter.Limit > MaxPostPageSize {
+		filter.Limit = MaxPostPageSize
+	}
+
+	conds := []string{"p.deleted_at IS NULL"}
+	var args []interface{}
+	if filter.After != nil {
+		conds = append(conds, "(p.created_at < ? OR (p.created_at = ? AND p.post_id < ?))")
+		args = append(args, filter.After.CreatedAt, filter.After.CreatedAt, filter.After.PostID)
+	}
+	if filter.CategoryID != 0 {
+		conds = append(conds, "EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = p.post_id AND pc.category_id = ?)")
+		args = append(args, filter.CategoryID)
+	}
+	if filter.Author != "" {
+		conds = append(conds, "u.username = ?")
+		args = append(args, filter.Author)
+	}
+	if !filter.From.IsZero() {
+		conds = append(conds, "p.created_at >= ?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conds = append(conds, "p.created_at < ?")
+		args = append(args, filter.To)
+	}
+	if filter.ReactedBy != uuid.Nil {
+		conds = append(conds, "EXISTS (SELECT 1 FROM likes l WHERE l.post_id = p.post_id AND l.user_id = ?)")
+		args = append(args, filter.ReactedBy)
+	}
+	where := "WHERE " + strings.Join(conds, " AND ")
+	// Fetch one extra row to know whether another page follows
+	args = append(args, filter.Limit+1)
+
+	rows, err := s.db.Query(postQuery+`
+        `+where+postGroupBy+`
+        ORDER BY p.created_at DESC, p.post_id DESC
+        LIMIT ?`, args...)
 	if err != nil {
 		log.Printf("Error executing query: %v", err)
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	var posts []Post
 	for rows.Next() {
-		var p Post
-		err := rows.Scan(&p.ID, &p.UserID, &p.Subject, &p.Content, &p.CreatedAt, &p.LikeCount, &p.DislikeCount)
+		p, err := scanPost(rows)
 		if err != nil {
 			log.Printf("Error scanning row: %v", err)
-			return nil, err
+			return nil, nil, err
 		}
-
-		user, err := GetUserByID(p.UserID)
-		if err != nil {
-			log.Printf("Error getting user by ID: %v", err)
-			return nil, err
-		}
-		p.User = user
-
-		categories, err := GetPostCategories(p.ID)
-		if err != nil {
-			log.Printf("Error getting post categories: %v", err)
-			return nil, err
-		}
-		p.Categories = convertToCategoryPointers(categories)
-
-		comments, err := GetComments(p.ID)
-		if err != nil {
-			log.Printf("Error getting comments: %v", err)
-			return nil, err
-		}
-		p.Comments = convertToCommentPointers(comments)
-
 		posts = append(posts, p)
 	}
-
 	if err = rows.Err(); err != nil {
 		log.Printf("Error iterating over rows: %v", err)
-		return nil, err
+		return nil, nil, err
+	}
+
+	var next *PostCursor
+	if len(posts) > filter.Limit {
+		posts = posts[:filter.Limit]
+		last := posts[len(posts)-1]
+		next = &PostCursor{CreatedAt: last.CreatedAt, PostID: last.ID}
 	}
 
-	return posts, nil
+	if err := s.loadCategories(posts); err != nil {
+		log.Printf("Error getting post categories: %v", err)
+		return nil, nil, err
+	}
+	if err := s.loadPostReactionCounts(posts); err != nil {
+		log.Printf("Error getting reaction counts: %v", err)
+		return nil, nil, err
+	}
+	if err := s.loadPostReactions(filter.Viewer, posts); err != nil {
+		log.Printf("Error getting user reactions: %v", err)
+		return nil, nil, err
+	}
+	return posts, next, nil
 }
 
-func convertToCategoryPointers(categories []Category) []*Category {
-	var categoryPointers []*Category
-	for i := range categories {
-		categoryPointers = append(categoryPointers, &categories[i])
+// loadCategories attaches categories to posts with a single query
+func (s *SQLStore) loadCategories(posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	byID := make(map[uuid.UUID]*Post, len(posts))
+	ids := make([]interface{}, len(posts))
+	for i := range posts {
+		byID[posts[i].ID] = &posts[i]
+		ids[i] = posts[i].ID
+	}
+	rows, err := s.db.Query(`
+        SELECT pc.post_id, c.category_id, c.category
+        FROM post_categories pc
+        JOIN categories c ON c.category_id = pc.category_id
+        WHERE pc.post_id IN (`+placeholders(len(ids))+`)
+        ORDER BY c.category_id`, ids...)
+	if err != nil {
+		return err
 	}
-	return categoryPointers
+	defer rows.Close()
+	for rows.Next() {
+		var postID uuid.UUID
+		c := &Category{}
+		if err := rows.Scan(&postID, &c.ID, &c.Name); err != nil {
+			return err
+		}
+		if p, ok := byID[postID]; ok {
+			p.Categories = append(p.Categories, c)
+		}
+	}
+	return rows.Err()
 }
-func convertToCommentPointers(comments []Comment) []*Comment {
-	var commentPointers []*Comment
-	for i := range comments {
-		commentPointers = append(commentPointers, &comments[i])
+
+// placeholders returns n comma separated "?" placeholders
+func placeholders(n int) string {
+	if n == 0 {
+		return "NULL"
 	}
-	return commentPointers
+	return strings.Repeat("?, ", n-1) + "?"
 }
-func GetPostCategories(postID uuid.UUID) ([]Category, error) {
-	rows, err := DB.Query(`
+
+// idBatchSize caps the number of IDs bound into a single IN clause. A post
+// can have any number of comments, and older SQLite builds allow only 999
+// parameters per statement.
+const idBatchSize = 500
+
+// inBatches calls fn with consecutive batches of at most idBatchSize ids
+func inBatches(ids []interface{}, fn func(batch []interface{}) error) error {
+	for start := 0; start < len(ids); start += idBatchSize {
+		if err := fn(ids[start:min(start+idBatchSize, len(ids))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) GetPostCategories(postID uuid.UUID) ([]Category, error) {
+	rows, err := s.db.Query(`
         SELECT c.category_id, c.category
         FROM categories c
         JOIN post_categories pc ON c.category_id = pc.category_id
@@ -276,21 +443,50 @@ func GetPostCategories(postID uuid.UUID) ([]Category, error) {
 	}
 	return categories, nil
 }
-func CreateComment(postID, userID uuid.UUID, content string) error {
-	_, err := DB.Exec("INSERT INTO comments (comment_id, post_id, user_id, content, created_at) VALUES (?, ?, ?, ?, ?)", uuid.Must(uuid.NewV4()), postID, userID, content, time.Now())
-	return err
+
+// CreateComment adds a comment to a post, optionally as a reply to
+// parentID, and returns the new comment's ID
+func (s *SQLStore) CreateComment(postID, userID uuid.UUID, parentID *uuid.UUID, content string) (uuid.UUID, error) {
+	commentID, err := uuid.NewV4()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	_, err = s.db.Exec("INSERT INTO comments (comment_id, post_id, parent_comment_id, user_id, content, created_at) VALUES (?, ?, ?, ?, ?, ?)", commentID, postID, parentID, userID, content, time.Now())
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return commentID, nil
 }
-func GetComments(postID uuid.UUID) ([]Comment, error) {
-	rows, err := DB.Query(`
-        SELECT c.comment_id, c.user_id, u.username, c.content, c.created_at,
-               COALESCE(SUM(CASE WHEN cr.type = 'like' THEN 1 ELSE 0 END), 0) AS like_count,
-               COALESCE(SUM(CASE WHEN cr.type = 'dislike' THEN 1 ELSE 0 END), 0) AS dislike_count
+
+// GetComments returns all comments of a post. When viewer is not uuid.Nil
+// each comment carries the viewer's own reaction.
+func (s *SQLStore) GetComments(postID, viewer uuid.UUID) ([]Comment, error) {
+	comments, err := s.queryComments("c.post_id = ?", postID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadCommentReactions(viewer, comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// queryComments returns the comments matching where, oldest first, with
+// their authors and reaction counts
+func (s *SQLStore) queryComments(where string, args ...interface{}) ([]Comment, error) {
+	rows, err := s.db.Query(`
+        SELECT c.comment_id, c.post_id, c.parent_comment_id, c.user_id, c.content, c.created_at, c.edited_at,
+               c.deleted_at IS NOT NULL,
+               `+authorColumns+`,
+               COALESCE(SUM(CASE WHEN crt.name = 'like' THEN 1 ELSE 0 END), 0) AS like_count,
+               COALESCE(SUM(CASE WHEN crt.name = 'dislike' THEN 1 ELSE 0 END), 0) AS dislike_count
         FROM comments c
         JOIN users u ON c.user_id = u.user_id
         LEFT JOIN likes cr ON c.comment_id = cr.comment_id
-        WHERE c.post_id = ?
-        GROUP BY c.comment_id
-        ORDER BY c.created_at ASC`, postID)
+        LEFT JOIN reaction_types crt ON crt.reaction_type_id = cr.reaction_type_id
+        WHERE `+where+`
+        GROUP BY c.comment_id, u.user_id
+        ORDER BY c.created_at ASC`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -298,34 +494,38 @@ func GetComments(postID uuid.UUID) ([]Comment, error) {
 	var comments []Comment
 	for rows.Next() {
 		var c Comment
-		err = rows.Scan(&c.ID, &c.UserID, &c.User.Username, &c.Content, &c.CreatedAt, &c.LikeCount, &c.DislikeCount)
-		if err != nil {
-			return nil, err
-		}
-		user, err := GetUserByID(c.UserID)
-		if err != nil {
+		u := &Author{}
+		dest := []interface{}{&c.ID, &c.PostID, &c.ParentID, &c.UserID, &c.Content, &c.CreatedAt, &c.EditedAt, &c.Deleted}
+		dest = append(dest, authorScanDest(u)...)
+		dest = append(dest, &c.LikeCount, &c.DislikeCount)
+		if err = rows.Scan(dest...); err != nil {
 			return nil, err
 		}
-		c.User = user
+		c.User = u
 		comments = append(comments, c)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.loadCommentReactionCounts(comments); err != nil {
+		return nil, err
+	}
 	return comments, nil
 }
-func GetUserByID(userID uuid.UUID) (*User, error) {
+func (s *SQLStore) GetUserByID(userID uuid.UUID) (*User, error) {
 	var user User
-	err := DB.QueryRow("SELECT user_id, username, firstname, lastname, age, gender, email FROM users WHERE user_id = ?", userID).Scan(
-		&user.Id, &user.Username, &user.FirstName, &user.LastName, &user.Age, &user.Gender, &user.Email)
+	err := s.db.QueryRow("SELECT "+userColumns+" FROM users u WHERE u.user_id = ?", userID).Scan(userScanDest(&user)...)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
-func CreateCategory(name string) error {
-	_, err := DB.Exec("INSERT INTO categories (category) VALUES (?)", name)
+func (s *SQLStore) CreateCategory(name string) error {
+	_, err := s.db.Exec("INSERT INTO categories (category) VALUES (?)", name)
 	return err
 }
-func GetCategories() ([]Category, error) {
-	rows, err := DB.Query("SELECT category_id, category FROM categories")
+func (s *SQLStore) GetCategories() ([]Category, error) {
+	rows, err := s.db.Query("SELECT category_id, category FROM categories")
 	if err != nil {
 		return nil, err
 	}
@@ -341,11 +541,8 @@ func GetCategories() ([]Category, error) {
 	}
 	return categories, nil
 }
-func AddMessage(senderID, receiverID uuid.UUID, content string) error {
-	if DB == nil {
-		return fmt.Errorf("db connection failed")
-	}
-	stmt, err := DB.Prepare(`INSERT INTO messages (message_id, sender_id, receiver_id, content, created_at, is_read) VALUES (?, ?, ?, ?, ?, 0)`)
+func (s *SQLStore) AddMessage(senderID, receiverID uuid.UUID, content string) error {
+	stmt, err := s.db.Prepare(`INSERT INTO messages (message_id, sender_id, receiver_id, content, created_at, is_read) VALUES (?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
@@ -354,17 +551,14 @@ func AddMessage(senderID, receiverID uuid.UUID, content string) error {
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(messageID, senderID, receiverID, content, time.Now())
+	_, err = stmt.Exec(messageID, senderID, receiverID, content, time.Now(), false)
 	if err != nil {
 		return err
 	}
 	return nil
 }
-func GetMessages(senderID, receiverID uuid.UUID, limit, offset int) ([]Message, error) {
-	if DB == nil {
-		return nil, fmt.Errorf("db connection failed")
-	}
-	rows, err := DB.Query(`SELECT message_id, sender_id, receiver_id, content, created_at, is_read FROM messages WHERE (sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?) ORDER BY created_at DESC LIMIT ? OFFSET ?`, senderID, receiverID, receiverID, senderID, limit, offset)
+func (s *SQLStore) GetMessages(senderID, receiverID uuid.UUID, limit, offset int) ([]Message, error) {
+	rows, err := s.db.Query(`SELECT message_id, sender_id, receiver_id, content, created_at, is_read FROM messages WHERE (sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?) ORDER BY created_at DESC LIMIT ? OFFSET ?`, senderID, receiverID, receiverID, senderID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -380,11 +574,8 @@ func GetMessages(senderID, receiverID uuid.UUID, limit, offset int) ([]Message,
 	}
 	return messages, nil
 }
-func UpdateUserStatus(userID uuid.UUID, isOnline bool) error {
-	if DB == nil {
-		return fmt.Errorf("db connection failed")
-	}
-	stmt, err := DB.Prepare(`INSERT INTO user_status (user_id, is_online) VALUES (?, ?) ON CONFLICT(user_id) DO UPDATE SET is_online = ?, last_activity = CURRENT_TIMESTAMP`)
+func (s *SQLStore) UpdateUserStatus(userID uuid.UUID, isOnline bool) error {
+	stmt, err := s.db.Prepare(`INSERT INTO user_status (user_id, is_online) VALUES (?, ?) ON CONFLICT(user_id) DO UPDATE SET is_online = ?, last_activity = CURRENT_TIMESTAMP`)
 	if err != nil {
 		return err
 	}
@@ -395,11 +586,8 @@ func UpdateUserStatus(userID uuid.UUID, isOnline bool) error {
 	}
 	return nil
 }
-func GetUserStatus() ([]UserStatus, error) {
-	if DB == nil {
-		return nil, fmt.Errorf("db connection failed")
-	}
-	rows, err := DB.Query(`SELECT user_id, is_online, last_activity FROM user_status`)
+func (s *SQLStore) GetUserStatus() ([]UserStatus, error) {
+	rows, err := s.db.Query(`SELECT user_id, is_online, last_activity FROM user_status`)
 	if err != nil {
 		return nil, err
 	}
@@ -415,16 +603,13 @@ func GetUserStatus() ([]UserStatus, error) {
 	}
 	return statuses, nil
 }
-func GetUsersOrderedByLastMessageOrAlphabetically() ([]User, error) {
-	if DB == nil {
-		return nil, fmt.Errorf("db connection failed")
-	}
-	rows, err := DB.Query(`
-	SELECT users.user_id, users.username, MAX(messages.created_at) AS last_message_time
+func (s *SQLStore) GetUsersOrderedByLastMessageOrAlphabetically() ([]User, error) {
+	rows, err := s.db.Query(`
+	SELECT users.user_id, users.username
 	FROM users
 	LEFT JOIN messages ON users.user_id = messages.sender_id OR users.user_id = messages.receiver_id
 	GROUP BY users.user_id
-	ORDER BY last_message_time DESC, users.username ASC
+	ORDER BY MAX(messages.created_at) DESC NULLS LAST, users.username ASC
 	`)
 	if err != nil {
 		return nil, err
@@ -441,127 +626,25 @@ func GetUsersOrderedByLastMessageOrAlphabetically() ([]User, error) {
 	}
 	return users, nil
 }
-func GetUserIDFromSession(token string) (uuid.UUID, error) {
-	if DB == nil {
-		return uuid.Nil, fmt.Errorf("db connection failed")
-	}
-	var userID uuid.UUID
-	err := DB.QueryRow(`SELECT user_id FROM sessions WHERE token = ?`, token).Scan(&userID)
-	if err != nil {
-		log.Printf("Error finding session token: %v", err)
-		return uuid.Nil, err
-	}
-	log.Printf("Session token found for user ID: %s", userID)
-	return userID, nil
-}
-func MarkMessageAsRead(messageID uuid.UUID, userID uuid.UUID) error {
-	if DB == nil {
-		return fmt.Errorf("db connection failed")
-	}
-	stmt, err := DB.Prepare(`UPDATE messages SET is_read = 1 WHERE message_id = ? AND receiver_id = ?`)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-	_, err = stmt.Exec(messageID, userID)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-func SaveSession(token string, userID uuid.UUID, expiration time.Time) error {
-	if DB == nil {
-		return fmt.Errorf("db connection failed")
-	}
-	stmt, err := DB.Prepare(`INSERT INTO sessions (token, user_id, expires_at) VALUES (?, ?, ?)`)
+func (s *SQLStore) MarkMessageAsRead(messageID uuid.UUID, userID uuid.UUID) error {
+	stmt, err := s.db.Prepare(`UPDATE messages SET is_read = ? WHERE message_id = ? AND receiver_id = ?`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(token, userID, expiration.Unix())
+	_, err = stmt.Exec(true, messageID, userID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
-func DeleteSession(token string) error {
-	if DB == nil {
-		return fmt.Errorf("db connection failed")
-	}
-	stmt, err := DB.Prepare(`DELETE FROM sessions WHERE token = ?`)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-	_, err = stmt.Exec(token)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-func GetUserID(usernameOrEmail string, db *sql.DB) (uuid.UUID, error) {
-	var userID uuid.UUID
-	fieldname, err := getUserFieldName(usernameOrEmail)
-	if err != nil {
-		return uuid.Nil, err
-	}
-	err = db.QueryRow("SELECT user_id FROM users WHERE "+fieldname+" = ?", usernameOrEmail).Scan(&userID)
-	if err != nil {
-		return uuid.Nil, err
-	}
-	return userID, nil
-}
-
-// add reaction from socket
-func AddPostReaction(userID, postID uuid.UUID, reactionType ReactionType) error {
-	_, err := DB.Exec("INSERT INTO likes (user_id, post_id, type, created_at) VALUES (?, ?, ?, ?)", userID, postID, reactionType, time.Now())
-	return err
-}
-func AddCommentReaction(userID, commentID uuid.UUID, reactionType ReactionType) error {
-	_, err := DB.Exec("INSERT INTO likes (user_id, comment_id, type, created_at) VALUES (?, ?, ?, ?)", userID, commentID, reactionType, time.Now())
-	return err
-}
-func GetPostReactions(postID uuid.UUID) ([]Reaction, error) {
-	rows, err := DB.Query("SELECT user_id, post_id, type FROM likes WHERE post_id = ?", postID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var reactions []Reaction
-	for rows.Next() {
-		var r Reaction
-		err := rows.Scan(&r.UserID, &r.PostID, &r.Type)
-		if err != nil {
-			return nil, err
-		}
-		reactions = append(reactions, r)
-	}
-	return reactions, nil
-}
-func GetCommentReactions(commentID uuid.UUID) ([]Reaction, error) {
-	rows, err := DB.Query("SELECT user_id, comment_id, type FROM likes WHERE comment_id = ?", commentID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var reactions []Reaction
-	for rows.Next() {
-		var r Reaction
-		err := rows.Scan(&r.UserID, &r.CommentID, &r.Type)
-		if err != nil {
-			return nil, err
-		}
-		reactions = append(reactions, r)
-	}
-	return reactions, nil
-}
-func GetUserIDByUsernameOrEmail(usernameOrEmail string) (uuid.UUID, error) {
+func (s *SQLStore) GetUserIDByUsernameOrEmail(usernameOrEmail string) (uuid.UUID, error) {
 	var userID uuid.UUID
 	fieldname, err := getUserFieldName(usernameOrEmail)
 	if err != nil {
 		return uuid.Nil, err
 	}
-	err = DB.QueryRow("SELECT user_id FROM users WHERE "+fieldname+" = ?", usernameOrEmail).Scan(&userID)
+	err = s.db.QueryRow("SELECT user_id FROM users WHERE LOWER("+fieldname+") = LOWER(?)", usernameOrEmail).Scan(&userID)
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -569,9 +652,9 @@ func GetUserIDByUsernameOrEmail(usernameOrEmail string) (uuid.UUID, error) {
 }
 
 // GetCategoryByID returns a category by its ID
-func GetCategoryByID(id int) (*Category, error) {
+func (s *SQLStore) GetCategoryByID(id int) (*Category, error) {
 	var category Category
-	err := DB.QueryRow("SELECT category_id, category FROM categories WHERE category_id = ?", id).Scan(&category.ID, &category.Name)
+	err := s.db.QueryRow("SELECT category_id, category FROM categories WHERE category_id = ?", id).Scan(&category.ID, &category.Name)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/mattn/go-sqlite3"
+)
+
+// statements counts the statements run through the sqlite3_counting driver
+var statements atomic.Int64
+
+func init() {
+	sql.Register("sqlite3_counting", countingDriver{&sqlite3.SQLiteDriver{}})
+}
+
+// countingDriver wraps the SQLite driver and counts the statements run
+type countingDriver struct {
+	driver.Driver
+}
+
+func (d countingDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{c.(*sqlite3.SQLiteConn)}, nil
+}
+
+type countingConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c countingConn) Prepare(query string) (driver.Stmt, error) {
+	statements.Add(1)
+	return c.SQLiteConn.Prepare(query)
+}
+
+func (c countingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	statements.Add(1)
+	return c.SQLiteConn.PrepareContext(ctx, query)
+}
+
+func (c countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	statements.Add(1)
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+func (c countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	statements.Add(1)
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+// newCountingStore opens a migrated SQLite store whose statements are
+// counted in statements
+func newCountingStore(tb testing.TB) *SQLStore {
+	tb.Helper()
+	s, err := openSQLite("sqlite3_counting", filepath.Join(tb.TempDir(), "forum.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { s.Close() })
+	if err := s.Migrate(); err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+// seedForum creates two users, a category and n posts, each with a comment
+// and a reaction from both users
+func seedForum(tb testing.TB, s *SQLStore, n int) []uuid.UUID {
+	tb.Helper()
+	if err := s.CreateCategory("General"); err != nil {
+		tb.Fatal(err)
+	}
+	var users []uuid.UUID
+	for _, name := range []string{"alice", "bob"} {
+		id, err := s.RegisterUser(User{Username: name, Email: name + "@example.com", Age: 30, Gender: "other", Password: "x"})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		users = append(users, id)
+	}
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < n; i++ {
+		if err := s.CreatePost(users[0], fmt.Sprintf("Post %d", i), "content", []int{1}, start.Add(time.Duration(i)*time.Second)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	posts, _, err := s.GetPosts(PostFilter{Limit: MaxPostPageSize})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, p := range posts {
+		if _, err := s.CreateComment(p.ID, users[1], nil, "a comment"); err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := s.AddPostReaction(users[0], p.ID, "like"); err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := s.AddPostReaction(users[1], p.ID, "dislike"); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return users
+}
+
+// countStatements returns how many statements fn runs
+func countStatements(fn func()) int64 {
+	before := statements.Load()
+	fn()
+	return statements.Load() - before
+}
+
+func TestGetPostsRunsConstantQueries(t *testing.T) {
+	s := newCountingStore(t)
+	users := seedForum(t, s, 60)
+	var counts []int64
+	for _, limit := range []int{1, 10, 50} {
+		counts = append(counts, countStatements(func() {
+			posts, _, err := s.GetPosts(PostFilter{Limit: limit, Viewer: users[0]})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(posts) != limit || len(posts[0].Categories) != 1 || posts[0].UserReaction != "like" {
+				t.Fatalf("unexpected page: %d posts, first %+v", len(posts), posts[0])
+			}
+		}))
+	}
+	for _, c := range counts[1:] {
+		if c != counts[0] {
+			t.Fatalf("GetPosts ran %v queries for pages of 1, 10 and 50 posts", counts)
+		}
+	}
+}
+
+func TestAuthorsArePublic(t *testing.T) {
+	s := newCountingStore(t)
+	seedForum(t, s, 1)
+	posts, _, err := s.GetPosts(PostFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments, err := s.GetComments(posts[0].ID, uuid.Nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 1 || comments[0].User == nil || comments[0].User.Username != "bob" {
+		t.Fatalf("comments = %+v", comments)
+	}
+	data, err := json.Marshal(map[string]interface{}{"posts": posts, "comments": comments})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []string{"example.com", `"age"`, `"gender"`, `"email"`} {
+		if strings.Contains(string(data), private) {
+			t.Errorf("authors expose %s: %s", private, data)
+		}
+	}
+}
+
+func BenchmarkGetPosts(b *testing.B) {
+	s := newCountingStore(b)
+	users := seedForum(b, s, MaxPostPageSize)
+	for _, limit := range []int{10, MaxPostPageSize} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			var queries int64
+			for i := 0; i < b.N; i++ {
+				queries += countStatements(func() {
+					if _, _, err := s.GetPosts(PostFilter{Limit: limit, Viewer: users[0]}); err != nil {
+						b.Fatal(err)
+					}
+				})
+			}
+			b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+		})
+	}
+}
+
+func TestGetCommentsBatchesReactionLookups(t *testing.T) {
+	s := newCountingStore(t)
+	users := seedForum(t, s, 1)
+	posts, _, err := s.GetPosts(PostFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID := posts[0].ID
+	n := 2*idBatchSize + 1
+	var last uuid.UUID
+	for i := 1; i < n; i++ {
+		if last, err = s.CreateComment(postID, users[1], nil, fmt.Sprintf("comment %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.AddCommentReaction(users[0], last, "like"); err != nil {
+		t.Fatal(err)
+	}
+
+	var comments []Comment
+	queries := countStatements(func() {
+		if comments, err = s.GetComments(postID, users[0]); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(comments) != n {
+		t.Fatalf("got %d comments, want %d", len(comments), n)
+	}
+	// One query for the comments, then three batches for each of the
+	// reaction counts and the viewer's reactions
+	if queries != 7 {
+		t.Errorf("GetComments ran %d queries, want 7", queries)
+	}
+	for _, c := range comments {
+		if c.ID != last {
+			continue
+		}
+		if c.UserReaction != "like" || len(c.Reactions) != 1 || c.Reactions[0].Count != 1 {
+			t.Fatalf("last comment has reaction %q and counts %+v", c.UserReaction, c.Reactions)
+		}
+		return
+	}
+	t.Fatal("last comment is missing")
+}
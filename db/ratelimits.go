@@ -0,0 +1,40 @@
+package db
+
+// Rate limit buckets hold the theoretical arrival time (TAT) of the GCRA
+// limiter in unix nanoseconds. Updates are compare-and-set so that several
+// instances can share a bucket without locking it.
+
+// GetRateLimit returns the TAT of a bucket, or sql.ErrNoRows when it has
+// none
+func (s *SQLStore) GetRateLimit(key string) (int64, error) {
+	var tat int64
+	err := s.db.QueryRow("SELECT tat FROM rate_limits WHERE bucket_key = ?", key).Scan(&tat)
+	return tat, err
+}
+
+// SetRateLimit moves the TAT of a bucket from old to tat, creating the
+// bucket when old is 0. It reports false when another request changed the
+// bucket first.
+func (s *SQLStore) SetRateLimit(key string, old, tat int64) (bool, error) {
+	query := "UPDATE rate_limits SET tat = ? WHERE bucket_key = ? AND tat = ?"
+	args := []interface{}{tat, key, old}
+	if old == 0 {
+		query = "INSERT INTO rate_limits (bucket_key, tat) VALUES (?, ?) ON CONFLICT (bucket_key) DO NOTHING"
+		args = []interface{}{key, tat}
+	}
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// DeleteExpiredRateLimits removes buckets that have fully refilled by now
+func (s *SQLStore) DeleteExpiredRateLimits(now int64) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM rate_limits WHERE tat <= ?", now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
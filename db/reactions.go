@@ -0,0 +1,314 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// ErrInvalidReaction is returned for reaction types that are unknown or
+// have been disabled
+var ErrInvalidReaction = errors.New("invalid reaction type")
+
+// ErrReactionExists is returned by CreateReactionKind when a reaction type
+// with the same name is already registered
+var ErrReactionExists = errors.New("reaction type already exists")
+
+// AddPostReaction sets the user's reaction to a post. Reacting again with
+// the same type removes the reaction and a different type replaces it. The
+// resulting reaction is returned, empty when it was removed.
+func (s *SQLStore) AddPostReaction(userID, postID uuid.UUID, reactionType ReactionType) (ReactionType, error) {
+	return s.toggleReaction("post_id", userID, postID, reactionType)
+}
+
+// AddCommentReaction is the comment counterpart of AddPostReaction
+func (s *SQLStore) AddCommentReaction(userID, commentID uuid.UUID, reactionType ReactionType) (ReactionType, error) {
+	return s.toggleReaction("comment_id", userID, commentID, reactionType)
+}
+
+// RemovePostReaction removes the user's reaction to a post, if any
+func (s *SQLStore) RemovePostReaction(userID, postID uuid.UUID) error {
+	_, err := s.db.Exec("DELETE FROM likes WHERE user_id = ? AND post_id = ?", userID, postID)
+	return err
+}
+
+// RemoveCommentReaction removes the user's reaction to a comment, if any
+func (s *SQLStore) RemoveCommentReaction(userID, commentID uuid.UUID) error {
+	_, err := s.db.Exec("DELETE FROM likes WHERE user_id = ? AND comment_id = ?", userID, commentID)
+	return err
+}
+
+// toggleReaction applies the toggle rules of AddPostReaction to the likes
+// row of userID on the target identified by column
+func (s *SQLStore) toggleReaction(column string, userID, targetID uuid.UUID, reactionType ReactionType) (ReactionType, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var typeID int
+	err = tx.QueryRow("SELECT reaction_type_id FROM reaction_types WHERE name = ? AND enabled = ?", reactionType, true).Scan(&typeID)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidReaction
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var currentID int
+	err = tx.QueryRow("SELECT reaction_type_id FROM likes WHERE user_id = ? AND "+column+" = ?", userID, targetID).Scan(&currentID)
+	result := reactionType
+	switch {
+	case err == sql.ErrNoRows:
+		err = insertReaction(tx, column, userID, targetID, typeID)
+	case err != nil:
+		return "", err
+	case currentID == typeID:
+		result = ""
+		_, err = tx.Exec("DELETE FROM likes WHERE user_id = ? AND "+column+" = ?", userID, targetID)
+	default:
+		_, err = tx.Exec("UPDATE likes SET reaction_type_id = ?, created_at = ? WHERE user_id = ? AND "+column+" = ?", typeID, time.Now(), userID, targetID)
+	}
+	if err != nil {
+		return "", err
+	}
+	return result, tx.Commit()
+}
+
+// insertReaction adds the user's first reaction to a target. A concurrent
+// request may have added one since it was looked up; the newer type then
+// wins instead of failing on the unique index.
+func insertReaction(tx *tx, column string, userID, targetID uuid.UUID, typeID int) error {
+	_, err := tx.Exec(`
+        INSERT INTO likes (user_id, `+column+`, reaction_type_id, created_at) VALUES (?, ?, ?, ?)
+        ON CONFLICT (user_id, `+column+`) DO UPDATE
+        SET reaction_type_id = excluded.reaction_type_id, created_at = excluded.created_at`,
+		userID, targetID, typeID, time.Now())
+	return err
+}
+
+func (s *SQLStore) GetPostReactions(postID uuid.UUID) ([]Reaction, error) {
+	rows, err := s.db.Query(`
+        SELECT l.like_id, l.user_id, l.post_id, rt.name
+        FROM likes l
+        JOIN reaction_types rt ON rt.reaction_type_id = l.reaction_type_id
+        WHERE l.post_id = ?`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reactions []Reaction
+	for rows.Next() {
+		var r Reaction
+		err := rows.Scan(&r.ReactionID, &r.UserID, &r.PostID, &r.Type)
+		if err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, r)
+	}
+	return reactions, rows.Err()
+}
+func (s *SQLStore) GetCommentReactions(commentID uuid.UUID) ([]Reaction, error) {
+	rows, err := s.db.Query(`
+        SELECT l.like_id, l.user_id, l.comment_id, rt.name
+        FROM likes l
+        JOIN reaction_types rt ON rt.reaction_type_id = l.reaction_type_id
+        WHERE l.comment_id = ?`, commentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reactions []Reaction
+	for rows.Next() {
+		var r Reaction
+		err := rows.Scan(&r.ReactionID, &r.UserID, &r.CommentID, &r.Type)
+		if err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, r)
+	}
+	return reactions, rows.Err()
+}
+
+// loadPostReactionCounts attaches per-type reaction counts to posts
+func (s *SQLStore) loadPostReactionCounts(posts []Post) error {
+	ids := make([]interface{}, len(posts))
+	for i := range posts {
+		ids[i] = posts[i].ID
+	}
+	counts, err := s.reactionCounts("post_id", ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Reactions = counts[posts[i].ID]
+		if posts[i].Reactions == nil {
+			posts[i].Reactions = []ReactionCount{}
+		}
+	}
+	return nil
+}
+
+// loadCommentReactionCounts attaches per-type reaction counts to comments
+func (s *SQLStore) loadCommentReactionCounts(comments []Comment) error {
+	ids := make([]interface{}, len(comments))
+	for i := range comments {
+		ids[i] = comments[i].ID
+	}
+	counts, err := s.reactionCounts("comment_id", ids)
+	if err != nil {
+		return err
+	}
+	for i := range comments {
+		comments[i].Reactions = counts[comments[i].ID]
+		if comments[i].Reactions == nil {
+			comments[i].Reactions = []ReactionCount{}
+		}
+	}
+	return nil
+}
+
+// reactionCounts counts reactions per type for the targets in ids, keyed by
+// target and ordered by the registry's position
+func (s *SQLStore) reactionCounts(column string, ids []interface{}) (map[uuid.UUID][]ReactionCount, error) {
+	counts := map[uuid.UUID][]ReactionCount{}
+	err := inBatches(ids, func(batch []interface{}) error {
+		rows, err := s.db.Query(`
+            SELECT l.`+column+`, rt.name, rt.emoji, COUNT(*)
+            FROM likes l
+            JOIN reaction_types rt ON rt.reaction_type_id = l.reaction_type_id
+            WHERE l.`+column+` IN (`+placeholders(len(batch))+`)
+            GROUP BY l.`+column+`, rt.reaction_type_id, rt.name, rt.emoji, rt.position
+            ORDER BY rt.position, rt.reaction_type_id`, batch...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var targetID uuid.UUID
+			var c ReactionCount
+			if err := rows.Scan(&targetID, &c.Type, &c.Emoji, &c.Count); err != nil {
+				return err
+			}
+			counts[targetID] = append(counts[targetID], c)
+		}
+		return rows.Err()
+	})
+	return counts, err
+}
+
+// loadPostReactions sets UserReaction on posts the viewer has reacted to
+func (s *SQLStore) loadPostReactions(viewer uuid.UUID, posts []Post) error {
+	ids := make([]interface{}, len(posts))
+	for i := range posts {
+		ids[i] = posts[i].ID
+	}
+	reactions, err := s.viewerReactions("post_id", viewer, ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].UserReaction = reactions[posts[i].ID]
+	}
+	return nil
+}
+
+// loadCommentReactions sets UserReaction on comments the viewer has reacted to
+func (s *SQLStore) loadCommentReactions(viewer uuid.UUID, comments []Comment) error {
+	ids := make([]interface{}, len(comments))
+	for i := range comments {
+		ids[i] = comments[i].ID
+	}
+	reactions, err := s.viewerReactions("comment_id", viewer, ids)
+	if err != nil {
+		return err
+	}
+	for i := range comments {
+		comments[i].UserReaction = reactions[comments[i].ID]
+	}
+	return nil
+}
+
+// viewerReactions returns the viewer's reaction to each target in ids
+func (s *SQLStore) viewerReactions(column string, viewer uuid.UUID, ids []interface{}) (map[uuid.UUID]ReactionType, error) {
+	reactions := map[uuid.UUID]ReactionType{}
+	if viewer == uuid.Nil {
+		return reactions, nil
+	}
+	err := inBatches(ids, func(batch []interface{}) error {
+		rows, err := s.db.Query(`
+            SELECT l.`+column+`, rt.name
+            FROM likes l
+            JOIN reaction_types rt ON rt.reaction_type_id = l.reaction_type_id
+            WHERE l.user_id = ? AND l.`+column+` IN (`+placeholders(len(batch))+`)`, append([]interface{}{viewer}, batch...)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var targetID uuid.UUID
+			var t ReactionType
+			if err := rows.Scan(&targetID, &t); err != nil {
+				return err
+			}
+			reactions[targetID] = t
+		}
+		return rows.Err()
+	})
+	return reactions, err
+}
+
+// GetReactionKinds returns the reaction registry in display order. Disabled
+// kinds are only included when all is true.
+func (s *SQLStore) GetReactionKinds(all bool) ([]ReactionKind, error) {
+	query := "SELECT reaction_type_id, name, emoji, position, enabled FROM reaction_types"
+	var args []interface{}
+	if !all {
+		query += " WHERE enabled = ?"
+		args = append(args, true)
+	}
+	rows, err := s.db.Query(query+" ORDER BY position, reaction_type_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var kinds []ReactionKind
+	for rows.Next() {
+		var k ReactionKind
+		if err := rows.Scan(&k.ID, &k.Name, &k.Emoji, &k.Position, &k.Enabled); err != nil {
+			return nil, err
+		}
+		kinds = append(kinds, k)
+	}
+	return kinds, rows.Err()
+}
+
+// CreateReactionKind adds a new enabled reaction type to the registry
+func (s *SQLStore) CreateReactionKind(name ReactionType, emoji string, position int) error {
+	_, err := s.db.Exec("INSERT INTO reaction_types (name, emoji, position, enabled) VALUES (?, ?, ?, ?)", name, emoji, position, true)
+	if uniqueViolation(err, "reaction_types.name", "reaction_types_name_key") {
+		return ErrReactionExists
+	}
+	return err
+}
+
+// UpdateReactionKind changes the emoji, position and enabled flag of a
+// reaction type. Names are fixed since reactions refer to types by name in
+// the API.
+func (s *SQLStore) UpdateReactionKind(kind ReactionKind) error {
+	res, err := s.db.Exec("UPDATE reaction_types SET emoji = ?, position = ?, enabled = ? WHERE reaction_type_id = ?", kind.Emoji, kind.Position, kind.Enabled, kind.ID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
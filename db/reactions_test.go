@@ -0,0 +1,94 @@
+package db
+
+import "testing"
+
+func TestInsertReactionAfterConcurrentInsert(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *SQLStore) {
+		users := seedForum(t, s, 1)
+		posts, _, err := s.GetPosts(PostFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		postID := posts[0].ID
+		if err := s.RemovePostReaction(users[0], postID); err != nil {
+			t.Fatal(err)
+		}
+		kinds, err := s.GetReactionKinds(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Two requests that both saw no reaction insert one after the other
+		for _, kind := range kinds[:2] {
+			tx, err := s.db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := insertReaction(tx, "post_id", users[0], postID, kind.ID); err != nil {
+				tx.Rollback()
+				t.Fatalf("inserting %s: %v", kind.Name, err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		reactions, err := s.GetPostReactions(postID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var mine []ReactionType
+		for _, r := range reactions {
+			if r.UserID == users[0] {
+				mine = append(mine, r.Type)
+			}
+		}
+		if len(mine) != 1 || mine[0] != kinds[1].Name {
+			t.Fatalf("user reactions = %v, want [%s]", mine, kinds[1].Name)
+		}
+	})
+}
+
+func TestToggleReaction(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *SQLStore) {
+		users := seedForum(t, s, 1)
+		posts, _, err := s.GetPosts(PostFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		postID := posts[0].ID
+		steps := []struct {
+			react, want ReactionType
+		}{
+			{"like", ""}, // seedForum already liked the post
+			{"like", "like"},
+			{"dislike", "dislike"},
+			{"dislike", ""},
+		}
+		for _, step := range steps {
+			got, err := s.AddPostReaction(users[0], postID, step.react)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != step.want {
+				t.Fatalf("reacting %s gave %q, want %q", step.react, got, step.want)
+			}
+		}
+		if _, err := s.AddPostReaction(users[0], postID, "no-such-type"); err != ErrInvalidReaction {
+			t.Fatalf("err = %v, want ErrInvalidReaction", err)
+		}
+	})
+}
+
+func TestCreateReactionKindRejectsDuplicates(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *SQLStore) {
+		if err := s.CreateReactionKind("like", "X", 1); err != ErrReactionExists {
+			t.Fatalf("duplicating like: %v", err)
+		}
+		if err := s.CreateReactionKind("party", "P", 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreateReactionKind("party", "Q", 11); err != ErrReactionExists {
+			t.Fatalf("duplicating party: %v", err)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// ErrDeleted is returned when editing or deleting content that is already deleted
+var ErrDeleted = errors.New("content has been deleted")
+
+// GetPostByID returns a single post, including soft deleted ones
+func (s *SQLStore) GetPostByID(postID uuid.UUID) (*Post, error) {
+	p, err := scanPost(s.db.QueryRow(postQuery+`
+        WHERE p.post_id = ?`+postGroupBy, postID))
+	if err != nil {
+		return nil, err
+	}
+	posts := []Post{p}
+	if err := s.loadCategories(posts); err != nil {
+		return nil, err
+	}
+	if err := s.loadPostReactionCounts(posts); err != nil {
+		return nil, err
+	}
+	return &posts[0], nil
+}
+
+// GetCommentByID returns a single comment, including soft deleted ones
+func (s *SQLStore) GetCommentByID(commentID uuid.UUID) (*Comment, error) {
+	comments, err := s.queryComments("c.comment_id = ?", commentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(comments) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &comments[0], nil
+}
+
+// UpdatePost stores the current subject and content as a revision and
+// replaces them with the new values
+func (s *SQLStore) UpdatePost(postID, editorID uuid.UUID, subject, content string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := savePostRevision(tx, postID, editorID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE posts SET subject = ?, content = ?, edited_at = ? WHERE post_id = ?", subject, content, time.Now(), postID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeletePost soft deletes a post. The last content is kept as a revision and
+// the row stays behind as a tombstone so its comments remain reachable.
+func (s *SQLStore) DeletePost(postID, editorID uuid.UUID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := savePostRevision(tx, postID, editorID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE posts SET subject = '', content = '', deleted_at = ? WHERE post_id = ?", time.Now(), postID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func savePostRevision(tx *tx, postID, editorID uuid.UUID) error {
+	var subject, content string
+	var deleted bool
+	err := tx.QueryRow("SELECT subject, content, deleted_at IS NOT NULL FROM posts WHERE post_id = ?", postID).Scan(&subject, &content, &deleted)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return ErrDeleted
+	}
+	_, err = tx.Exec("INSERT INTO revisions (post_id, editor_id, subject, content, created_at) VALUES (?, ?, ?, ?, ?)", postID, editorID, subject, content, time.Now())
+	return err
+}
+
+// UpdateComment stores the current content as a revision and replaces it
+func (s *SQLStore) UpdateComment(commentID, editorID uuid.UUID, content string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := saveCommentRevision(tx, commentID, editorID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE comments SET content = ?, edited_at = ? WHERE comment_id = ?", content, time.Now(), commentID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteComment soft deletes a comment, leaving a tombstone in the thread
+func (s *SQLStore) DeleteComment(commentID, editorID uuid.UUID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := saveCommentRevision(tx, commentID, editorID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE comments SET content = '', deleted_at = ? WHERE comment_id = ?", time.Now(), commentID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func saveCommentRevision(tx *tx, commentID, editorID uuid.UUID) error {
+	var content string
+	var deleted bool
+	err := tx.QueryRow("SELECT content, deleted_at IS NOT NULL FROM comments WHERE comment_id = ?", commentID).Scan(&content, &deleted)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return ErrDeleted
+	}
+	_, err = tx.Exec("INSERT INTO revisions (comment_id, editor_id, content, created_at) VALUES (?, ?, ?, ?)", commentID, editorID, content, time.Now())
+	return err
+}
+
+// GetPostRevisions returns the previous versions of a post, oldest first
+func (s *SQLStore) GetPostRevisions(postID uuid.UUID) ([]Revision, error) {
+	rows, err := s.db.Query("SELECT revision_id, post_id, editor_id, COALESCE(subject, ''), content, created_at FROM revisions WHERE post_id = ? ORDER BY revision_id ASC", postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var revisions []Revision
+	for rows.Next() {
+		var r Revision
+		if err := rows.Scan(&r.ID, &r.PostID, &r.EditorID, &r.Subject, &r.Content, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// GetCommentRevisions returns the previous versions of a comment, oldest first
+func (s *SQLStore) GetCommentRevisions(commentID uuid.UUID) ([]Revision, error) {
+	rows, err := s.db.Query("SELECT revision_id, comment_id, editor_id, content, created_at FROM revisions WHERE comment_id = ? ORDER BY revision_id ASC", commentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var revisions []Revision
+	for rows.Next() {
+		var r Revision
+		if err := rows.Scan(&r.ID, &r.CommentID, &r.EditorID, &r.Content, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// SetUserRole changes the role of the user with the given username
+func (s *SQLStore) SetUserRole(username, role string) error {
+	res, err := s.db.Exec("UPDATE users SET role = ? WHERE username = ?", role, username)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
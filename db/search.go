@@ -0,0 +1,285 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// Markers wrapped around matched terms in search snippets. They are plain
+// text so clients can escape the snippet before turning them into markup.
+const (
+	HighlightStart = "[["
+	HighlightEnd   = "]]"
+)
+
+// Kinds of search results
+const (
+	SearchPosts    = "post"
+	SearchComments = "comment"
+	SearchMessages = "message"
+)
+
+// MaxSearchOffset bounds how deep search results can be paged. Each source
+// is asked for offset+limit rows, so deeper pages would cost ever more.
+const MaxSearchOffset = 200
+
+var (
+	// ErrEmptySearch is returned when the search text has no terms
+	ErrEmptySearch = errors.New("empty search query")
+	// ErrSearchOffset is returned when the offset exceeds MaxSearchOffset
+	ErrSearchOffset = errors.New("search offset too large")
+)
+
+type SearchQuery struct {
+	Text       string
+	Kind       string // one of SearchPosts, SearchComments, SearchMessages or empty for all
+	CategoryID int
+	Author     string
+	UserID     uuid.UUID // the searching user; messages are limited to their conversations
+	Limit      int
+	Offset     int
+}
+type SearchResult struct {
+	Kind      string        `json:"kind"`
+	ID        uuid.UUID     `json:"id"`
+	PostID    uuid.NullUUID `json:"post_id"`
+	Title     string        `json:"title,omitempty"`
+	Snippet   string        `json:"snippet"`
+	Author    string        `json:"author"`
+	CreatedAt time.Time     `json:"created_at"`
+	// Score is relative to the best match of the same kind, which scores 1
+	Score float64 `json:"score"`
+}
+
+// searchSource holds the dialect specific SELECT for one kind of result.
+// Each statement selects id, post_id, title, snippet, author, created_at and
+// score, and ends with a WHERE clause whose first placeholder is the search
+// text so filters can be appended with AND. like is the fallback for SQLite
+// without FTS5; it has no WHERE clause and matches every term against
+// likeText instead.
+type searchSource struct {
+	kind     string
+	sqlite   string
+	postgres string
+	like     string
+	likeText string
+}
+
+const pgHeadline = `'StartSel="` + HighlightStart + `", StopSel="` + HighlightEnd + `", MaxFragments=1, MaxWords=16, MinWords=5'`
+
+var searchSources = []searchSource{
+	{
+		kind: SearchPosts,
+		sqlite: `
+        SELECT p.post_id, p.post_id,
+               highlight(posts_fts, 1, '` + HighlightStart + `', '` + HighlightEnd + `'),
+               snippet(posts_fts, 2, '` + HighlightStart + `', '` + HighlightEnd + `', '…', 16),
+               u.username, p.created_at AS created_at, -bm25(posts_fts) AS score
+        FROM posts_fts
+        JOIN posts p ON p.post_id = posts_fts.post_id
+        JOIN users u ON u.user_id = p.user_id
+        WHERE posts_fts MATCH ?`,
+		postgres: `
+        SELECT p.post_id, p.post_id,
+               ts_headline('simple', p.subject, q, 'StartSel="` + HighlightStart + `", StopSel="` + HighlightEnd + `", HighlightAll=true'),
+               ts_headline('simple', p.content, q, ` + pgHeadline + `),
+               u.username, p.created_at AS created_at, ts_rank(to_tsvector('simple', p.subject || ' ' || p.content), q) AS score
+        FROM posts p
+        JOIN users u ON u.user_id = p.user_id
+        CROSS JOIN websearch_to_tsquery('simple', ?) q
+        WHERE to_tsvector('simple', p.subject || ' ' || p.content) @@ q`,
+		like: `
+        SELECT p.post_id, p.post_id, p.subject, substr(p.content, 1, 200),
+               u.username, p.created_at AS created_at, 0 AS score
+        FROM posts p
+        JOIN users u ON u.user_id = p.user_id`,
+		likeText: "p.subject || ' ' || p.content",
+	},
+	{
+		kind: SearchComments,
+		sqlite: `
+        SELECT c.comment_id, c.post_id, p.subject,
+               snippet(comments_fts, 1, '` + HighlightStart + `', '` + HighlightEnd + `', '…', 16),
+               u.username, c.created_at AS created_at, -bm25(comments_fts) AS score
+        FROM comments_fts
+        JOIN comments c ON c.comment_id = comments_fts.comment_id
+        JOIN posts p ON p.post_id = c.post_id
+        JOIN users u ON u.user_id = c.user_id
+        WHERE comments_fts MATCH ?`,
+		postgres: `
+        SELECT c.comment_id, c.post_id, p.subject,
+               ts_headline('simple', c.content, q, ` + pgHeadline + `),
+               u.username, c.created_at AS created_at, ts_rank(to_tsvector('simple', c.content), q) AS score
+        FROM comments c
+        JOIN posts p ON p.post_id = c.post_id
+        JOIN users u ON u.user_id = c.user_id
+        CROSS JOIN websearch_to_tsquery('simple', ?) q
+        WHERE to_tsvector('simple', c.content) @@ q`,
+		like: `
+        SELECT c.comment_id, c.post_id, p.subject, substr(c.content, 1, 200),
+               u.username, c.created_at AS created_at, 0 AS score
+        FROM comments c
+        JOIN posts p ON p.post_id = c.post_id
+        JOIN users u ON u.user_id = c.user_id`,
+		likeText: "c.content",
+	},
+	{
+		kind: SearchMessages,
+		sqlite: `
+        SELECT m.message_id, NULL, '',
+               snippet(messages_fts, 1, '` + HighlightStart + `', '` + HighlightEnd + `', '…', 16),
+               u.username, m.created_at AS created_at, -bm25(messages_fts) AS score
+        FROM messages_fts
+        JOIN messages m ON m.message_id = messages_fts.message_id
+        JOIN users u ON u.user_id = m.sender_id
+        WHERE messages_fts MATCH ?`,
+		postgres: `
+        SELECT m.message_id, NULL::uuid, '',
+               ts_headline('simple', m.content, q, ` + pgHeadline + `),
+               u.username, m.created_at AS created_at, ts_rank(to_tsvector('simple', m.content), q) AS score
+        FROM messages m
+        JOIN users u ON u.user_id = m.sender_id
+        CROSS JOIN websearch_to_tsquery('simple', ?) q
+        WHERE to_tsvector('simple', m.content) @@ q`,
+		like: `
+        SELECT m.message_id, NULL, '', substr(m.content, 1, 200),
+               u.username, m.created_at AS created_at, 0 AS score
+        FROM messages m
+        JOIN users u ON u.user_id = m.sender_id`,
+		likeText: "m.content",
+	},
+}
+
+// ftsMatchQuery turns free text into an FTS5 query that matches all terms,
+// quoting each one so user input cannot inject FTS5 syntax
+func ftsMatchQuery(text string) string {
+	var terms []string
+	for _, f := range strings.Fields(text) {
+		terms = append(terms, `"`+strings.ReplaceAll(f, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// likeMatch returns a WHERE clause requiring every term of text in column,
+// with its arguments. LIKE wildcards in the terms are escaped.
+func likeMatch(column, text string) (string, []interface{}) {
+	escape := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	var conds []string
+	var args []interface{}
+	for _, f := range strings.Fields(text) {
+		conds = append(conds, column+` LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escape.Replace(f)+"%")
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// Search runs a ranked full-text search over posts, comments and the
+// searching user's private messages. It returns up to q.Limit results
+// starting at q.Offset, best match first. bm25 and ts_rank scores are not
+// comparable across tables, so each source's scores are divided by its best
+// one before the results are merged. Without FTS5, SQLite matches terms
+// with LIKE and orders results newest first.
+func (s *SQLStore) Search(q SearchQuery) ([]SearchResult, error) {
+	if strings.TrimSpace(q.Text) == "" {
+		return nil, ErrEmptySearch
+	}
+	if q.Offset > MaxSearchOffset {
+		return nil, ErrSearchOffset
+	}
+	text := q.Text
+	if s.db.dialect == dialectSQLite {
+		text = ftsMatchQuery(q.Text)
+	}
+	var results []SearchResult
+	for _, src := range searchSources {
+		if q.Kind != "" && q.Kind != src.kind {
+			continue
+		}
+		// Messages have no category
+		if src.kind == SearchMessages && q.CategoryID != 0 {
+			continue
+		}
+		query := src.sqlite
+		args := []interface{}{text}
+		if s.db.dialect == dialectPostgres {
+			query = src.postgres
+		} else if !s.fts5 {
+			where, likeArgs := likeMatch(src.likeText, q.Text)
+			query = src.like + where
+			args = likeArgs
+		}
+		switch src.kind {
+		case SearchPosts:
+			if q.CategoryID != 0 {
+				query += " AND EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = p.post_id AND pc.category_id = ?)"
+				args = append(args, q.CategoryID)
+			}
+		case SearchComments:
+			if q.CategoryID != 0 {
+				query += " AND EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = c.post_id AND pc.category_id = ?)"
+				args = append(args, q.CategoryID)
+			}
+		case SearchMessages:
+			query += " AND (m.sender_id = ? OR m.receiver_id = ?)"
+			args = append(args, q.UserID, q.UserID)
+		}
+		if q.Author != "" {
+			query += " AND u.username = ?"
+			args = append(args, q.Author)
+		}
+		// Each source can contribute at most offset+limit rows to the page
+		query += " ORDER BY score DESC, created_at DESC LIMIT ?"
+		args = append(args, q.Offset+q.Limit)
+
+		rows, err := s.db.Query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		first := len(results)
+		for rows.Next() {
+			r := SearchResult{Kind: src.kind}
+			if err := rows.Scan(&r.ID, &r.PostID, &r.Title, &r.Snippet, &r.Author, &r.CreatedAt, &r.Score); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			results = append(results, r)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		normalizeScores(results[first:])
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+	if q.Offset >= len(results) {
+		return []SearchResult{}, nil
+	}
+	results = results[q.Offset:]
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+// normalizeScores scales the scores of results, which come from one source
+// ordered best first, so the best one is 1. Unranked LIKE results keep 0.
+func normalizeScores(results []SearchResult) {
+	if len(results) == 0 || results[0].Score <= 0 {
+		return
+	}
+	best := results[0].Score
+	for i := range results {
+		results[i].Score /= best
+	}
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSearchScoresAreNormalizedPerKind(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *SQLStore) {
+		users := seedForum(t, s, 0)
+		start := time.Now().Add(-time.Hour)
+		for i := 0; i < 5; i++ {
+			content := "gopher " + fmt.Sprint(i) + " filler words that dilute the match"
+			if i == 0 {
+				content = "gopher gopher gopher"
+			}
+			if err := s.CreatePost(users[0], fmt.Sprintf("Post %d", i), content, []int{1}, start.Add(time.Duration(i)*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		posts, _, err := s.GetPosts(PostFilter{Limit: MaxPostPageSize})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.CreateComment(posts[0].ID, users[1], nil, "a gopher in a long comment about other things entirely"); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := s.Search(SearchQuery{Text: "gopher", Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 6 {
+			t.Fatalf("got %d results, want 6", len(results))
+		}
+		if s.db.dialect == dialectSQLite && !s.fts5 {
+			t.Skip("scores need FTS5")
+		}
+		best := map[string]float64{}
+		for i, r := range results {
+			if r.Score <= 0 || r.Score > 1 {
+				t.Errorf("%s score %v is outside (0, 1]", r.Kind, r.Score)
+			}
+			if i > 0 && r.Score > results[i-1].Score {
+				t.Errorf("results are not ordered by score: %v after %v", r.Score, results[i-1].Score)
+			}
+			best[r.Kind] = max(best[r.Kind], r.Score)
+		}
+		if best[SearchPosts] != 1 || best[SearchComments] != 1 {
+			t.Errorf("best scores per kind = %v, want 1", best)
+		}
+	})
+}
+
+func TestSearchOffsetIsBounded(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *SQLStore) {
+		if _, err := s.Search(SearchQuery{Text: "gopher", Limit: 10, Offset: MaxSearchOffset}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Search(SearchQuery{Text: "gopher", Limit: 10, Offset: MaxSearchOffset + 1}); err != ErrSearchOffset {
+			t.Fatalf("err = %v, want ErrSearchOffset", err)
+		}
+	})
+}
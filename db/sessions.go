@@ -0,0 +1,133 @@
+package db
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// sessionColumns are the columns scanned by scanSession
+const sessionColumns = `s.session_id, s.token, s.user_id, u.username, u.role, s.user_agent, s.ip, s.created_at, s.last_seen_at, s.expires_at`
+
+// scanSession scans a row selected with sessionColumns
+func scanSession(row rowScanner) (Session, error) {
+	var session Session
+	var expiresAt int64
+	err := row.Scan(&session.ID, &session.SessionToken, &session.UserID, &session.Username, &session.Role,
+		&session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeen, &expiresAt)
+	session.ExpireTime = time.Unix(expiresAt, 0)
+	return session, err
+}
+
+// SaveSession stores a new login session and sets its ID
+func (s *SQLStore) SaveSession(session *Session) error {
+	return s.db.QueryRow(`
+        INSERT INTO sessions (token, user_id, user_agent, ip, created_at, last_seen_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+        RETURNING session_id`,
+		session.SessionToken, session.UserID, session.UserAgent, session.IP,
+		session.CreatedAt, session.LastSeen, session.ExpireTime.Unix()).Scan(&session.ID)
+}
+
+// GetSession returns the unexpired session with the given token, or
+// sql.ErrNoRows when there is none
+func (s *SQLStore) GetSession(token string) (*Session, error) {
+	session, err := scanSession(s.db.QueryRow(`
+        SELECT `+sessionColumns+`
+        FROM sessions s
+        JOIN users u ON u.user_id = s.user_id
+        WHERE s.token = ? AND s.expires_at > ?`, token, time.Now().Unix()))
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetUserSessions returns the user's unexpired sessions, most recently
+// used first
+func (s *SQLStore) GetUserSessions(userID uuid.UUID) ([]Session, error) {
+	rows, err := s.db.Query(`
+        SELECT `+sessionColumns+`
+        FROM sessions s
+        JOIN users u ON u.user_id = s.user_id
+        WHERE s.user_id = ? AND s.expires_at > ?
+        ORDER BY s.last_seen_at DESC`, userID, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchSession records activity on a session and moves its expiry
+func (s *SQLStore) TouchSession(token string, lastSeen, expiration time.Time) error {
+	_, err := s.db.Exec(`UPDATE sessions SET last_seen_at = ?, expires_at = ? WHERE token = ?`, lastSeen, expiration.Unix(), token)
+	return err
+}
+
+// DeleteSession removes a session
+func (s *SQLStore) DeleteSession(token string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE token = ?`, token)
+	return err
+}
+
+// DeleteUserSession removes one of the user's sessions by ID and returns
+// its token, or sql.ErrNoRows if the user has no such session
+func (s *SQLStore) DeleteUserSession(userID uuid.UUID, sessionID int) (string, error) {
+	var token string
+	err := s.db.QueryRow(`SELECT token FROM sessions WHERE session_id = ? AND user_id = ?`, sessionID, userID).Scan(&token)
+	if err != nil {
+		return "", err
+	}
+	return token, s.DeleteSession(token)
+}
+
+// DeleteOtherSessions removes all of the user's sessions except keepToken
+// and returns the tokens that were removed. An empty keepToken removes
+// every session.
+func (s *SQLStore) DeleteOtherSessions(userID uuid.UUID, keepToken string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT token FROM sessions WHERE user_id = ? AND token <> ?`, userID, keepToken)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ? AND token <> ?`, userID, keepToken); err != nil {
+		return nil, err
+	}
+	return tokens, tx.Commit()
+}
+
+// DeleteExpiredSessions removes every expired session and returns how many
+// were deleted
+func (s *SQLStore) DeleteExpiredSessions() (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// Store is the persistence layer used by the HTTP and websocket handlers.
+// SQLStore implements it for both SQLite and PostgreSQL; Open picks the
+// backend from the DSN.
+type Store interface {
+	// Users
+	RegisterUser(user User) (uuid.UUID, error)
+	CheckUserAvailability(username, email string) (usernameTaken, emailTaken bool, err error)
+	LoginUser(usernameOrEmail, password string) (Login, error)
+	GetUserByID(userID uuid.UUID) (*User, error)
+	GetUserIDByUsernameOrEmail(usernameOrEmail string) (uuid.UUID, error)
+	GetUsersOrderedByLastMessageOrAlphabetically() ([]User, error)
+	SetUserRole(username, role string) error
+	CreatePasswordReset(userID uuid.UUID, tokenHash string, expiresAt time.Time) error
+	GetPasswordResetUser(tokenHash string) (uuid.UUID, error)
+	ResetPassword(tokenHash, passwordHash string) (uuid.UUID, error)
+	CreateEmailVerification(userID uuid.UUID, tokenHash string, expiresAt time.Time) error
+	VerifyEmail(tokenHash string) (uuid.UUID, error)
+
+	// Two-factor authentication
+	GetTwoFactor(userID uuid.UUID) (*TwoFactor, error)
+	SetTwoFactorSecret(userID uuid.UUID, secret string) error
+	EnableTwoFactor(userID uuid.UUID, step int64, codeHashes []string) error
+	DisableTwoFactor(userID uuid.UUID) error
+	UseTOTPStep(userID uuid.UUID, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error
+	UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error)
+	CountRecoveryCodes(userID uuid.UUID) (int, error)
+	CreatePendingLogin(userID uuid.UUID, tokenHash string, expiresAt time.Time) error
+	AttemptPendingLogin(tokenHash string, maxAttempts int) (uuid.UUID, error)
+	DeletePendingLogin(tokenHash string) error
+	RoleRequiresTwoFactor(role string) (bool, error)
+	SetRoleRequiresTwoFactor(role string, required bool) error
+
+	// Login tracking
+	AddLoginEvent(event LoginEvent) error
+	GetLoginEvents(userID uuid.UUID, limit int) ([]LoginEvent, error)
+	CheckLoginDevice(userID uuid.UUID, userAgent string) (knownDevice, anyLogin bool, err error)
+	AddFailedLogin(userID uuid.UUID) (int, error)
+	LockAccount(userID uuid.UUID, until time.Time) error
+	GetAccountLock(userID uuid.UUID) (time.Time, error)
+	ClearFailedLogins(userID uuid.UUID) error
+
+	// Personal API tokens
+	CreateAPIToken(token *APIToken, tokenHash string) error
+	GetAPIToken(tokenHash string) (*APIToken, error)
+	GetUserAPITokens(userID uuid.UUID) ([]APIToken, error)
+	TouchAPIToken(tokenID int, lastUsed time.Time) error
+	DeleteAPIToken(userID uuid.UUID, tokenID int) error
+
+	// External identities
+	CreateOIDCState(stateHash, provider, nonce, codeVerifier string, expiresAt time.Time) error
+	ConsumeOIDCState(stateHash string) (provider, nonce, codeVerifier string, err error)
+	GetIdentityUser(provider, subject string) (uuid.UUID, error)
+	LinkIdentity(provider, subject, email string, userID uuid.UUID) error
+
+	// Sessions
+	SaveSession(session *Session) error
+	GetSession(token string) (*Session, error)
+	GetUserSessions(userID uuid.UUID) ([]Session, error)
+	TouchSession(token string, lastSeen, expiration time.Time) error
+	DeleteSession(token string) error
+	DeleteUserSession(userID uuid.UUID, sessionID int) (string, error)
+	DeleteOtherSessions(userID uuid.UUID, keepToken string) ([]string, error)
+	DeleteExpiredSessions() (int64, error)
+
+	// CSRF tokens
+	CreateCSRFToken(tokenHash, keyHash string, expiresAt time.Time) error
+	CheckCSRFToken(tokenHash, keyHash string) (bool, error)
+	DeleteCSRFTokens(keyHash string) error
+	DeleteExpiredCSRFTokens() (int64, error)
+
+	// Rate limits
+	GetRateLimit(key string) (int64, error)
+	SetRateLimit(key string, old, tat int64) (bool, error)
+	DeleteExpiredRateLimits(now int64) (int64, error)
+
+	// Posts and comments
+	CreatePost(userID uuid.UUID, subject, content string, categoryIDs []int, createdAt time.Time) error
+	GetPosts(filter PostFilter) ([]Post, *PostCursor, error)
+	GetPostCategories(postID uuid.UUID) ([]Category, error)
+	CreateComment(postID, userID uuid.UUID, parentID *uuid.UUID, content string) (uuid.UUID, error)
+	GetComments(postID, viewer uuid.UUID) ([]Comment, error)
+	GetPostByID(postID uuid.UUID) (*Post, error)
+	GetCommentByID(commentID uuid.UUID) (*Comment, error)
+
+	// Edits and revisions
+	UpdatePost(postID, editorID uuid.UUID, subject, content string) error
+	DeletePost(postID, editorID uuid.UUID) error
+	UpdateComment(commentID, editorID uuid.UUID, content string) error
+	DeleteComment(commentID, editorID uuid.UUID) error
+	GetPostRevisions(postID uuid.UUID) ([]Revision, error)
+	GetCommentRevisions(commentID uuid.UUID) ([]Revision, error)
+
+	// Categories
+	CreateCategory(name string) error
+	GetCategories() ([]Category, error)
+	GetCategoryByID(id int) (*Category, error)
+
+	// Reactions
+	AddPostReaction(userID, postID uuid.UUID, reactionType ReactionType) (ReactionType, error)
+	AddCommentReaction(userID, commentID uuid.UUID, reactionType ReactionType) (ReactionType, error)
+	RemovePostReaction(userID, postID uuid.UUID) error
+	RemoveCommentReaction(userID, commentID uuid.UUID) error
+	GetPostReactions(postID uuid.UUID) ([]Reaction, error)
+	GetCommentReactions(commentID uuid.UUID) ([]Reaction, error)
+	GetReactionKinds(all bool) ([]ReactionKind, error)
+	CreateReactionKind(name ReactionType, emoji string, position int) error
+	UpdateReactionKind(kind ReactionKind) error
+
+	// Private messages
+	AddMessage(senderID, receiverID uuid.UUID, content string) error
+	GetMessages(senderID, receiverID uuid.UUID, limit, offset int) ([]Message, error)
+	MarkMessageAsRead(messageID, userID uuid.UUID) error
+
+	// Search
+	Search(q SearchQuery) ([]SearchResult, error)
+
+	// Online status
+	UpdateUserStatus(userID uuid.UUID, isOnline bool) error
+	GetUserStatus() ([]UserStatus, error)
+
+	Close() error
+}
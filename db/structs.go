@@ -7,14 +7,23 @@ import (
 )
 
 type User struct {
-	Id        uuid.UUID `json:"id"`
-	Username  string    `json:"username"`
-	FirstName string    `json:"firstname"`
-	LastName  string    `json:"lastname"`
-	Age       int       `json:"age"`
-	Gender    string    `json:"gender"`
-	Password  string    `json:"password"`
-	Email     string    `json:"email"`
+	Id              uuid.UUID  `json:"id"`
+	Username        string     `json:"username"`
+	FirstName       string     `json:"firstname"`
+	LastName        string     `json:"lastname"`
+	Age             int        `json:"age"`
+	Gender          string     `json:"gender"`
+	Password        string     `json:"password"`
+	Email           string     `json:"email"`
+	Role            string     `json:"role"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+}
+
+// Author is the public profile of the author of a post or comment
+type Author struct {
+	Id       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
 }
 type Login struct {
 	Username string `json:"username"`
@@ -47,26 +56,56 @@ type Category struct {
 	Name string `json:"name"`
 }
 type Post struct {
-	ID           uuid.UUID   `json:"id"`
-	UserID       uuid.UUID   `json:"user_id"`
-	User         *User       `json:"user,omitempty"`
-	Subject      string      `json:"subject"`
-	Content      string      `json:"content"`
-	Categories   []*Category `json:"categories,omitempty"`
-	Comments     []*Comment  `json:"comments,omitempty"`
-	CreatedAt    time.Time   `json:"created_at"`
-	LikeCount    int         `json:"like_count"`
-	DislikeCount int         `json:"dislike_count"`
+	ID           uuid.UUID       `json:"id"`
+	UserID       uuid.UUID       `json:"user_id"`
+	User         *Author         `json:"user,omitempty"`
+	Subject      string          `json:"subject"`
+	Content      string          `json:"content"`
+	Categories   []*Category     `json:"categories,omitempty"`
+	Comments     []*Comment      `json:"comments,omitempty"`
+	CommentCount int             `json:"comment_count"`
+	CreatedAt    time.Time       `json:"created_at"`
+	EditedAt     *time.Time      `json:"edited_at,omitempty"`
+	Deleted      bool            `json:"deleted,omitempty"`
+	LikeCount    int             `json:"like_count"`
+	DislikeCount int             `json:"dislike_count"`
+	Reactions    []ReactionCount `json:"reactions"`
+	UserReaction ReactionType    `json:"user_reaction,omitempty"`
+}
+type PostFilter struct {
+	CategoryID int
+	Author     string
+	From       time.Time
+	To         time.Time
+	ReactedBy  uuid.UUID
+	Viewer     uuid.UUID
+	After      *PostCursor
+	Limit      int
+}
+type PostCursor struct {
+	CreatedAt time.Time
+	PostID    uuid.UUID
+}
+type PostPage struct {
+	Posts      []Post `json:"posts"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 type Comment struct {
-	ID           uuid.UUID `json:"id"`
-	PostID       uuid.UUID `json:"post_id"`
-	UserID       uuid.UUID `json:"user_id"`
-	User         *User     `json:"user,omitempty"`
-	Content      string    `json:"content"`
-	CreatedAt    time.Time `json:"created_at"`
-	LikeCount    int       `json:"like_count"`
-	DislikeCount int       `json:"dislike_count"`
+	ID           uuid.UUID       `json:"id"`
+	PostID       uuid.UUID       `json:"post_id"`
+	ParentID     *uuid.UUID      `json:"parent_id,omitempty"`
+	UserID       uuid.UUID       `json:"user_id"`
+	User         *Author         `json:"user,omitempty"`
+	Content      string          `json:"content"`
+	CreatedAt    time.Time       `json:"created_at"`
+	EditedAt     *time.Time      `json:"edited_at,omitempty"`
+	Deleted      bool            `json:"deleted,omitempty"`
+	LikeCount    int             `json:"like_count"`
+	DislikeCount int             `json:"dislike_count"`
+	Reactions    []ReactionCount `json:"reactions"`
+	UserReaction ReactionType    `json:"user_reaction,omitempty"`
+	Replies      []*Comment      `json:"replies,omitempty"`
+	ReplyCount   int             `json:"reply_count"`
 }
 type PostCategory struct {
 	PostID     uuid.UUID `json:"post_id"`
@@ -86,14 +125,110 @@ const (
 	Dislike ReactionType = "dislike"
 )
 
+type ReactionKind struct {
+	ID       int          `json:"id"`
+	Name     ReactionType `json:"name"`
+	Emoji    string       `json:"emoji"`
+	Position int          `json:"position"`
+	Enabled  bool         `json:"enabled"`
+}
+type ReactionCount struct {
+	Type  ReactionType `json:"type"`
+	Emoji string       `json:"emoji"`
+	Count int          `json:"count"`
+}
+
+type Revision struct {
+	ID        int        `json:"revision_id"`
+	PostID    *uuid.UUID `json:"post_id,omitempty"`
+	CommentID *uuid.UUID `json:"comment_id,omitempty"`
+	EditorID  uuid.UUID  `json:"editor_id"`
+	Subject   string     `json:"subject,omitempty"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TwoFactor is a user's TOTP enrollment. It is pending until EnabledAt is
+// set by confirming a code.
+type TwoFactor struct {
+	UserID    uuid.UUID  `json:"user_id"`
+	Secret    string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	EnabledAt *time.Time `json:"enabled_at,omitempty"`
+	LastStep  int64      `json:"-"`
+}
+
+// User roles
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type Session struct {
+	ID           int       `json:"id"`
+	UserID       uuid.UUID `json:"user_id"`
 	Username     string    `json:"username"`
-	SessionToken string    `json:"session_token"`
+	Role         string    `json:"-"` // the user's role when the session was loaded
+	SessionToken string    `json:"-"`
+	UserAgent    string    `json:"user_agent"`
+	IP           string    `json:"ip"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastSeen     time.Time `json:"last_seen"`
 	ExpireTime   time.Time `json:"expire_time"`
+	Current      bool      `json:"current"`
+}
+
+// LoginEvent records a login attempt on an account
+type LoginEvent struct {
+	ID        int       `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIToken is a personal access token. Only a hash of the token itself is
+// stored.
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Role       string     `json:"-"` // the owner's role
+}
+
+// HasScope reports whether the token grants scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type ReplyMessage struct {
+	Type      string    `json:"type"`
+	PostID    uuid.UUID `json:"post_id"`
+	CommentID uuid.UUID `json:"comment_id"`
+	ParentID  uuid.UUID `json:"parent_id"`
+	Sender    uuid.UUID `json:"sender"`
+	Receiver  uuid.UUID `json:"receiver"`
+	Timestamp string    `json:"timestamp"`
 }
 type ReactionMessage struct {
-	UserID       uuid.UUID    `json:"user_id"`
-	PostID       uuid.UUID    `json:"post_id,omitempty"`
-	CommentID    uuid.UUID    `json:"comment_id,omitempty"`
-	ReactionType ReactionType `json:"type"`
+	Type         string          `json:"type"`
+	UserID       uuid.UUID       `json:"user_id"`
+	PostID       uuid.UUID       `json:"post_id,omitempty"`
+	CommentID    uuid.UUID       `json:"comment_id,omitempty"`
+	ReactionType ReactionType    `json:"reaction"`
+	LikeCount    int             `json:"like_count"`
+	DislikeCount int             `json:"dislike_count"`
+	Reactions    []ReactionCount `json:"reactions"`
 }
@@ -0,0 +1,74 @@
+package db
+
+import "github.com/gofrs/uuid/v5"
+
+const (
+	// DefaultCommentDepth is how many reply levels are nested by default
+	DefaultCommentDepth = 3
+	// MaxCommentDepth caps the nesting a client may ask for
+	MaxCommentDepth = 10
+	// DefaultRepliesPerLevel is how many comments are returned per level by default
+	DefaultRepliesPerLevel = 20
+	// MaxRepliesPerLevel caps the comments a client may ask for per level
+	MaxRepliesPerLevel = 100
+)
+
+// CommentTreeOptions selects the part of a comment thread to return
+type CommentTreeOptions struct {
+	ParentID *uuid.UUID // root of the subtree, nil for top level comments
+	MaxDepth int        // number of levels to nest, at least 1
+	Limit    int        // comments per level
+	Offset   int        // offset into the first level only
+}
+
+// BuildCommentTree arranges a post's flat comment list into a tree. Every
+// comment carries its total ReplyCount; replies beyond Limit or MaxDepth are
+// left out and can be fetched by requesting the comment as ParentID.
+func BuildCommentTree(comments []Comment, opts CommentTreeOptions) []*Comment {
+	if opts.MaxDepth < 1 {
+		opts.MaxDepth = 1
+	}
+	if opts.Limit < 1 {
+		opts.Limit = DefaultRepliesPerLevel
+	}
+
+	children := map[uuid.UUID][]*Comment{}
+	var roots []*Comment
+	for i := range comments {
+		c := &comments[i]
+		if c.ParentID == nil {
+			roots = append(roots, c)
+		} else {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		}
+	}
+	for i := range comments {
+		comments[i].ReplyCount = len(children[comments[i].ID])
+	}
+
+	level := roots
+	if opts.ParentID != nil {
+		level = children[*opts.ParentID]
+	}
+	if opts.Offset >= len(level) {
+		return []*Comment{}
+	}
+	level = level[opts.Offset:]
+	return attachReplies(level, children, opts.Limit, opts.MaxDepth)
+}
+
+// attachReplies truncates level to limit and recursively nests replies
+// until depth runs out
+func attachReplies(level []*Comment, children map[uuid.UUID][]*Comment, limit, depth int) []*Comment {
+	if len(level) > limit {
+		level = level[:limit]
+	}
+	if depth > 1 {
+		for _, c := range level {
+			if replies := children[c.ID]; len(replies) > 0 {
+				c.Replies = attachReplies(replies, children, limit, depth-1)
+			}
+		}
+	}
+	return level
+}
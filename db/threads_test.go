@@ -0,0 +1,91 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// thread builds a flat comment list from "name" and "parent/name" entries.
+// A parent that is not in the list makes the comment an orphan.
+func thread(entries ...string) ([]Comment, map[string]uuid.UUID) {
+	ids := map[string]uuid.UUID{}
+	id := func(name string) uuid.UUID {
+		if _, ok := ids[name]; !ok {
+			ids[name] = uuid.Must(uuid.NewV4())
+		}
+		return ids[name]
+	}
+	var comments []Comment
+	for _, entry := range entries {
+		c := Comment{Content: entry}
+		if parent, name, ok := strings.Cut(entry, "/"); ok {
+			parentID := id(parent)
+			c.ParentID = &parentID
+			c.Content = name
+		}
+		c.ID = id(c.Content)
+		comments = append(comments, c)
+	}
+	return comments, ids
+}
+
+// shape renders a tree as name(replycount)[replies] for comparison
+func shape(level []*Comment) string {
+	var parts []string
+	for _, c := range level {
+		s := fmt.Sprintf("%s(%d)", c.Content, c.ReplyCount)
+		if len(c.Replies) > 0 {
+			s += "[" + shape(c.Replies) + "]"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestBuildCommentTree(t *testing.T) {
+	entries := []string{
+		"a", "b", "c",
+		"a/a1", "a/a2", "a/a3",
+		"a1/a1x", "a1x/a1xy",
+		"b/b1",
+		"gone/orphan",
+	}
+	tests := []struct {
+		name   string
+		parent string
+		opts   CommentTreeOptions
+		want   string
+	}{
+		{"top level only", "", CommentTreeOptions{MaxDepth: 1}, "a(3) b(1) c(0)"},
+		{"depth zero means one level", "", CommentTreeOptions{}, "a(3) b(1) c(0)"},
+		{"depth cut off", "", CommentTreeOptions{MaxDepth: 3}, "a(3)[a1(1)[a1x(1)] a2(0) a3(0)] b(1)[b1(0)] c(0)"},
+		{"whole thread", "", CommentTreeOptions{MaxDepth: MaxCommentDepth}, "a(3)[a1(1)[a1x(1)[a1xy(0)]] a2(0) a3(0)] b(1)[b1(0)] c(0)"},
+		{"limit applies per level", "", CommentTreeOptions{MaxDepth: 2, Limit: 2}, "a(3)[a1(1) a2(0)] b(1)[b1(0)]"},
+		{"offset applies to the first level", "", CommentTreeOptions{MaxDepth: 2, Limit: 1, Offset: 1}, "b(1)[b1(0)]"},
+		{"offset past the end", "", CommentTreeOptions{Offset: 3}, ""},
+		{"subtree", "a", CommentTreeOptions{MaxDepth: 2, Offset: 1}, "a2(0) a3(0)"},
+		{"deep subtree", "a1x", CommentTreeOptions{MaxDepth: 5}, "a1xy(0)"},
+		{"leaf", "c", CommentTreeOptions{MaxDepth: 2}, ""},
+		{"orphans are reachable by their parent only", "gone", CommentTreeOptions{}, "orphan(0)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comments, ids := thread(entries...)
+			opts := tt.opts
+			if tt.parent != "" {
+				parentID := ids[tt.parent]
+				opts.ParentID = &parentID
+			}
+			tree := BuildCommentTree(comments, opts)
+			if tree == nil {
+				t.Fatal("tree is nil, want an empty list")
+			}
+			if got := shape(tree); got != tt.want {
+				t.Errorf("tree = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// GetTwoFactor returns the user's TOTP enrollment, or sql.ErrNoRows when
+// they have none
+func (s *SQLStore) GetTwoFactor(userID uuid.UUID) (*TwoFactor, error) {
+	var tf TwoFactor
+	err := s.db.QueryRow("SELECT user_id, secret, created_at, enabled_at, last_step FROM two_factor WHERE user_id = ?", userID).
+		Scan(&tf.UserID, &tf.Secret, &tf.CreatedAt, &tf.EnabledAt, &tf.LastStep)
+	if err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// SetTwoFactorSecret starts a new pending enrollment, replacing any earlier
+// pending one. An enabled enrollment is left untouched.
+func (s *SQLStore) SetTwoFactorSecret(userID uuid.UUID, secret string) error {
+	_, err := s.db.Exec(`
+        INSERT INTO two_factor (user_id, secret, created_at, last_step)
+        VALUES (?, ?, ?, 0)
+        ON CONFLICT (user_id) DO UPDATE
+        SET secret = excluded.secret, created_at = excluded.created_at, last_step = 0
+        WHERE two_factor.enabled_at IS NULL`, userID, secret, time.Now())
+	return err
+}
+
+// EnableTwoFactor confirms a pending enrollment with the time step of the
+// code that confirmed it and stores the user's recovery codes. It returns
+// sql.ErrNoRows if there is no pending enrollment.
+func (s *SQLStore) EnableTwoFactor(userID uuid.UUID, step int64, codeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE two_factor SET enabled_at = ?, last_step = ? WHERE user_id = ? AND enabled_at IS NULL", time.Now(), step, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTwoFactor removes the user's enrollment and recovery codes
+func (s *SQLStore) DisableTwoFactor(userID uuid.UUID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM two_factor WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code from the given time step was accepted. It
+// reports false if that step or a later one was already used, so each code
+// only works once.
+func (s *SQLStore) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	res, err := s.db.Exec("UPDATE two_factor SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ReplaceRecoveryCodes discards the user's recovery codes and stores new ones
+func (s *SQLStore) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *tx, userID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (code_hash, user_id, created_at) VALUES (?, ?, ?)", hash, userID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks one of the user's unused recovery codes as used and
+// reports whether it was valid
+func (s *SQLStore) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	res, err := s.db.Exec("UPDATE recovery_codes SET used_at = ? WHERE code_hash = ? AND user_id = ? AND used_at IS NULL", time.Now(), codeHash, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has left
+func (s *SQLStore) CountRecoveryCodes(userID uuid.UUID) (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n)
+	return n, err
+}
+
+// CreatePendingLogin stores the hash of a token issued after a correct
+// password, which is exchanged for a session once the second factor is given
+func (s *SQLStore) CreatePendingLogin(userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	_, err := s.db.Exec("INSERT INTO pending_logins (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)", tokenHash, userID, time.Now(), expiresAt.Unix())
+	return err
+}
+
+// AttemptPendingLogin counts an attempt to complete a pending login and
+// returns its user. Expired tokens and tokens that have had maxAttempts
+// attempts return ErrInvalidToken.
+func (s *SQLStore) AttemptPendingLogin(tokenHash string, maxAttempts int) (uuid.UUID, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE pending_logins SET attempts = attempts + 1 WHERE token_hash = ? AND expires_at > ? AND attempts < ?", tokenHash, time.Now().Unix(), maxAttempts)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if n == 0 {
+		return uuid.Nil, ErrInvalidToken
+	}
+	var userID uuid.UUID
+	if err := tx.QueryRow("SELECT user_id FROM pending_logins WHERE token_hash = ?", tokenHash).Scan(&userID); err != nil {
+		return uuid.Nil, err
+	}
+	return userID, tx.Commit()
+}
+
+// DeletePendingLogin removes a pending login once it has been completed
+func (s *SQLStore) DeletePendingLogin(tokenHash string) error {
+	_, err := s.db.Exec("DELETE FROM pending_logins WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+// RoleRequiresTwoFactor reports whether admins have made 2FA mandatory for
+// role
+func (s *SQLStore) RoleRequiresTwoFactor(role string) (bool, error) {
+	var required bool
+	err := s.db.QueryRow("SELECT require_2fa FROM role_policies WHERE role = ?", role).Scan(&required)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return required, err
+}
+
+// SetRoleRequiresTwoFactor makes 2FA mandatory or optional for role
+func (s *SQLStore) SetRoleRequiresTwoFactor(role string, required bool) error {
+	_, err := s.db.Exec(`
+        INSERT INTO role_policies (role, require_2fa) VALUES (?, ?)
+        ON CONFLICT (role) DO UPDATE SET require_2fa = excluded.require_2fa`, role, required)
+	return err
+}
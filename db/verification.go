@@ -0,0 +1,41 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// CreateEmailVerification stores the hash of an email verification token
+func (s *SQLStore) CreateEmailVerification(userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	_, err := s.db.Exec("INSERT INTO email_verifications (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)", tokenHash, userID, time.Now(), expiresAt.Unix())
+	return err
+}
+
+// VerifyEmail consumes an email verification token, marks the user's email
+// as verified and returns the user
+func (s *SQLStore) VerifyEmail(tokenHash string) (uuid.UUID, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	var userID uuid.UUID
+	err = tx.QueryRow("SELECT user_id FROM email_verifications WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now().Unix()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, ErrInvalidToken
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	now := time.Now()
+	if _, err := tx.Exec("UPDATE users SET email_verified_at = ? WHERE user_id = ? AND email_verified_at IS NULL", now, userID); err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := tx.Exec("UPDATE email_verifications SET used_at = ? WHERE user_id = ? AND used_at IS NULL", now, userID); err != nil {
+		return uuid.Nil, err
+	}
+	return userID, tx.Commit()
+}
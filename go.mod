@@ -1,10 +1,11 @@
 module forum
 
-go 1.22
+go 1.23.0
 
 require (
 	github.com/gofrs/uuid/v5 v5.3.1
 	github.com/gorilla/websocket v1.5.3
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.28
 	golang.org/x/crypto v0.38.0
-)
\ No newline at end of file
+)
@@ -3,61 +3,102 @@ package handlers
 import (
 	"fmt"
 	"forum/db"
+	"log"
 	"net/http"
-	"time"
+
+	"github.com/gofrs/uuid/v5"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-func SignupProcess(w http.ResponseWriter, r *http.Request) {
-	username := r.FormValue("signupUsername")
-	email := r.FormValue("email")
-	firstName := r.FormValue("firstname")
-	lastName := r.FormValue("lastname")
-	age := r.FormValue("age")
-	gender := r.FormValue("gender")
-	password := r.FormValue("signupPassword")
-	encryptedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// SignupProcess validates the registration form and creates the account.
+// Invalid fields are reported as FieldErrors.
+func (s *Server) SignupProcess(w http.ResponseWriter, r *http.Request) {
+	form, errs := parseSignupForm(r, s.config.Passwords)
+	if len(errs) == 0 {
+		usernameTaken, emailTaken, err := s.store.CheckUserAvailability(form.Username, form.Email)
+		if err != nil {
+			log.Printf("Error checking user availability: %v", err)
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			return
+		}
+		if usernameTaken {
+			errs.add("signupUsername", "Username is already taken")
+		}
+		if emailTaken {
+			errs.add("email", "Email is already registered")
+		}
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+
+	encryptedPassword, err := bcrypt.GenerateFromPassword([]byte(form.Password), bcrypt.DefaultCost)
 	if err != nil {
 		http.Error(w, "Error hashing password", http.StatusInternalServerError)
 		return
 	}
-	data := []interface{}{username, age, gender, firstName, lastName, email, string(encryptedPassword)}
-	_, err = db.RegisterUser(data)
+	user := db.User{
+		Username:  form.Username,
+		FirstName: form.FirstName,
+		LastName:  form.LastName,
+		Age:       form.Age,
+		Gender:    form.Gender,
+		Password:  string(encryptedPassword),
+		Email:     form.Email,
+	}
+	userID, err := s.store.RegisterUser(user)
+	if err == db.ErrUsernameTaken {
+		writeFieldErrors(w, FieldErrors{"signupUsername": "Username is already taken"})
+		return
+	}
+	if err == db.ErrEmailTaken {
+		writeFieldErrors(w, FieldErrors{"email": "Email is already registered"})
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
+	user.Id = userID
+	if err := s.sendVerificationEmail(&user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+	}
 	fmt.Fprintf(w, "User created successfully!")
 }
 
-func LoginProcess(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LoginProcess(w http.ResponseWriter, r *http.Request) {
 	usernameOrEmail := r.FormValue("username")
 	password := r.FormValue("password")
-	login, err := db.LoginUser(db.DB, usernameOrEmail, password)
+	userID, err := s.store.GetUserIDByUsernameOrEmail(usernameOrEmail)
+	if err == nil && !s.checkAccountLock(w, r, userID) {
+		return
+	}
+	login, err := s.store.LoginUser(usernameOrEmail, password)
 	if err != nil {
+		if userID != uuid.Nil {
+			s.recordLoginFailure(r, userID, loginReasonPassword)
+		}
 		w.WriteHeader(http.StatusForbidden)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	userID, err := db.GetUserIDByUsernameOrEmail(usernameOrEmail)
+	tf, err := s.enabledTwoFactor(userID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to get user ID"))
+		w.Write([]byte("Failed to get 2FA status"))
 		return
 	}
-	token, err := NewSession(w, login.Username, userID)
-	if err != nil {
+	if tf != nil {
+		s.startPendingLogin(w, userID)
+		return
+	}
+	if err := s.completeLogin(w, r, userID, login.Username); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to create session"))
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_token",
-		Value:   token,
-		Expires: time.Now().Add(24 * time.Hour),
-	})
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Login successful"))
 }
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"forum/db"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Scopes a personal API token can be granted
+const (
+	ScopeRead    = "read"
+	ScopePost    = "post"
+	ScopeMessage = "message"
+)
+
+var apiTokenScopes = map[string]bool{ScopeRead: true, ScopePost: true, ScopeMessage: true}
+
+const (
+	// apiTokenPrefix marks personal API tokens, telling them apart from
+	// session tokens that the web client also sends as bearer tokens
+	apiTokenPrefix = "forum_pat_"
+	// defaultAPITokenDays is the lifetime of a token when none is given
+	defaultAPITokenDays = 90
+	// maxAPITokenDays caps the lifetime of a token
+	maxAPITokenDays = 365
+	// apiTokenTouchInterval limits how often last-used times are written
+	apiTokenTouchInterval = time.Minute
+)
+
+type contextKey int
+
+const (
+	// userIDKey holds the ID of the user RequireLogin authenticated
+	userIDKey contextKey = iota
+	// userRoleKey holds that user's role
+	userRoleKey
+	// tokenScopeKey holds the scope AllowToken requires of API tokens
+	tokenScopeKey
+)
+
+// AllowToken makes a route usable with personal API tokens that have the
+// given scope. It must wrap RequireLogin; routes without it only accept
+// sessions.
+func (s *Server) AllowToken(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenScopeKey, scope)))
+	})
+}
+
+// bearerAPIToken returns the personal API token in the Authorization
+// header, or "" when there is none
+func bearerAPIToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(token, apiTokenPrefix) {
+		return ""
+	}
+	return token
+}
+
+// authenticateAPIToken checks a bearer API token against the scope of the
+// route and records its use. It writes the error response and returns nil
+// when the request should not go ahead.
+func (s *Server) authenticateAPIToken(w http.ResponseWriter, r *http.Request, token string) *db.APIToken {
+	apiToken, err := s.store.GetAPIToken(hashSecretToken(token))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid or expired API token", http.StatusUnauthorized)
+		return nil
+	}
+	if err != nil {
+		log.Printf("Error loading API token: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	scope, ok := r.Context().Value(tokenScopeKey).(string)
+	if !ok {
+		http.Error(w, "This endpoint cannot be used with an API token", http.StatusForbidden)
+		return nil
+	}
+	if !apiToken.HasScope(scope) {
+		http.Error(w, "API token is missing the "+scope+" scope", http.StatusForbidden)
+		return nil
+	}
+	now := time.Now()
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) >= apiTokenTouchInterval {
+		if err := s.store.TouchAPIToken(apiToken.ID, now); err != nil {
+			log.Printf("Error updating API token: %v", err)
+		}
+	}
+	return apiToken
+}
+
+// CreateAPITokenHandler creates a personal API token. The token is only
+// included in this response; afterwards only its metadata can be listed.
+func (s *Server) CreateAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var requestData struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays *int     `json:"expires_in_days"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+
+	errs := FieldErrors{}
+	name := strings.TrimSpace(requestData.Name)
+	if name == "" || len([]rune(name)) > 50 {
+		errs.add("name", "Name must be 1 to 50 characters")
+	}
+	var scopes []string
+	seen := map[string]bool{}
+	for _, scope := range requestData.Scopes {
+		if !apiTokenScopes[scope] {
+			errs.add("scopes", "Unknown scope "+scope)
+		} else if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		errs.add("scopes", "At least one scope is required")
+	}
+	days := defaultAPITokenDays
+	if requestData.ExpiresInDays != nil {
+		days = *requestData.ExpiresInDays
+	}
+	if days < 1 || days > maxAPITokenDays {
+		errs.add("expires_in_days", "Tokens must expire within 1 to 365 days")
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+
+	secret, _, err := newSecretToken()
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	token := apiTokenPrefix + secret
+	expiresAt := time.Now().AddDate(0, 0, days)
+	apiToken := db.APIToken{UserID: userID, Name: name, Scopes: scopes, ExpiresAt: &expiresAt}
+	if err := s.store.CreateAPIToken(&apiToken, hashSecretToken(token)); err != nil {
+		log.Printf("Error creating API token: %v", err)
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Token string `json:"token"`
+		db.APIToken
+	}{token, apiToken})
+}
+
+// GetAPITokensHandler lists the user's personal API tokens
+func (s *Server) GetAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	tokens, err := s.store.GetUserAPITokens(userID)
+	if err != nil {
+		log.Printf("Error getting API tokens: %v", err)
+		http.Error(w, "Failed to get tokens", http.StatusInternalServerError)
+		return
+	}
+	if tokens == nil {
+		tokens = []db.APIToken{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// RevokeAPITokenHandler deletes one of the user's personal API tokens
+func (s *Server) RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var requestData struct {
+		TokenID int `json:"token_id"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil || requestData.TokenID == 0 {
+		http.Error(w, "Token ID is required", http.StatusBadRequest)
+		return
+	}
+	err = s.store.DeleteAPIToken(userID, requestData.TokenID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking API token: %v", err)
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
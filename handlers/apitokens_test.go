@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/db"
+)
+
+// bearerGet sends a GET for path with the API token to h
+func bearerGet(h http.Handler, path, token string) int {
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	h, _, store := csrfTestServer(t)
+	read := addAPIToken(t, store, "alice", ScopeRead)
+	post := addAPIToken(t, store, "alice", ScopeRead, ScopePost)
+
+	tests := []struct {
+		name, token, path string
+		want              int
+	}{
+		{"read token on create-post", read, "/api/create-post", http.StatusForbidden},
+		{"read token on send-message", read, "/api/send-message", http.StatusForbidden},
+		{"post token on send-message", post, "/api/send-message", http.StatusForbidden},
+		{"route without AllowToken", post, "/api/create-api-token", http.StatusForbidden},
+		{"route without AllowToken, sessions", post, "/api/revoke-other-sessions", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, body := (csrfRequest{bearer: tt.token}).do(h, tt.path); code != tt.want {
+				t.Fatalf("status %d, want %d: %s", code, tt.want, body)
+			}
+		})
+	}
+	// A token with the scope gets past authentication to the handler,
+	// which rejects the empty post
+	if code, body := (csrfRequest{bearer: post}).do(h, "/api/create-post"); code == http.StatusUnauthorized || code == http.StatusForbidden {
+		t.Fatalf("post token on create-post: status %d: %s", code, body)
+	}
+	if code := bearerGet(h, "/api/api-tokens", read); code != http.StatusForbidden {
+		t.Fatalf("read token listing tokens: status %d", code)
+	}
+	if code := bearerGet(h, "/api/get-categories", read); code != http.StatusOK {
+		t.Fatalf("read token on get-categories: status %d", code)
+	}
+}
+
+func TestAPITokenExpiredAndRevoked(t *testing.T) {
+	h, _, store := csrfTestServer(t)
+	userID, err := store.GetUserIDByUsernameOrEmail("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := apiTokenPrefix + "expired"
+	past := time.Now().Add(-time.Minute)
+	if err := store.CreateAPIToken(&db.APIToken{UserID: userID, Name: "old", Scopes: []string{ScopeRead}, ExpiresAt: &past}, hashSecretToken(expired)); err != nil {
+		t.Fatal(err)
+	}
+	revoked := addAPIToken(t, store, "alice", ScopeRead)
+	tokens, err := store.GetUserAPITokens(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if token.Name == "test" {
+			if err := store.DeleteAPIToken(userID, token.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for name, token := range map[string]string{"expired": expired, "revoked": revoked, "unknown": apiTokenPrefix + "forged"} {
+		if code := bearerGet(h, "/api/get-categories", token); code != http.StatusUnauthorized {
+			t.Errorf("%s token: status %d", name, code)
+		}
+	}
+}
+
+func TestAPITokenLastUsedThrottled(t *testing.T) {
+	h, _, store := csrfTestServer(t)
+	token := addAPIToken(t, store, "alice", ScopeRead)
+	userID, err := store.GetUserIDByUsernameOrEmail("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastUsed := func() *time.Time {
+		t.Helper()
+		tokens, err := store.GetUserAPITokens(userID)
+		if err != nil || len(tokens) != 1 {
+			t.Fatalf("tokens: %v, %v", tokens, err)
+		}
+		return tokens[0].LastUsedAt
+	}
+	use := func() {
+		t.Helper()
+		if code := bearerGet(h, "/api/get-categories", token); code != http.StatusOK {
+			t.Fatalf("status %d", code)
+		}
+	}
+
+	if lastUsed() != nil {
+		t.Fatal("new token has a last use")
+	}
+	use()
+	first := lastUsed()
+	if first == nil || time.Since(*first) > time.Minute {
+		t.Fatalf("last used after the first request: %v", first)
+	}
+	use()
+	if again := lastUsed(); again == nil || !again.Equal(*first) {
+		t.Fatalf("last used rewritten within the interval: %v, then %v", first, again)
+	}
+
+	// Once the interval has passed the next use is recorded
+	stale := time.Now().Add(-apiTokenTouchInterval - time.Second)
+	tokens, _ := store.GetUserAPITokens(userID)
+	if err := store.TouchAPIToken(tokens[0].ID, stale); err != nil {
+		t.Fatal(err)
+	}
+	use()
+	if latest := lastUsed(); latest == nil || !latest.After(stale.Add(time.Second)) {
+		t.Fatalf("last used not updated after the interval: %v", latest)
+	}
+}
+
+func TestCreateAPITokenHandler(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	addUser(t, store, "alice", true)
+	session, _ := newTestSession(t, srv, "alice")
+
+	tests := []struct {
+		name, body string
+		fields     []string // fields with errors, none when the token is created
+	}{
+		{"valid", `{"name": "ci", "scopes": ["read", "post", "read"]}`, nil},
+		{"lifetime at the cap", `{"name": "ci", "scopes": ["read"], "expires_in_days": 365}`, nil},
+		{"missing name", `{"scopes": ["read"]}`, []string{"name"}},
+		{"blank name", `{"name": "   ", "scopes": ["read"]}`, []string{"name"}},
+		{"long name", `{"name": "` + strings.Repeat("n", 51) + `", "scopes": ["read"]}`, []string{"name"}},
+		{"no scopes", `{"name": "ci", "scopes": []}`, []string{"scopes"}},
+		{"unknown scope", `{"name": "ci", "scopes": ["read", "admin"]}`, []string{"scopes"}},
+		{"zero days", `{"name": "ci", "scopes": ["read"], "expires_in_days": 0}`, []string{"expires_in_days"}},
+		{"negative days", `{"name": "ci", "scopes": ["read"], "expires_in_days": -5}`, []string{"expires_in_days"}},
+		{"too many days", `{"name": "ci", "scopes": ["read"], "expires_in_days": 366}`, []string{"expires_in_days"}},
+		{"everything wrong", `{"scopes": ["all"], "expires_in_days": 1000}`, []string{"expires_in_days", "name", "scopes"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := callHandler(srv.CreateAPITokenHandler, session, tt.body)
+			if tt.fields == nil {
+				var created struct {
+					Token string `json:"token"`
+					db.APIToken
+				}
+				if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&created) != nil {
+					t.Fatalf("status %d: %s", rec.Code, rec.Body)
+				}
+				if !strings.HasPrefix(created.Token, apiTokenPrefix) || created.ExpiresAt == nil {
+					t.Fatalf("created %+v", created)
+				}
+				if len(created.Scopes) == 0 || created.Scopes[0] != ScopeRead || (len(created.Scopes) > 1 && created.Scopes[1] == ScopeRead) {
+					t.Fatalf("scopes not deduplicated: %v", created.Scopes)
+				}
+				return
+			}
+			var body struct {
+				Errors FieldErrors `json:"errors"`
+			}
+			if rec.Code != http.StatusBadRequest || json.NewDecoder(rec.Body).Decode(&body) != nil {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if len(body.Errors) != len(tt.fields) {
+				t.Fatalf("errors %v, want fields %v", body.Errors, tt.fields)
+			}
+			for _, field := range tt.fields {
+				if body.Errors[field] == "" {
+					t.Errorf("no error for %s in %v", field, body.Errors)
+				}
+			}
+		})
+	}
+
+	// The default lifetime applies when none is given
+	rec := callHandler(srv.CreateAPITokenHandler, session, `{"name": "default", "scopes": ["read"]}`)
+	var created db.APIToken
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if days := time.Until(*created.ExpiresAt).Hours() / 24; days < defaultAPITokenDays-1 || days > defaultAPITokenDays {
+		t.Fatalf("default token expires in %.1f days", days)
+	}
+	if rec := callHandler(srv.CreateAPITokenHandler, session, `{"name":`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid JSON: status %d", rec.Code)
+	}
+	if rec := callHandler(srv.CreateAPITokenHandler, "", `{"name": "ci", "scopes": ["read"]}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without a session: status %d", rec.Code)
+	}
+}
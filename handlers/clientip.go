@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the networks of reverse proxies whose forwarding
+// headers are believed
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses a comma separated list of CIDRs and single
+// addresses
+func ParseTrustedProxies(value string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// Contains reports whether addr belongs to a trusted proxy
+func (tp TrustedProxies) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range tp {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Headers a trusted proxy can give the client address in
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderForwarded     = "Forwarded"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// ParseProxyHeader checks that value names a supported forwarding header
+// and returns its canonical form. The empty string selects
+// X-Forwarded-For.
+func ParseProxyHeader(value string) (string, error) {
+	if value == "" {
+		return HeaderXForwardedFor, nil
+	}
+	for _, header := range []string{HeaderXForwardedFor, HeaderForwarded, HeaderXRealIP} {
+		if strings.EqualFold(value, header) {
+			return header, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported proxy header %q", value)
+}
+
+// clientIP returns the address of the client. The configured forwarding
+// header is only followed when the request comes from a trusted proxy, and
+// then from right to left up to the first hop that is not itself a trusted
+// proxy, so clients cannot choose their address by sending the header
+// themselves. Other forwarding headers are ignored: the proxy passes them
+// on untouched, so a client could set them to anything.
+func (s *Server) clientIP(r *http.Request) string {
+	trusted := s.config.TrustedProxies
+	remote, ok := parseHop(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !trusted.Contains(remote) {
+		return remote.String()
+	}
+
+	header := s.config.ProxyHeader
+	if header == HeaderXRealIP {
+		if realIP, ok := parseHop(r.Header.Get(HeaderXRealIP)); ok {
+			return realIP.String()
+		}
+		return remote.String()
+	}
+	hops := forwardedHops(r.Header, header)
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			// Unknown or obfuscated hops end the chain we can vouch for
+			break
+		}
+		client = addr
+		if !trusted.Contains(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedHops returns the client chain of header, either Forwarded or
+// X-Forwarded-For, in the order the hops were added
+func forwardedHops(h http.Header, header string) []string {
+	var hops []string
+	if header == HeaderForwarded {
+		for _, value := range h.Values(HeaderForwarded) {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+					if found && strings.EqualFold(key, "for") {
+						hops = append(hops, strings.Trim(val, `"`))
+					}
+				}
+			}
+		}
+		return hops
+	}
+	for _, value := range h.Values(HeaderXForwardedFor) {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseHop parses an address with an optional port, as found in RemoteAddr
+// and forwarding headers. IPv6 addresses with a port are bracketed.
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	hop = strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")
+	addr, err := netip.ParseAddr(hop)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// clientKey is the rate limit key of a client address. IPv6 clients are
+// usually given a whole /64, so addresses are grouped by it; otherwise a
+// client could use a fresh address for every request.
+func clientKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() {
+		return ip
+	}
+	prefix, err := addr.Prefix(64)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
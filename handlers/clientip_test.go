@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 2001:db8:ffff::/48, 192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		header  string // the configured proxy header
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted remote ignores XFF", HeaderXForwardedFor, "203.0.113.5:1234",
+			map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.5"},
+		{"untrusted remote ignores Forwarded", HeaderForwarded, "203.0.113.5:1234",
+			map[string]string{"Forwarded": "for=1.2.3.4"}, "203.0.113.5"},
+		{"untrusted remote ignores X-Real-IP", HeaderXRealIP, "203.0.113.5:1234",
+			map[string]string{"X-Real-IP": "1.2.3.4"}, "203.0.113.5"},
+		{"trusted proxy without header", HeaderXForwardedFor, "10.0.0.1:80", nil, "10.0.0.1"},
+		{"trusted proxy with XFF", HeaderXForwardedFor, "10.0.0.1:80",
+			map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"walk stops at the first untrusted hop", HeaderXForwardedFor, "10.0.0.1:80",
+			map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		{"chain of only trusted proxies", HeaderXForwardedFor, "10.0.0.1:80",
+			map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"unknown hop ends the chain", HeaderXForwardedFor, "10.0.0.1:80",
+			map[string]string{"X-Forwarded-For": "198.51.100.7, unknown"}, "10.0.0.1"},
+		{"spoofed Forwarded ignored when XFF is configured", HeaderXForwardedFor, "10.0.0.1:80",
+			map[string]string{"Forwarded": "for=1.2.3.4", "X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"spoofed Forwarded alone ignored when XFF is configured", HeaderXForwardedFor, "10.0.0.1:80",
+			map[string]string{"Forwarded": "for=1.2.3.4"}, "10.0.0.1"},
+		{"spoofed X-Real-IP ignored when XFF is configured", HeaderXForwardedFor, "10.0.0.1:80",
+			map[string]string{"X-Real-IP": "1.2.3.4"}, "10.0.0.1"},
+		{"spoofed XFF ignored when Forwarded is configured", HeaderForwarded, "10.0.0.1:80",
+			map[string]string{"Forwarded": "for=198.51.100.7", "X-Forwarded-For": "1.2.3.4"}, "198.51.100.7"},
+		{"Forwarded walk", HeaderForwarded, "10.0.0.1:80",
+			map[string]string{"Forwarded": "for=1.2.3.4;proto=https, for=198.51.100.7;by=10.0.0.1, for=10.0.0.2"}, "198.51.100.7"},
+		{"Forwarded obfuscated hop", HeaderForwarded, "10.0.0.1:80",
+			map[string]string{"Forwarded": "for=198.51.100.7, for=_hidden"}, "10.0.0.1"},
+		{"Forwarded unknown hop", HeaderForwarded, "10.0.0.1:80",
+			map[string]string{"Forwarded": "for=unknown"}, "10.0.0.1"},
+		{"Forwarded quoted IPv6 with port", HeaderForwarded, "10.0.0.1:80",
+			map[string]string{"Forwarded": `for="[2001:db8::1]:4711"`}, "2001:db8::1"},
+		{"Forwarded key is case insensitive", HeaderForwarded, "10.0.0.1:80",
+			map[string]string{"Forwarded": "For=198.51.100.7"}, "198.51.100.7"},
+		{"XFF port stripped", HeaderXForwardedFor, "10.0.0.1:80",
+			map[string]string{"X-Forwarded-For": "198.51.100.7:5555"}, "198.51.100.7"},
+		{"XFF bracketed IPv6 with port", HeaderXForwardedFor, "10.0.0.1:80",
+			map[string]string{"X-Forwarded-For": "[2001:db8::2]:443"}, "2001:db8::2"},
+		{"X-Real-IP from trusted proxy", HeaderXRealIP, "10.0.0.1:80",
+			map[string]string{"X-Real-IP": "198.51.100.7", "X-Forwarded-For": "1.2.3.4"}, "198.51.100.7"},
+		{"invalid X-Real-IP falls back to the proxy", HeaderXRealIP, "10.0.0.1:80",
+			map[string]string{"X-Real-IP": "garbage"}, "10.0.0.1"},
+		{"IPv4-mapped remote is trusted as IPv4", HeaderXForwardedFor, "[::ffff:10.0.0.1]:80",
+			map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"IPv4-mapped hop is unmapped", HeaderXForwardedFor, "10.0.0.1:80",
+			map[string]string{"X-Forwarded-For": "::ffff:198.51.100.7"}, "198.51.100.7"},
+		{"single trusted address", HeaderXForwardedFor, "192.0.2.10:80",
+			map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"neighbour of a single trusted address", HeaderXForwardedFor, "192.0.2.11:80",
+			map[string]string{"X-Forwarded-For": "198.51.100.7"}, "192.0.2.11"},
+		{"trusted IPv6 proxy", HeaderXForwardedFor, "[2001:db8:ffff::1]:80",
+			map[string]string{"X-Forwarded-For": "2001:db8:1::5"}, "2001:db8:1::5"},
+		{"unparsable RemoteAddr is kept", HeaderXForwardedFor, "pipe",
+			map[string]string{"X-Forwarded-For": "1.2.3.4"}, "pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &Server{config: Config{TrustedProxies: proxies, ProxyHeader: tt.header}}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := srv.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies(" 10.1.2.3/8 ,, ::ffff:192.0.2.1 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 2 || proxies[0].String() != "10.0.0.0/8" || proxies[1].String() != "192.0.2.1/32" {
+		t.Fatalf("proxies = %v", proxies)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "proxy.example", "10.0.0.1/8/8"} {
+		if _, err := ParseTrustedProxies(bad); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	for value, want := range map[string]string{
+		"":                HeaderXForwardedFor,
+		"x-forwarded-for": HeaderXForwardedFor,
+		"Forwarded":       HeaderForwarded,
+		"X-REAL-IP":       HeaderXRealIP,
+	} {
+		if got, err := ParseProxyHeader(value); err != nil || got != want {
+			t.Errorf("ParseProxyHeader(%q) = %q, %v; want %q", value, got, err, want)
+		}
+	}
+	if _, err := ParseProxyHeader("CF-Connecting-IP"); err == nil {
+		t.Error("unsupported header accepted")
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := []struct{ ip, want string }{
+		{"198.51.100.7", "198.51.100.7"},
+		{"2001:db8:1:2:aaaa::1", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:bbbb::9", "2001:db8:1:2::/64"},
+		{"2001:db8:1:3::1", "2001:db8:1:3::/64"},
+		{"not-an-ip", "not-an-ip"},
+	}
+	for _, tt := range tests {
+		if got := clientKey(tt.ip); got != tt.want {
+			t.Errorf("clientKey(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -1,85 +1,156 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"database/sql"
+	"forum/db"
+	"log"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 )
 
-// CSRFToken represents a CSRF token with expiration
-type CSRFToken struct {
-	Token     string
-	ExpiresAt time.Time
-}
+const (
+	csrfTokenExpiry = 1 * time.Hour
+	// csrfSweepInterval is how often expired CSRF tokens are purged
+	csrfSweepInterval = 10 * time.Minute
+	// preSessionCookie identifies browsers without a session, so that the
+	// login and registration forms can carry CSRF tokens too
+	preSessionCookie = "csrf_id"
+	preSessionTTL    = 24 * time.Hour
+)
 
-// CSRFStore stores CSRF tokens per session
-type CSRFStore struct {
-	tokens map[string]CSRFToken
-	mu     sync.RWMutex
+// CSRFStore issues and checks CSRF tokens. Tokens are bound to a key, which
+// is the session token or, before login, the pre-session cookie.
+type CSRFStore interface {
+	// Generate issues a new token for key
+	Generate(key string) (string, error)
+	// Validate reports whether token was issued for key and is unexpired
+	Validate(key, token string) bool
+	// Revoke invalidates every token issued for key
+	Revoke(key string) error
+	// Close stops the background sweeper
+	Close() error
 }
 
-// Global CSRF store
-var csrfStore = &CSRFStore{
-	tokens: make(map[string]CSRFToken),
+// dbCSRFStore is a CSRFStore backed by the csrf_tokens table. Tokens and
+// keys are stored hashed.
+type dbCSRFStore struct {
+	store     db.Store
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-const csrfTokenLength = 32
-const csrfTokenExpiry = 1 * time.Hour
+// NewDBCSRFStore creates a CSRFStore backed by store and starts a
+// background sweeper that purges expired tokens until it is closed
+func NewDBCSRFStore(store db.Store) CSRFStore {
+	cs := &dbCSRFStore{store: store, done: make(chan struct{})}
+	go cs.sweep()
+	return cs
+}
 
-// GenerateCSRFToken generates a new CSRF token for a session
-func GenerateCSRFToken(sessionToken string) (string, error) {
-	bytes := make([]byte, csrfTokenLength)
-	if _, err := rand.Read(bytes); err != nil {
+func (cs *dbCSRFStore) Generate(key string) (string, error) {
+	token, hash, err := newSecretToken()
+	if err != nil {
 		return "", err
 	}
-	token := base64.URLEncoding.EncodeToString(bytes)
+	if err := cs.store.CreateCSRFToken(hash, hashSecretToken(key), time.Now().Add(csrfTokenExpiry)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
 
-	csrfStore.mu.Lock()
-	csrfStore.tokens[sessionToken] = CSRFToken{
-		Token:     token,
-		ExpiresAt: time.Now().Add(csrfTokenExpiry),
+func (cs *dbCSRFStore) Validate(key, token string) bool {
+	if key == "" || token == "" {
+		return false
+	}
+	ok, err := cs.store.CheckCSRFToken(hashSecretToken(token), hashSecretToken(key))
+	if err != nil {
+		log.Printf("Error checking CSRF token: %v", err)
+		return false
 	}
-	csrfStore.mu.Unlock()
+	return ok
+}
 
-	return token, nil
+func (cs *dbCSRFStore) Revoke(key string) error {
+	return cs.store.DeleteCSRFTokens(hashSecretToken(key))
 }
 
-// ValidateCSRFToken validates a CSRF token for a session
-func ValidateCSRFToken(sessionToken, csrfToken string) bool {
-	csrfStore.mu.RLock()
-	stored, exists := csrfStore.tokens[sessionToken]
-	csrfStore.mu.RUnlock()
+func (cs *dbCSRFStore) Close() error {
+	cs.closeOnce.Do(func() { close(cs.done) })
+	return nil
+}
 
-	if !exists {
-		return false
+// sweep periodically purges expired tokens until the store is closed
+func (cs *dbCSRFStore) sweep() {
+	ticker := time.NewTicker(csrfSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := cs.store.DeleteExpiredCSRFTokens(); err != nil {
+				log.Printf("Failed to remove expired CSRF tokens: %v", err)
+			}
+		case <-cs.done:
+			return
+		}
 	}
+}
+
+// csrfKey returns what the request's CSRF tokens are bound to: the session
+// cookie, or the pre-session cookie for visitors who are not logged in
+func csrfKey(r *http.Request) string {
+	if token, err := getSessionToken(r); err == nil && token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(preSessionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
 
-	if time.Now().After(stored.ExpiresAt) {
-		// Token expired, remove it
-		csrfStore.mu.Lock()
-		delete(csrfStore.tokens, sessionToken)
-		csrfStore.mu.Unlock()
+// sameOrigin reports whether origin, an Origin header or Referer URL, is the
+// forum itself
+func (s *Server) sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
 		return false
 	}
+	if u.Host == r.Host {
+		return true
+	}
+	base, err := url.Parse(s.config.BaseURL)
+	return err == nil && u.Scheme == base.Scheme && u.Host == base.Host
+}
 
-	return stored.Token == csrfToken
+// checkOrigin rejects requests whose Origin, or Referer when there is no
+// Origin, belongs to another site. Requests with neither are left to the
+// token check.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	return origin == "" || s.sameOrigin(r, origin)
 }
 
-// CSRFMiddleware protects against CSRF attacks
-func CSRFMiddleware(next http.Handler) http.Handler {
+// CSRFMiddleware protects every state-changing request. The token is read
+// from the X-CSRF-Token header or the csrf_token form field, and the origin
+// is checked as a second layer. Requests authenticated only by a valid
+// personal API token are exempt; see apiTokenOnly.
+func (s *Server) CSRFMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip CSRF check for safe methods
 		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
 			next.ServeHTTP(w, r)
 			return
 		}
-
-		// Get session token
-		sessionToken, err := getSessionToken(r)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if s.apiTokenOnly(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !s.checkOrigin(r) {
+			http.Error(w, "Cross-origin request blocked", http.StatusForbidden)
 			return
 		}
 
@@ -88,8 +159,7 @@ func CSRFMiddleware(next http.Handler) http.Handler {
 		if csrfToken == "" {
 			csrfToken = r.FormValue("csrf_token")
 		}
-
-		if !ValidateCSRFToken(sessionToken, csrfToken) {
+		if !s.csrf.Validate(csrfKey(r), csrfToken) {
 			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
 			return
 		}
@@ -98,16 +168,51 @@ func CSRFMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// GetCSRFTokenHandler returns a new CSRF token for the session
-func GetCSRFTokenHandler(w http.ResponseWriter, r *http.Request) {
-	sessionToken, err := getSessionToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+// apiTokenOnly reports whether r carries a valid personal API token and no
+// session cookie. Browsers never add the Authorization header on their own,
+// so such requests cannot be forged, while a request with a session cookie
+// must pass the CSRF check whatever else it carries.
+func (s *Server) apiTokenOnly(r *http.Request) bool {
+	token := bearerAPIToken(r)
+	if token == "" {
+		return false
+	}
+	if _, err := getSessionToken(r); err == nil {
+		return false
+	}
+	_, err := s.store.GetAPIToken(hashSecretToken(token))
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error loading API token: %v", err)
+	}
+	return err == nil
+}
+
+// GetCSRFTokenHandler returns a new CSRF token for the session, starting a
+// pre-session for visitors who are not logged in. Each key keeps only its
+// newest tokens, and the route is rate limited per client, which bounds
+// the tokens anonymous clients can create.
+func (s *Server) GetCSRFTokenHandler(w http.ResponseWriter, r *http.Request) {
+	key := csrfKey(r)
+	if key == "" {
+		var err error
+		key, _, err = newSecretToken()
+		if err != nil {
+			http.Error(w, "Failed to generate CSRF token", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     preSessionCookie,
+			Value:    key,
+			Path:     "/",
+			MaxAge:   int(preSessionTTL / time.Second),
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
 	}
 
-	token, err := GenerateCSRFToken(sessionToken)
+	token, err := s.csrf.Generate(key)
 	if err != nil {
+		log.Printf("Error generating CSRF token: %v", err)
 		http.Error(w, "Failed to generate CSRF token", http.StatusInternalServerError)
 		return
 	}
@@ -116,19 +221,19 @@ func GetCSRFTokenHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(token))
 }
 
-// CleanupExpiredCSRFTokens removes expired CSRF tokens
-func init() {
-	go func() {
-		ticker := time.NewTicker(10 * time.Minute)
-		for range ticker.C {
-			csrfStore.mu.Lock()
-			now := time.Now()
-			for session, token := range csrfStore.tokens {
-				if now.After(token.ExpiresAt) {
-					delete(csrfStore.tokens, session)
-				}
-			}
-			csrfStore.mu.Unlock()
+// rotateCSRFToken is called when a session starts. Tokens of the previous
+// key stop working, and a token for the new session is returned in the
+// X-CSRF-Token header.
+func (s *Server) rotateCSRFToken(w http.ResponseWriter, r *http.Request, sessionToken string) {
+	if key := csrfKey(r); key != "" {
+		if err := s.csrf.Revoke(key); err != nil {
+			log.Printf("Error revoking CSRF tokens: %v", err)
 		}
-	}()
+	}
+	token, err := s.csrf.Generate(sessionToken)
+	if err != nil {
+		log.Printf("Error generating CSRF token: %v", err)
+		return
+	}
+	w.Header().Set("X-CSRF-Token", token)
 }
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"forum/db"
+)
+
+// csrfRoutes are the state-changing routes checked by the CSRF tests
+var csrfRoutes = []string{
+	"/",
+	"/registration",
+	"/logout",
+	"/api/forgot-password",
+	"/api/reset-password",
+	"/api/login-2fa",
+	"/api/create-api-token",
+	"/api/revoke-other-sessions",
+	"/api/create-category",
+	"/api/create-post",
+	"/api/create-comment",
+	"/api/add-post-reaction",
+	"/api/send-message",
+	"/api/update-status",
+}
+
+// csrfTestServer returns the handler of a server whose rate limits do not
+// get in the way, with a verified user alice
+func csrfTestServer(t *testing.T) (http.Handler, *Server, *db.SQLStore) {
+	t.Helper()
+	limits := DefaultRateLimits()
+	for name, rate := range limits.Policies {
+		limits.Policies[name] = Rate{Limit: 1000, Window: rate.Window}
+	}
+	srv, store := newTestServer(t, Config{BaseURL: "http://forum.test", RateLimits: limits})
+	addUser(t, store, "alice", true)
+	return srv.Handler(), srv, store
+}
+
+// newTestSession logs username in and returns the session token and a CSRF
+// token for it
+func newTestSession(t *testing.T, srv *Server, username string) (string, string) {
+	t.Helper()
+	userID, err := srv.store.GetUserIDByUsernameOrEmail(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := srv.sessions.Create(userID, username, "test", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := srv.csrf.Generate(session.SessionToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.SessionToken, token
+}
+
+// addAPIToken creates a personal API token for username with scopes
+func addAPIToken(t *testing.T, store db.Store, username string, scopes ...string) string {
+	t.Helper()
+	userID, err := store.GetUserIDByUsernameOrEmail(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, hash, err := newSecretToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token = apiTokenPrefix + token
+	hash = hashSecretToken(token)
+	if err := store.CreateAPIToken(&db.APIToken{UserID: userID, Name: "test", Scopes: scopes}, hash); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+type csrfRequest struct {
+	session    string
+	preSession *http.Cookie
+	token      string
+	bearer     string
+	origin     string
+}
+
+// withPreSession returns c with the pre-session cookie and token that
+// /api/csrf-token hands a visitor who is not logged in
+func (c csrfRequest) withPreSession(t *testing.T, h http.Handler) csrfRequest {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/csrf-token", nil))
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == preSessionCookie {
+			c.preSession = cookie
+		}
+	}
+	if rec.Code != http.StatusOK || c.preSession == nil {
+		t.Fatalf("csrf-token: status %d, cookie %v", rec.Code, c.preSession)
+	}
+	c.token = rec.Body.String()
+	return c
+}
+
+// do POSTs an empty JSON object to path
+func (c csrfRequest) do(h http.Handler, path string) (int, string) {
+	return c.post(h, path, "application/json", "{}")
+}
+
+// form POSTs the URL encoded body to path
+func (c csrfRequest) form(h http.Handler, path, body string) (int, string) {
+	return c.post(h, path, "application/x-www-form-urlencoded", body)
+}
+
+func (c csrfRequest) post(h http.Handler, path, contentType, body string) (int, string) {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if c.preSession != nil {
+		req.AddCookie(c.preSession)
+	}
+	if c.session != "" {
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: c.session})
+	}
+	if c.token != "" {
+		req.Header.Set("X-CSRF-Token", c.token)
+	}
+	if c.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearer)
+	}
+	if c.origin != "" {
+		req.Header.Set("Origin", c.origin)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	resp, _ := io.ReadAll(rec.Body)
+	return rec.Code, strings.TrimSpace(string(resp))
+}
+
+// blockedByCSRF reports whether the middleware refused the request
+func blockedByCSRF(code int, body string) bool {
+	return code == http.StatusForbidden && (body == "Invalid CSRF token" || body == "Cross-origin request blocked")
+}
+
+func TestCSRFPerRoute(t *testing.T) {
+	h, srv, store := csrfTestServer(t)
+	pat := addAPIToken(t, store, "alice", ScopeRead, ScopePost, ScopeMessage)
+
+	tests := []struct {
+		name    string
+		req     func(session, token string) csrfRequest
+		blocked bool
+	}{
+		{"no token", func(session, token string) csrfRequest { return csrfRequest{session: session} }, true},
+		{"no session or token", func(session, token string) csrfRequest { return csrfRequest{} }, true},
+		{"wrong token", func(session, token string) csrfRequest { return csrfRequest{session: session, token: "forged"} }, true},
+		{"token of another key", func(session, token string) csrfRequest { return csrfRequest{token: token} }, true},
+		{"cross origin", func(session, token string) csrfRequest {
+			return csrfRequest{session: session, token: token, origin: "https://evil.example"}
+		}, true},
+		{"fake API token with session", func(session, token string) csrfRequest {
+			return csrfRequest{session: session, bearer: apiTokenPrefix + "forged"}
+		}, true},
+		{"API token with session", func(session, token string) csrfRequest { return csrfRequest{session: session, bearer: pat} }, true},
+		{"fake API token alone", func(session, token string) csrfRequest { return csrfRequest{bearer: apiTokenPrefix + "forged"} }, true},
+		{"valid token", func(session, token string) csrfRequest { return csrfRequest{session: session, token: token} }, false},
+		{"valid token same origin", func(session, token string) csrfRequest {
+			return csrfRequest{session: session, token: token, origin: "http://forum.test"}
+		}, false},
+		{"API token alone", func(session, token string) csrfRequest { return csrfRequest{bearer: pat} }, false},
+	}
+	for _, route := range csrfRoutes {
+		for _, tt := range tests {
+			t.Run(route+"/"+tt.name, func(t *testing.T) {
+				// Routes such as /logout end the session, so each request
+				// gets its own
+				session, token := newTestSession(t, srv, "alice")
+				code, body := tt.req(session, token).do(h, route)
+				if blockedByCSRF(code, body) != tt.blocked {
+					t.Errorf("status %d %q, blocked by CSRF = %v, want %v", code, body, !tt.blocked, tt.blocked)
+				}
+			})
+		}
+	}
+}
+
+func TestCSRFSafeMethodsPass(t *testing.T) {
+	h, srv, _ := csrfTestServer(t)
+	session, _ := newTestSession(t, srv, "alice")
+	for _, method := range []string{"GET", "HEAD", "OPTIONS"} {
+		req := httptest.NewRequest(method, "/api/get-categories", nil)
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: session})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if blockedByCSRF(rec.Code, strings.TrimSpace(rec.Body.String())) {
+			t.Errorf("%s was blocked", method)
+		}
+	}
+}
+
+func TestCSRFPreSessionRotatesAtLogin(t *testing.T) {
+	h, _, _ := csrfTestServer(t)
+
+	// A visitor gets a pre-session cookie with the first token
+	visitor := csrfRequest{}.withPreSession(t, h)
+	preSession, preToken := visitor.preSession, visitor.token
+
+	login := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("username=alice&password="+testPassword))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-CSRF-Token", token)
+		req.AddCookie(preSession)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := login(preToken)
+	if rec.Code != http.StatusOK || sessionCookie(rec) == nil {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body)
+	}
+	rotated := rec.Header().Get("X-CSRF-Token")
+	if rotated == "" || rotated == preToken {
+		t.Fatal("login did not rotate the CSRF token")
+	}
+
+	// The pre-session token died with the login
+	if rec := login(preToken); !blockedByCSRF(rec.Code, strings.TrimSpace(rec.Body.String())) {
+		t.Errorf("pre-session token reused after login: status %d", rec.Code)
+	}
+	code, body := csrfRequest{session: sessionCookie(rec).Value, token: rotated}.do(h, "/api/update-status")
+	if blockedByCSRF(code, body) {
+		t.Errorf("rotated token refused: %d %s", code, body)
+	}
+}
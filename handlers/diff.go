@@ -0,0 +1,137 @@
+package handlers
+
+import "strings"
+
+// DiffOp is one step of a word level diff
+type DiffOp struct {
+	Op   string `json:"op"` // "equal", "insert" or "delete"
+	Text string `json:"text"`
+}
+
+// maxDiffWords bounds the O((n+m)*d) running time of the diff, where d is
+// the number of changed words; longer texts are reported as a full
+// replacement. Memory stays linear in the length of the texts.
+const maxDiffWords = 5000
+
+// diffWords returns the word level changes needed to turn before into after
+func diffWords(before, after string) []DiffOp {
+	a := strings.Fields(before)
+	b := strings.Fields(after)
+	if len(a) > maxDiffWords || len(b) > maxDiffWords {
+		return appendOp(appendOp(nil, "delete", before), "insert", after)
+	}
+	var d differ
+	d.diff(a, b)
+	return d.ops
+}
+
+// differ implements Myers' linear space diff, splitting the texts at the
+// middle snake of a shortest edit script and diffing both halves
+type differ struct {
+	ops []DiffOp
+}
+
+func (d *differ) diff(a, b []string) {
+	// A common prefix and suffix are always part of a shortest script
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		d.ops = appendOp(d.ops, "equal", a[prefix])
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		for _, word := range b {
+			d.ops = appendOp(d.ops, "insert", word)
+		}
+	case len(b) == 0:
+		for _, word := range a {
+			d.ops = appendOp(d.ops, "delete", word)
+		}
+	default:
+		// Both texts are non-empty and differ at each end, so at least two
+		// edits are needed and each half needs fewer than the whole
+		x, y, u, v := middleSnake(a, b)
+		d.diff(a[:x], b[:y])
+		for _, word := range a[x:u] {
+			d.ops = appendOp(d.ops, "equal", word)
+		}
+		d.diff(a[u:], b[v:])
+	}
+	for _, word := range common {
+		d.ops = appendOp(d.ops, "equal", word)
+	}
+}
+
+// middleSnake returns the snake from (x, y) to (u, v) in the middle of a
+// shortest edit script of a and b. Paths are searched from both ends at
+// once; forward[k] and backward[k] hold the furthest x reached on diagonal
+// k, the backward one counted from the end of the texts.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n + m + 1) / 2
+	offset := limit + 1
+	forward := make([]int, 2*limit+3)
+	backward := make([]int, 2*limit+3)
+
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < m && a[u] == b[v] {
+				u++
+				v++
+			}
+			forward[offset+k] = u
+			// Diagonal k is diagonal delta-k of the backward paths, which
+			// have d-1 edits so far
+			if c := delta - k; odd && c >= -(d-1) && c <= d-1 && u+backward[offset+c] >= n {
+				return x, y, u, v
+			}
+		}
+		for c := -d; c <= d; c += 2 {
+			if c == -d || (c != d && backward[offset+c-1] < backward[offset+c+1]) {
+				x = backward[offset+c+1]
+			} else {
+				x = backward[offset+c-1] + 1
+			}
+			y = x - c
+			u, v = x, y
+			for u < n && v < m && a[n-1-u] == b[m-1-v] {
+				u++
+				v++
+			}
+			backward[offset+c] = u
+			if k := delta - c; !odd && k >= -d && k <= d && u+forward[offset+k] >= n {
+				return n - u, m - v, n - x, m - y
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
+
+// appendOp adds text to ops, merging it into the last op of the same kind
+func appendOp(ops []DiffOp, op, text string) []DiffOp {
+	if text == "" {
+		return ops
+	}
+	if n := len(ops); n > 0 && ops[n-1].Op == op {
+		ops[n-1].Text += " " + text
+		return ops
+	}
+	return append(ops, DiffOp{Op: op, Text: text})
+}
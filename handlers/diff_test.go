@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// lcsLength is the quadratic reference for the number of words diffWords
+// should keep
+func lcsLength(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return lcs[0][0]
+}
+
+// checkDiff verifies that ops turn before into after keeping as many words
+// as possible
+func checkDiff(t *testing.T, before, after string, ops []DiffOp) {
+	t.Helper()
+	var old, new []string
+	kept := 0
+	for _, op := range ops {
+		words := strings.Fields(op.Text)
+		switch op.Op {
+		case "equal":
+			old = append(old, words...)
+			new = append(new, words...)
+			kept += len(words)
+		case "delete":
+			old = append(old, words...)
+		case "insert":
+			new = append(new, words...)
+		default:
+			t.Fatalf("unknown op %q", op.Op)
+		}
+	}
+	if strings.Join(old, " ") != strings.Join(strings.Fields(before), " ") {
+		t.Fatalf("diff of %q -> %q rebuilds before as %q", before, after, old)
+	}
+	if strings.Join(new, " ") != strings.Join(strings.Fields(after), " ") {
+		t.Fatalf("diff of %q -> %q rebuilds after as %q", before, after, new)
+	}
+	if want := lcsLength(strings.Fields(before), strings.Fields(after)); kept != want {
+		t.Fatalf("diff of %q -> %q keeps %d words, want %d", before, after, kept, want)
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+	tests := []struct {
+		before, after string
+		want          []DiffOp
+	}{
+		{"", "", nil},
+		{"a b c", "a b c", []DiffOp{{"equal", "a b c"}}},
+		{"", "a b", []DiffOp{{"insert", "a b"}}},
+		{"a b", "", []DiffOp{{"delete", "a b"}}},
+		{"the quick fox", "the slow fox", []DiffOp{{"equal", "the"}, {"delete", "quick"}, {"insert", "slow"}, {"equal", "fox"}}},
+		{"a b c d", "a c d e", []DiffOp{{"equal", "a"}, {"delete", "b"}, {"equal", "c d"}, {"insert", "e"}}},
+	}
+	for _, tt := range tests {
+		got := diffWords(tt.before, tt.after)
+		if len(got) != len(tt.want) {
+			t.Errorf("diffWords(%q, %q) = %v, want %v", tt.before, tt.after, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("diffWords(%q, %q) = %v, want %v", tt.before, tt.after, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestDiffWordsIsMinimal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	text := func() string {
+		words := make([]string, rng.Intn(30))
+		for i := range words {
+			words[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return strings.Join(words, " ")
+	}
+	for i := 0; i < 2000; i++ {
+		before, after := text(), text()
+		checkDiff(t, before, after, diffWords(before, after))
+	}
+}
+
+func TestDiffWordsLongTexts(t *testing.T) {
+	words := make([]string, maxDiffWords)
+	for i := range words {
+		words[i] = "w" + string(rune('a'+i%26))
+	}
+	before := strings.Join(words, " ")
+	words[maxDiffWords/2] = "changed"
+	after := strings.Join(words, " ")
+	ops := diffWords(before, after)
+	if len(ops) != 4 {
+		t.Fatalf("got %d ops, want a single replaced word", len(ops))
+	}
+	checkDiff(t, before, after, ops)
+
+	// Past the bound the texts are replaced whole
+	ops = diffWords(before+" extra", after)
+	if len(ops) != 2 || ops[0].Op != "delete" || ops[1].Op != "insert" {
+		t.Fatalf("got %v, want a full replacement", ops)
+	}
+}
+
+func BenchmarkDiffWords(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	words := make([]string, maxDiffWords)
+	for i := range words {
+		words[i] = string(rune('a' + rng.Intn(26)))
+	}
+	before := strings.Join(words, " ")
+	for i := 0; i < 50; i++ {
+		words[rng.Intn(len(words))] = "edit"
+	}
+	after := strings.Join(words, " ")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		diffWords(before, after)
+	}
+}
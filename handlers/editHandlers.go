@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"encoding/json"
+	"forum/db"
+	"log"
+	"net/http"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// canModerate reports whether the user may change content written by authorID
+func (s *Server) canModerate(userID, authorID uuid.UUID) bool {
+	if userID == authorID {
+		return true
+	}
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error getting user by ID: %v", err)
+		return false
+	}
+	return (user.Role == db.RoleModerator || user.Role == db.RoleAdmin) && s.twoFactorSatisfied(user)
+}
+
+// EditPostHandler handles editing a post by its author or a moderator
+func (s *Server) EditPostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var requestData struct {
+		PostID  string `json:"post_id"`
+		Title   string `json:"title"`
+		Content string `json:"content"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+
+	if requestData.Title == "" || requestData.Content == "" {
+		http.Error(w, "Title and content are required", http.StatusBadRequest)
+		return
+	}
+
+	post, ok := s.lookupPost(w, requestData.PostID)
+	if !ok {
+		return
+	}
+	if !s.canModerate(userID, post.UserID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	err = s.store.UpdatePost(post.ID, userID, requestData.Title, requestData.Content)
+	if err == db.ErrDeleted {
+		http.Error(w, "Post has been deleted", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating post: %v", err)
+		http.Error(w, "Failed to edit post", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeletePostHandler handles soft deleting a post by its author or a moderator
+func (s *Server) DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var requestData struct {
+		PostID string `json:"post_id"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+
+	post, ok := s.lookupPost(w, requestData.PostID)
+	if !ok {
+		return
+	}
+	if !s.canModerate(userID, post.UserID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	err = s.store.DeletePost(post.ID, userID)
+	if err == db.ErrDeleted {
+		http.Error(w, "Post has already been deleted", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting post: %v", err)
+		http.Error(w, "Failed to delete post", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// EditCommentHandler handles editing a comment by its author or a moderator
+func (s *Server) EditCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var requestData struct {
+		CommentID string `json:"comment_id"`
+		Content   string `json:"content"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+
+	if requestData.Content == "" {
+		http.Error(w, "Content is required", http.StatusBadRequest)
+		return
+	}
+
+	comment, ok := s.lookupComment(w, requestData.CommentID)
+	if !ok {
+		return
+	}
+	if !s.canModerate(userID, comment.UserID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	err = s.store.UpdateComment(comment.ID, userID, requestData.Content)
+	if err == db.ErrDeleted {
+		http.Error(w, "Comment has been deleted", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating comment: %v", err)
+		http.Error(w, "Failed to edit comment", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteCommentHandler handles soft deleting a comment by its author or a moderator
+func (s *Server) DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var requestData struct {
+		CommentID string `json:"comment_id"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+
+	comment, ok := s.lookupComment(w, requestData.CommentID)
+	if !ok {
+		return
+	}
+	if !s.canModerate(userID, comment.UserID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	err = s.store.DeleteComment(comment.ID, userID)
+	if err == db.ErrDeleted {
+		http.Error(w, "Comment has already been deleted", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting comment: %v", err)
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// revisionWithDiff is a revision plus the changes that turned it into the
+// following version
+type revisionWithDiff struct {
+	db.Revision
+	SubjectDiff []DiffOp `json:"subject_diff,omitempty"`
+	ContentDiff []DiffOp `json:"content_diff"`
+}
+
+// GetRevisionsHandler lists the edit history of a post (post_id) or comment
+// (comment_id). History of deleted content is only visible to its author
+// and moderators.
+func (s *Server) GetRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var revisions []db.Revision
+	var currentSubject, currentContent string
+	if postIDStr := r.URL.Query().Get("post_id"); postIDStr != "" {
+		post, ok := s.lookupPost(w, postIDStr)
+		if !ok {
+			return
+		}
+		if post.Deleted && !s.canModerate(userID, post.UserID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		revisions, err = s.store.GetPostRevisions(post.ID)
+		currentSubject, currentContent = post.Subject, post.Content
+	} else {
+		comment, ok := s.lookupComment(w, r.URL.Query().Get("comment_id"))
+		if !ok {
+			return
+		}
+		if comment.Deleted && !s.canModerate(userID, comment.UserID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		revisions, err = s.store.GetCommentRevisions(comment.ID)
+		currentContent = comment.Content
+	}
+	if err != nil {
+		log.Printf("Error getting revisions: %v", err)
+		http.Error(w, "Failed to get revisions", http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]revisionWithDiff, len(revisions))
+	for i, rev := range revisions {
+		nextSubject, nextContent := currentSubject, currentContent
+		if i+1 < len(revisions) {
+			nextSubject, nextContent = revisions[i+1].Subject, revisions[i+1].Content
+		}
+		result[i] = revisionWithDiff{
+			Revision:    rev,
+			SubjectDiff: diffWords(rev.Subject, nextSubject),
+			ContentDiff: diffWords(rev.Content, nextContent),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// lookupPost parses postIDStr and loads the post, writing an error response
+// and returning false if either step fails
+func (s *Server) lookupPost(w http.ResponseWriter, postIDStr string) (*db.Post, bool) {
+	if postIDStr == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return nil, false
+	}
+	postID, err := uuid.FromString(postIDStr)
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return nil, false
+	}
+	post, err := s.store.GetPostByID(postID)
+	if err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return nil, false
+	}
+	return post, true
+}
+
+// lookupComment is the comment counterpart of lookupPost
+func (s *Server) lookupComment(w http.ResponseWriter, commentIDStr string) (*db.Comment, bool) {
+	if commentIDStr == "" {
+		http.Error(w, "Comment ID is required", http.StatusBadRequest)
+		return nil, false
+	}
+	commentID, err := uuid.FromString(commentIDStr)
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return nil, false
+	}
+	comment, err := s.store.GetCommentByID(commentID)
+	if err != nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return nil, false
+	}
+	return comment, true
+}
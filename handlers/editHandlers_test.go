@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEditAndDeleteRequireJSON(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	alice := addUser(t, store, "alice", true)
+	session, _ := newTestSession(t, srv, "alice")
+	post := addPost(t, store, alice, "Hello")
+	comment, err := store.CreateComment(post, alice, nil, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	postBody := `{"post_id": "` + post.String() + `", "title": "Edited", "content": "edited"}`
+	commentBody := `{"comment_id": "` + comment.String() + `", "content": "edited"}`
+	handlers := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"edit post", srv.EditPostHandler, postBody},
+		{"edit comment", srv.EditCommentHandler, commentBody},
+		{"delete comment", srv.DeleteCommentHandler, commentBody},
+		{"delete post", srv.DeletePostHandler, postBody},
+	}
+
+	for _, h := range handlers {
+		for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(h.body))
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+			req.AddCookie(&http.Cookie{Name: "session_token", Value: session})
+			rec := httptest.NewRecorder()
+			h.handler(rec, req)
+			if rec.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("%s as %q: status %d", h.name, contentType, rec.Code)
+			}
+		}
+		if rec := callHandler(h.handler, session, h.body); rec.Code != http.StatusOK {
+			t.Errorf("%s as JSON: status %d: %s", h.name, rec.Code, rec.Body)
+		}
+	}
+}
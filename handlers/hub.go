@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is how long a write to a client may take
+	writeWait = 10 * time.Second
+	// pongWait is how long a client may stay silent, pongs included
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so that pongs arrive in time
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize bounds the frames read from clients
+	maxMessageSize = 8192
+	// sendQueueSize is how many frames may wait for a client. Clients that
+	// fall further behind are dropped rather than holding up everyone else.
+	sendQueueSize = 64
+)
+
+// client is a websocket connection of an authenticated user
+type client struct {
+	hub     *Hub
+	conn    *websocket.Conn
+	userID  uuid.UUID
+	role    string // the user's role when they connected
+	session string
+	// send queues frames for writePump. The hub closes it to disconnect
+	// the client.
+	send chan []byte
+}
+
+// outbound is a frame to deliver to one client, to one user's connections,
+// or to every connection when neither is set
+type outbound struct {
+	client *client
+	userID uuid.UUID
+	data   []byte
+}
+
+// Hub tracks the websocket clients and delivers frames to them. Its state
+// is owned by the run goroutine, and each client has its own writer, so a
+// slow client cannot stall delivery to others.
+type Hub struct {
+	// Keepalive timings of the clients; the defaults unless a test
+	// shortens them
+	pongWait      time.Duration
+	pingPeriod    time.Duration
+	clients       map[*client]bool
+	register      chan *client
+	unregister    chan *client
+	deliver       chan outbound
+	closeSessions chan []string
+}
+
+// NewHub creates a Hub and starts its run goroutine
+func NewHub() *Hub {
+	h := &Hub{
+		pongWait:      pongWait,
+		pingPeriod:    pingPeriod,
+		clients:       make(map[*client]bool),
+		register:      make(chan *client),
+		unregister:    make(chan *client),
+		deliver:       make(chan outbound, 256),
+		closeSessions: make(chan []string),
+	}
+	go h.run()
+	return h
+}
+
+func (h *Hub) run() {
+	for {
+		select {
+		case c := <-h.register:
+			h.clients[c] = true
+		case c := <-h.unregister:
+			h.drop(c)
+		case tokens := <-h.closeSessions:
+			revoked := make(map[string]bool, len(tokens))
+			for _, token := range tokens {
+				revoked[token] = true
+			}
+			for c := range h.clients {
+				if revoked[c.session] {
+					h.drop(c)
+				}
+			}
+		case out := <-h.deliver:
+			for c := range h.clients {
+				if out.client != nil && c != out.client || out.userID != uuid.Nil && c.userID != out.userID {
+					continue
+				}
+				select {
+				case c.send <- out.data:
+				default:
+					log.Printf("Dropping websocket client of user %s: send queue full", c.userID)
+					h.drop(c)
+				}
+			}
+		}
+	}
+}
+
+// drop removes a client and closes its queue, which makes its writer close
+// the connection
+func (h *Hub) drop(c *client) {
+	if h.clients[c] {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// Broadcast sends msg to every connected client
+func (h *Hub) Broadcast(msg interface{}) {
+	h.queue(outbound{}, msg)
+}
+
+// SendToUser sends msg to every connection of the user
+func (h *Hub) SendToUser(userID uuid.UUID, msg interface{}) {
+	if userID == uuid.Nil {
+		return
+	}
+	h.queue(outbound{userID: userID}, msg)
+}
+
+// sendToClient sends msg to a single connection, if it is still open
+func (h *Hub) sendToClient(c *client, msg interface{}) {
+	h.queue(outbound{client: c}, msg)
+}
+
+func (h *Hub) queue(out outbound, msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error encoding websocket message: %v", err)
+		return
+	}
+	out.data = data
+	h.deliver <- out
+}
+
+// CloseSessions disconnects the clients that connected with any of the
+// given session tokens, used when sessions are revoked
+func (h *Hub) CloseSessions(tokens ...string) {
+	if len(tokens) > 0 {
+		h.closeSessions <- tokens
+	}
+}
+
+// readPump reads frames from the client and passes them to handle until
+// the connection fails or the client goes silent for longer than the hub's
+// pongWait
+func (c *client) readPump(handle func(data []byte)) {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+	}()
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Error reading websocket message: %v", err)
+			}
+			return
+		}
+		handle(data)
+	}
+}
+
+// writePump is the only writer of the connection. It sends queued frames
+// and pings, and closes the connection when the hub closes the queue.
+func (c *client) writePump() {
+	ticker := time.NewTicker(c.hub.pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Printf("Error writing to websocket: %v", err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/websocket"
+)
+
+// hubHarness serves websocket connections attached to a Hub. The user and
+// session of a connection come from the query string; with nowriter the
+// client gets no writePump, as if its writer were stuck.
+type hubHarness struct {
+	hub    *Hub
+	server *httptest.Server
+	// connected receives each client once it is registered
+	connected chan *client
+	// closed receives each client once its readPump has returned
+	closed chan *client
+}
+
+func newHubHarness(t *testing.T, hub *Hub) *hubHarness {
+	t.Helper()
+	h := &hubHarness{hub: hub, connected: make(chan *client, 16), closed: make(chan *client, 16)}
+	h.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		q := r.URL.Query()
+		c := &client{
+			hub:     hub,
+			conn:    ws,
+			userID:  uuid.FromStringOrNil(q.Get("user")),
+			session: q.Get("session"),
+			send:    make(chan []byte, sendQueueSize),
+		}
+		hub.register <- c
+		h.connected <- c
+		if q.Get("nowriter") == "" {
+			go c.writePump()
+		}
+		c.readPump(func([]byte) {})
+		h.closed <- c
+	}))
+	t.Cleanup(h.server.Close)
+	return h
+}
+
+// dial connects as user with session and waits for the hub to register the
+// connection
+func (h *hubHarness) dial(t *testing.T, user uuid.UUID, session string, extra string) (*websocket.Conn, *client) {
+	t.Helper()
+	u := "ws" + strings.TrimPrefix(h.server.URL, "http") + "/?user=" + user.String() + "&session=" + session + extra
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	select {
+	case c := <-h.connected:
+		return conn, c
+	case <-time.After(time.Second):
+		t.Fatal("connection was not registered")
+		return nil, nil
+	}
+}
+
+// readFrame reads the next frame's type within timeout
+func readFrame(t *testing.T, conn *websocket.Conn, timeout time.Duration) (string, error) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+	var frame outboundFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		t.Fatalf("invalid frame %s: %v", data, err)
+	}
+	return frame.Type, nil
+}
+
+func expectFrame(t *testing.T, conn *websocket.Conn, want string) {
+	t.Helper()
+	got, err := readFrame(t, conn, time.Second)
+	if err != nil {
+		t.Fatalf("reading %s frame: %v", want, err)
+	}
+	if got != want {
+		t.Fatalf("got %s frame, want %s", got, want)
+	}
+}
+
+// expectClosed waits for the server to close conn
+func expectClosed(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	for {
+		if _, err := readFrame(t, conn, 2*time.Second); err != nil {
+			if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+				t.Fatal("connection was not closed")
+			}
+			return
+		}
+	}
+}
+
+func TestHubRegisterUnregister(t *testing.T) {
+	h := newHubHarness(t, NewHub())
+	alice, bob := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	conn1, _ := h.dial(t, alice, "s1", "")
+	conn2, c2 := h.dial(t, bob, "s2", "")
+
+	h.hub.Broadcast(newFrame("hello", nil))
+	expectFrame(t, conn1, "hello")
+	expectFrame(t, conn2, "hello")
+
+	conn2.Close()
+	select {
+	case c := <-h.closed:
+		if c != c2 {
+			t.Fatal("the wrong client was unregistered")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("closed connection was not unregistered")
+	}
+	// Delivering after the unregistration must neither block nor write to
+	// the closed queue
+	h.hub.Broadcast(newFrame("after", nil))
+	h.hub.SendToUser(bob, newFrame("after", nil))
+	expectFrame(t, conn1, "after")
+}
+
+func TestHubSendToUser(t *testing.T) {
+	h := newHubHarness(t, NewHub())
+	alice, bob := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	alice1, _ := h.dial(t, alice, "s1", "")
+	alice2, _ := h.dial(t, alice, "s2", "")
+	bob1, c := h.dial(t, bob, "s3", "")
+
+	h.hub.SendToUser(alice, newFrame("private", nil))
+	h.hub.sendToClient(c, newFrame("direct", nil))
+	h.hub.Broadcast(newFrame("public", nil))
+
+	expectFrame(t, alice1, "private")
+	expectFrame(t, alice1, "public")
+	expectFrame(t, alice2, "private")
+	expectFrame(t, alice2, "public")
+	// Frames are delivered in order, so Bob would have seen the private
+	// frame before the public one
+	expectFrame(t, bob1, "direct")
+	expectFrame(t, bob1, "public")
+}
+
+func TestHubDropsClientWithFullQueue(t *testing.T) {
+	h := newHubHarness(t, NewHub())
+	alice, bob := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	_, stuck := h.dial(t, alice, "s1", "&nowriter=1")
+	healthy, _ := h.dial(t, bob, "s2", "")
+
+	for i := 0; i <= sendQueueSize; i++ {
+		h.hub.Broadcast(newFrame("flood", nil))
+	}
+	// Once the healthy client has every frame, the hub has handled them all
+	for i := 0; i <= sendQueueSize; i++ {
+		expectFrame(t, healthy, "flood")
+	}
+	// The stuck client's queue holds sendQueueSize frames and is then
+	// closed by the hub
+	for i := 0; i < sendQueueSize; i++ {
+		if _, ok := <-stuck.send; !ok {
+			t.Fatalf("queue closed after %d frames", i)
+		}
+	}
+	select {
+	case _, ok := <-stuck.send:
+		if ok {
+			t.Fatal("queue holds more than sendQueueSize frames")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client with a full queue was not dropped")
+	}
+	// Unregistering a dropped client is harmless
+	stuck.conn.Close()
+	<-h.closed
+	h.hub.Broadcast(newFrame("after", nil))
+	expectFrame(t, healthy, "after")
+}
+
+func TestHubPingPong(t *testing.T) {
+	hub := NewHub()
+	hub.pongWait = 300 * time.Millisecond
+	hub.pingPeriod = 100 * time.Millisecond
+	h := newHubHarness(t, hub)
+
+	// A client that reads answers pings with pongs and stays connected
+	alive, _ := h.dial(t, uuid.Must(uuid.NewV4()), "s1", "")
+	pings := make(chan struct{}, 100)
+	alive.SetPingHandler(func(data string) error {
+		pings <- struct{}{}
+		return alive.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := alive.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// A client that never reads sends no pongs and is disconnected
+	_, silent := h.dial(t, uuid.Must(uuid.NewV4()), "s2", "")
+	select {
+	case c := <-h.closed:
+		if c != silent {
+			t.Fatal("the client answering pings was disconnected")
+		}
+	case <-time.After(5 * hub.pongWait):
+		t.Fatal("silent client was not disconnected after pongWait")
+	}
+
+	time.Sleep(3 * hub.pongWait)
+	select {
+	case <-h.closed:
+		t.Fatal("the client answering pings was disconnected")
+	default:
+	}
+	if len(pings) < 3 {
+		t.Fatalf("got %d pings, want at least 3", len(pings))
+	}
+}
+
+func TestHubCloseSessions(t *testing.T) {
+	h := newHubHarness(t, NewHub())
+	alice := uuid.Must(uuid.NewV4())
+	revoked1, _ := h.dial(t, alice, "s1", "")
+	revoked2, _ := h.dial(t, alice, "s2", "")
+	kept, _ := h.dial(t, alice, "s3", "")
+
+	h.hub.CloseSessions("s1", "s2", "unknown")
+	expectClosed(t, revoked1)
+	expectClosed(t, revoked2)
+
+	h.hub.SendToUser(alice, newFrame("still-here", nil))
+	expectFrame(t, kept, "still-here")
+}
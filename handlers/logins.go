@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"forum/db"
+	"forum/mail"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// lockoutThreshold is how many consecutive failed logins lock an account
+	lockoutThreshold = 5
+	// lockoutBase is the first lockout, doubled for every further failure
+	lockoutBase = 30 * time.Second
+	// lockoutMax caps the lockout
+	lockoutMax = time.Hour
+)
+
+// Reasons recorded on failed login events
+const (
+	loginReasonPassword = "wrong_password"
+	loginReasonCode     = "invalid_code"
+	loginReasonLocked   = "locked"
+)
+
+// lockoutDuration returns how long an account is locked after the given
+// number of consecutive failures
+func lockoutDuration(failures int) time.Duration {
+	if failures < lockoutThreshold {
+		return 0
+	}
+	d := float64(lockoutBase) * math.Pow(2, float64(failures-lockoutThreshold))
+	if d > float64(lockoutMax) {
+		return lockoutMax
+	}
+	return time.Duration(d)
+}
+
+// checkAccountLock responds 429 and returns false while the account is
+// locked. The attempt is recorded but does not extend the lockout.
+func (s *Server) checkAccountLock(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	until, err := s.store.GetAccountLock(userID)
+	if err != nil {
+		log.Printf("Error getting account lock: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return false
+	}
+	remaining := time.Until(until)
+	if remaining <= 0 {
+		return true
+	}
+	s.recordLoginEvent(r, userID, false, loginReasonLocked)
+	seconds := int(math.Ceil(remaining.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many failed logins. Try again in "+strconv.Itoa(seconds)+" seconds.", http.StatusTooManyRequests)
+	return false
+}
+
+// recordLoginFailure records a failed login and locks the account once
+// there have been too many in a row
+func (s *Server) recordLoginFailure(r *http.Request, userID uuid.UUID, reason string) {
+	s.recordLoginEvent(r, userID, false, reason)
+	failures, err := s.store.AddFailedLogin(userID)
+	if err != nil {
+		log.Printf("Error counting failed login: %v", err)
+		return
+	}
+	if d := lockoutDuration(failures); d > 0 {
+		log.Printf("Locking account %s for %s after %d failed logins", userID, d, failures)
+		if err := s.store.LockAccount(userID, time.Now().Add(d)); err != nil {
+			log.Printf("Error locking account: %v", err)
+		}
+	}
+}
+
+// recordLoginEvent stores a login attempt from the request's client
+func (s *Server) recordLoginEvent(r *http.Request, userID uuid.UUID, success bool, reason string) {
+	err := s.store.AddLoginEvent(db.LoginEvent{
+		UserID:    userID,
+		Success:   success,
+		Reason:    reason,
+		IP:        s.clientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		log.Printf("Error recording login event: %v", err)
+	}
+}
+
+// completeLogin creates the session once every login step has passed. It
+// resets the failure count, records the login and emails the user when it
+// comes from a device they have not logged in from before. The request's
+// CSRF tokens are rotated for the new session.
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, userID uuid.UUID, username string) error {
+	sessionToken, err := s.NewSession(w, r, username, userID)
+	if err != nil {
+		return err
+	}
+	s.rotateCSRFToken(w, r, sessionToken)
+	if err := s.store.ClearFailedLogins(userID); err != nil {
+		log.Printf("Error clearing failed logins: %v", err)
+	}
+	knownDevice, anyLogin, err := s.store.CheckLoginDevice(userID, r.UserAgent())
+	if err != nil {
+		log.Printf("Error checking login device: %v", err)
+	}
+	s.recordLoginEvent(r, userID, true, "")
+	if err == nil && anyLogin && !knownDevice {
+		if err := s.sendNewDeviceNotice(r, userID); err != nil {
+			log.Printf("Error sending new device notice: %v", err)
+		}
+	}
+	return nil
+}
+
+// sendNewDeviceNotice emails the user about a login from a new device
+func (s *Server) sendNewDeviceNotice(r *http.Request, userID uuid.UUID) error {
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	return s.config.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "New sign-in to your forum account",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Your account was just signed in to from a device you have not used before:\n\n" +
+			"  Device: " + r.UserAgent() + "\n" +
+			"  IP address: " + s.clientIP(r) + "\n" +
+			"  Time: " + time.Now().Format(time.RFC1123) + "\n\n" +
+			"If this was you, there is nothing to do. Otherwise reset your password and sign out your other sessions.\n",
+	})
+}
+
+// GetLoginHistoryHandler lists the user's recent login attempts. The limit
+// query parameter defaults to 20 and is capped at 100.
+func (s *Server) GetLoginHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	limit, err := intParam(r.URL.Query().Get("limit"), 20, 1, 100)
+	if err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	events, err := s.store.GetLoginEvents(userID, limit)
+	if err != nil {
+		log.Printf("Error getting login events: %v", err)
+		http.Error(w, "Failed to get login history", http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []db.LoginEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
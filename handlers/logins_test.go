@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/db"
+)
+
+// loginAs posts the login form for username to LoginProcess
+func loginAs(srv *Server, username, password, userAgent string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader("username="+username+"&password="+password))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+	rec := httptest.NewRecorder()
+	srv.LoginProcess(rec, req)
+	return rec
+}
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{lockoutThreshold - 1, 0},
+		{lockoutThreshold, lockoutBase},
+		{lockoutThreshold + 1, 2 * lockoutBase},
+		{lockoutThreshold + 2, 4 * lockoutBase},
+		{lockoutThreshold + 6, 64 * lockoutBase},
+		{lockoutThreshold + 7, lockoutMax},
+		{1000, lockoutMax},
+	}
+	for _, tt := range tests {
+		if got := lockoutDuration(tt.failures); got != tt.want {
+			t.Errorf("lockoutDuration(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestAccountLockout(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	userID := addUser(t, store, "alice", true)
+
+	for i := 0; i < lockoutThreshold; i++ {
+		if rec := loginAs(srv, "alice", "wrong", "ua"); rec.Code != http.StatusForbidden {
+			t.Fatalf("failure %d: status %d", i, rec.Code)
+		}
+	}
+	until, err := store.GetAccountLock(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(until); d <= 0 || d > lockoutBase {
+		t.Fatalf("locked for %v after %d failures", d, lockoutThreshold)
+	}
+
+	// The right password is refused during the lock, and neither it nor a
+	// wrong one extends the lock
+	for _, password := range []string{testPassword, "wrong"} {
+		rec := loginAs(srv, "alice", password, "ua")
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("login during the lock: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+	if again, _ := store.GetAccountLock(userID); !again.Equal(until) {
+		t.Fatalf("lock moved from %v to %v", until, again)
+	}
+	failures, err := store.AddFailedLogin(userID)
+	if err != nil || failures != lockoutThreshold+1 {
+		t.Fatalf("failures counted during the lock: %d, %v", failures-1, err)
+	}
+
+	// Once the lock is over, a success clears the failures so the next
+	// wrong password does not lock the account again
+	if err := store.LockAccount(userID, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if rec := loginAs(srv, "alice", testPassword, "ua"); rec.Code != http.StatusOK {
+		t.Fatalf("login after the lock: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := loginAs(srv, "alice", "wrong", "ua"); rec.Code != http.StatusForbidden {
+		t.Fatalf("failure after success: status %d", rec.Code)
+	}
+	if until, _ := store.GetAccountLock(userID); time.Until(until) > 0 {
+		t.Fatal("account locked again by a single failure")
+	}
+}
+
+func TestNewDeviceNotice(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	addUser(t, store, "alice", true)
+	const subject = "Subject: New sign-in to your forum account"
+
+	logins := []struct {
+		userAgent string
+		notices   int
+	}{
+		{"laptop", 0}, // the first login has nothing to compare with
+		{"laptop", 0},
+		{"phone", 1},
+		{"phone", 1},
+		{"laptop", 1},
+	}
+	for i, l := range logins {
+		if rec := loginAs(srv, "alice", testPassword, l.userAgent); rec.Code != http.StatusOK {
+			t.Fatalf("login %d: status %d: %s", i, rec.Code, rec.Body)
+		}
+		if n := strings.Count(sentMail(t, srv), subject); n != l.notices {
+			t.Fatalf("after login %d from %s: %d notices, want %d", i, l.userAgent, n, l.notices)
+		}
+	}
+	if mail := sentMail(t, srv); !strings.Contains(mail, "To: alice@example.com") || !strings.Contains(mail, "Device: phone") {
+		t.Fatalf("notice:\n%s", mail)
+	}
+}
+
+func TestGetLoginHistoryHandler(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	aliceID := addUser(t, store, "alice", true)
+	addUser(t, store, "bob", true)
+	loginAs(srv, "alice", "wrong", "ua")
+	loginAs(srv, "bob", testPassword, "ua")
+	for i := 0; i < 3; i++ {
+		loginAs(srv, "alice", testPassword, "ua")
+	}
+	session, _ := newTestSession(t, srv, "alice")
+
+	history := func(query string) (int, []db.LoginEvent) {
+		req := httptest.NewRequest("GET", "/api/login-history"+query, nil)
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: session})
+		rec := httptest.NewRecorder()
+		srv.GetLoginHistoryHandler(rec, req)
+		var events []db.LoginEvent
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&events); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, events
+	}
+
+	code, events := history("")
+	if code != http.StatusOK || len(events) != 4 {
+		t.Fatalf("history: status %d, %d events", code, len(events))
+	}
+	for _, e := range events {
+		if e.UserID != aliceID || e.UserAgent != "ua" {
+			t.Fatalf("event of another user or agent: %+v", e)
+		}
+	}
+	if last := events[len(events)-1]; last.Success || last.Reason != loginReasonPassword {
+		t.Fatalf("oldest event: %+v", last)
+	}
+	if code, events := history("?limit=2"); code != http.StatusOK || len(events) != 2 || !events[0].Success {
+		t.Fatalf("limit 2: status %d, %+v", code, events)
+	}
+	if code, events := history("?limit=1000"); code != http.StatusOK || len(events) != 4 {
+		t.Fatalf("limit above the cap: status %d, %d events", code, len(events))
+	}
+	for _, limit := range []string{"0", "-1", "x"} {
+		if code, _ := history("?limit=" + limit); code != http.StatusBadRequest {
+			t.Errorf("limit %s: status %d", limit, code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/login-history", nil)
+	rec := httptest.NewRecorder()
+	srv.GetLoginHistoryHandler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without a session: status %d", rec.Code)
+	}
+}
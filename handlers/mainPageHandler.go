@@ -6,7 +6,7 @@ import (
 	"net/http"
 )
 
-func MainPageHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) MainPageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		tmpl, err := template.ParseFiles("static/index.html")
 		if err != nil {
@@ -15,60 +15,58 @@ func MainPageHandler(w http.ResponseWriter, r *http.Request) {
 		tmpl.Execute(w, nil)
 	}
 	if r.Method == "POST" {
-		LoginProcess(w, r)
+		s.LoginProcess(w, r)
 	}
 }
 
-func SignupHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SignupHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/registration" {
 		http.Error(w, "Page not found.", http.StatusNotFound)
 		return
 	}
 	if r.Method == "GET" {
-		MainPageHandler(w, r)
+		s.MainPageHandler(w, r)
 		return
 	}
 	if r.Method == "POST" {
-		SignupProcess(w, r)
+		s.SignupProcess(w, r)
 	}
 }
 
-func HomepageHandler(w http.ResponseWriter, r *http.Request) {
-	if SessionExpired(r) {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
+func (s *Server) HomepageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/homepage" {
 		http.Error(w, "Page not found.", http.StatusNotFound)
 		return
 	}
-	username := ValidateSession(r)
+	username := s.ValidateSession(r)
 	if username == "" {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 	if r.Method == "GET" {
-		MainPageHandler(w, r)
+		s.MainPageHandler(w, r)
 	}
 }
 
-func ValidateSessionHandler(w http.ResponseWriter, r *http.Request) {
-	username := ValidateSession(r)
-	if username != "" && !SessionExpired(r) {
+func (s *Server) ValidateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	username := s.ValidateSession(r)
+	if username != "" {
 		w.WriteHeader(http.StatusOK)
 	} else {
 		w.WriteHeader(http.StatusUnauthorized)
 	}
 }
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+// LogoutHandler ends the session. Only POST requests, which carry a CSRF
+// token, log out; other methods just redirect.
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/logout" {
 		http.Error(w, "Page not found.", http.StatusNotFound)
 		return
 	}
-	user := ValidateSession(r)
-	if user != "" {
-		CloseSession(w, r)
+	user := s.ValidateSession(r)
+	if user != "" && r.Method == http.MethodPost {
+		s.CloseSession(w, r)
 	}
 	if r.Header.Get("Accept") == "application/json" {
 		w.Header().Set("Content-Type", "application/json")
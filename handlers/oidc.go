@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum/db"
+	"forum/oidc"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// oidcStateTTL is how long a user has to finish signing in at the
+	// identity provider
+	oidcStateTTL = 10 * time.Minute
+	// oidcStateCookie binds a provider login to the browser that started
+	// it, so a callback URL cannot be used to log someone else in
+	oidcStateCookie = "oidc_state"
+)
+
+// oidcCallbackPath is where providers send users back to
+const oidcCallbackPath = "/oidc/callback"
+
+// errNoLink is returned by linkIdentity when the provider account cannot
+// be linked to a forum account
+var errNoLink = errors.New("identity cannot be linked")
+
+// newOIDCClients creates a client for each configured provider
+func newOIDCClients(baseURL string, providers []oidc.ProviderConfig) map[string]*oidc.Client {
+	clients := make(map[string]*oidc.Client, len(providers))
+	for _, p := range providers {
+		clients[p.Name] = oidc.NewClient(p, baseURL+oidcCallbackPath)
+	}
+	return clients
+}
+
+// GetOIDCProvidersHandler lists the identity providers users can sign in
+// with
+func (s *Server) GetOIDCProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	type provider struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+		LoginURL    string `json:"login_url"`
+	}
+	providers := []provider{}
+	for _, p := range s.config.OIDCProviders {
+		providers = append(providers, provider{
+			Name:        p.Name,
+			DisplayName: p.DisplayName,
+			LoginURL:    "/oidc/login?provider=" + url.QueryEscape(p.Name),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(providers)
+}
+
+// OIDCLoginHandler starts signing in with an identity provider
+func (s *Server) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("provider")
+	client, ok := s.oidc[name]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+	state, stateHash, err := newSecretToken()
+	if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidc.NewNonce()
+	if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	authURL, err := client.AuthCodeURL(state, nonce, challenge)
+	if err != nil {
+		log.Printf("Error contacting identity provider %s: %v", name, err)
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	if err := s.store.CreateOIDCState(stateHash, name, nonce, verifier, time.Now().Add(oidcStateTTL)); err != nil {
+		log.Printf("Error saving sign-in state: %v", err)
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/oidc/",
+		MaxAge:   int(oidcStateTTL / time.Second),
+		HttpOnly: true,
+		// Lax so the cookie is sent on the provider's redirect back
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler finishes signing in with an identity provider. The
+// provider account is linked on first use to the forum account with the
+// same verified email. Users with 2FA enabled are sent to the login page
+// with a pending login token in the URL fragment, which the page exchanges
+// for a session together with a code as after a password login.
+func (s *Server) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "Sign-in was not completed: "+e, http.StatusBadRequest)
+		return
+	}
+	state := q.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http.Error(w, "Invalid sign-in state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/oidc/", MaxAge: -1})
+
+	name, nonce, verifier, err := s.store.ConsumeOIDCState(hashSecretToken(state))
+	if err == db.ErrInvalidToken {
+		http.Error(w, "Sign-in expired, please try again", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading sign-in state: %v", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	client, ok := s.oidc[name]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusBadRequest)
+		return
+	}
+	claims, err := client.Exchange(q.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("Error completing sign-in with %s: %v", name, err)
+		http.Error(w, "Failed to sign in with "+client.Config.DisplayName, http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := s.store.GetIdentityUser(name, claims.Subject)
+	if err == sql.ErrNoRows {
+		userID, err = s.linkIdentity(w, client, claims)
+		if err != nil {
+			return
+		}
+	} else if err != nil {
+		log.Printf("Error getting linked identity: %v", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.checkAccountLock(w, r, userID) {
+		return
+	}
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	tf, err := s.enabledTwoFactor(userID)
+	if err != nil {
+		http.Error(w, "Failed to get 2FA status", http.StatusInternalServerError)
+		return
+	}
+	if tf != nil {
+		token, err := s.newPendingLogin(userID)
+		if err != nil {
+			log.Printf("Error creating pending login: %v", err)
+			http.Error(w, "Failed to start login", http.StatusInternalServerError)
+			return
+		}
+		// The fragment is neither sent to servers nor leaked in Referer
+		http.Redirect(w, r, "/#two_factor="+token, http.StatusFound)
+		return
+	}
+	if err := s.completeLogin(w, r, userID, user.Username); err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/homepage", http.StatusFound)
+}
+
+// linkIdentity links a provider account to the forum account with the same
+// email. Both sides must have verified the address, otherwise someone could
+// take over an account by registering its email first. It writes the error
+// response when no account can be linked.
+func (s *Server) linkIdentity(w http.ResponseWriter, client *oidc.Client, claims *oidc.Claims) (uuid.UUID, error) {
+	provider := client.Config.DisplayName
+	if claims.Email == "" || !claims.EmailVerified {
+		http.Error(w, "Your "+provider+" account has no verified email address", http.StatusForbidden)
+		return uuid.Nil, errNoLink
+	}
+	userID, err := s.store.GetUserIDByUsernameOrEmail(claims.Email)
+	if err != nil {
+		http.Error(w, "No forum account uses "+claims.Email+". Create an account first, then sign in with "+provider+".", http.StatusForbidden)
+		return uuid.Nil, errNoLink
+	}
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return uuid.Nil, err
+	}
+	if user.EmailVerifiedAt == nil {
+		http.Error(w, "Verify your forum email address before signing in with "+provider, http.StatusForbidden)
+		return uuid.Nil, errNoLink
+	}
+	if err := s.store.LinkIdentity(client.Config.Name, claims.Subject, claims.Email, userID); err != nil {
+		log.Printf("Error linking identity: %v", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return uuid.Nil, err
+	}
+	log.Printf("Linked %s account %s to user %s", client.Config.Name, claims.Subject, userID)
+	return userID, nil
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/db"
+	"forum/oidc"
+	"forum/oidc/oidctest"
+	"forum/totp"
+)
+
+func newOIDCTestServer(t *testing.T) (*Server, *db.SQLStore, *oidctest.Provider) {
+	t.Helper()
+	p := oidctest.NewProvider("forum")
+	t.Cleanup(p.Close)
+	srv, store := newTestServer(t, Config{
+		BaseURL: "http://forum.test",
+		OIDCProviders: []oidc.ProviderConfig{
+			{Name: "test", DisplayName: "Test", Issuer: p.Issuer, ClientID: "forum"},
+		},
+	})
+	return srv, store, p
+}
+
+// signInWithProvider runs the browser side of a provider sign-in and
+// returns the response of the callback
+func signInWithProvider(t *testing.T, srv *Server) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	srv.OIDCLoginHandler(rec, httptest.NewRequest("GET", "/oidc/login?provider=test", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status %d: %s", rec.Code, rec.Body)
+	}
+	cookies := rec.Result().Cookies()
+
+	// The fake provider signs the user in at once and redirects back
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || callback.Path != oidcCallbackPath {
+		t.Fatalf("provider redirected to %q", resp.Header.Get("Location"))
+	}
+
+	req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	srv.OIDCCallbackHandler(rec, req)
+	return rec
+}
+
+func sessionCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "session_token" && c.Value != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestOIDCLinksVerifiedEmail(t *testing.T) {
+	srv, store, p := newOIDCTestServer(t)
+	userID := addUser(t, store, "alice", true)
+	p.SetUser(oidctest.User{Subject: "sub-alice", Email: "ALICE@example.com", EmailVerified: true})
+
+	rec := signInWithProvider(t, srv)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/homepage" {
+		t.Fatalf("callback: status %d, location %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	if sessionCookie(rec) == nil {
+		t.Fatal("no session cookie after signing in")
+	}
+	linked, err := store.GetIdentityUser("test", "sub-alice")
+	if err != nil || linked != userID {
+		t.Fatalf("identity linked to %s (%v), want %s", linked, err, userID)
+	}
+
+	// The link holds even after the provider email changes
+	p.SetUser(oidctest.User{Subject: "sub-alice", Email: "new@example.com", EmailVerified: true})
+	if rec := signInWithProvider(t, srv); sessionCookie(rec) == nil {
+		t.Fatalf("second sign-in: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCRefusesUnverifiedEmail(t *testing.T) {
+	tests := []struct {
+		name          string
+		forumVerified bool
+		user          oidctest.User
+	}{
+		{"provider email unverified", true, oidctest.User{Subject: "s1", Email: "alice@example.com"}},
+		{"forum email unverified", false, oidctest.User{Subject: "s2", Email: "alice@example.com", EmailVerified: true}},
+		{"no forum account", true, oidctest.User{Subject: "s3", Email: "bob@example.com", EmailVerified: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, store, p := newOIDCTestServer(t)
+			addUser(t, store, "alice", tt.forumVerified)
+			p.SetUser(tt.user)
+			rec := signInWithProvider(t, srv)
+			if rec.Code != http.StatusForbidden || sessionCookie(rec) != nil {
+				t.Fatalf("status %d, want 403 without a session", rec.Code)
+			}
+			if _, err := store.GetIdentityUser("test", tt.user.Subject); err == nil {
+				t.Fatal("identity was linked")
+			}
+		})
+	}
+}
+
+func TestOIDCRequiresSecondFactor(t *testing.T) {
+	srv, store, p := newOIDCTestServer(t)
+	userID := addUser(t, store, "alice", true)
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTwoFactorSecret(userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableTwoFactor(userID, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	p.SetUser(oidctest.User{Subject: "sub-alice", Email: "alice@example.com", EmailVerified: true})
+
+	rec := signInWithProvider(t, srv)
+	location := rec.Header().Get("Location")
+	if rec.Code != http.StatusFound || !strings.HasPrefix(location, "/#two_factor=") {
+		t.Fatalf("callback: status %d, location %q", rec.Code, location)
+	}
+	if sessionCookie(rec) != nil {
+		t.Fatal("session created before the second factor")
+	}
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]string{"token": strings.TrimPrefix(location, "/#two_factor="), "code": code})
+	rec = httptest.NewRecorder()
+	srv.LoginTwoFactorHandler(rec, httptest.NewRequest("POST", "/api/login-2fa", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK || sessionCookie(rec) == nil {
+		t.Fatalf("login-2fa: status %d: %s", rec.Code, rec.Body)
+	}
+}
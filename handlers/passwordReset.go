@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"forum/db"
+	"forum/mail"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetTTL is how long a password reset link stays valid
+const passwordResetTTL = time.Hour
+
+// ForgotPasswordHandler emails a password reset link to the account with the
+// given email. It responds the same way whether or not the account exists
+// so it cannot be used to discover registered addresses.
+func (s *Server) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var requestData struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil || requestData.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.sendPasswordReset(requestData.Email); err != nil {
+		log.Printf("Error sending password reset: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account exists for that email, a reset link has been sent",
+	})
+}
+
+// sendPasswordReset creates a reset token for the account with email and
+// mails the link. Unknown addresses are silently ignored.
+func (s *Server) sendPasswordReset(email string) error {
+	userID, err := s.store.GetUserIDByUsernameOrEmail(email)
+	if err != nil {
+		return nil
+	}
+	user, err := s.store.GetUserByID(userID)
+	if err != nil || !strings.EqualFold(user.Email, email) {
+		return nil
+	}
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	if err := s.store.CreatePasswordReset(userID, hash, time.Now().Add(passwordResetTTL)); err != nil {
+		return err
+	}
+	link := s.config.BaseURL + "/reset-password?token=" + url.QueryEscape(token)
+	return s.config.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your forum password",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Someone asked to reset the password of your forum account. If it was you, open the link below within the next hour:\n\n" +
+			link + "\n\n" +
+			"If you did not ask for this you can ignore this email.\n",
+	})
+}
+
+// ResetPasswordPageHandler serves the page the reset link points to. The
+// page reads the token from the URL and posts it to ResetPasswordHandler.
+func (s *Server) ResetPasswordPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	s.MainPageHandler(w, r)
+}
+
+// ResetPasswordHandler sets a new password using a reset token. All of the
+// user's sessions are ended afterwards.
+func (s *Server) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var requestData struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+	if requestData.Token == "" || requestData.Password == "" {
+		http.Error(w, "Token and password are required", http.StatusBadRequest)
+		return
+	}
+
+	// The token is only consumed by ResetPassword below, once the new
+	// password has been accepted for its account
+	tokenHash := hashSecretToken(requestData.Token)
+	userID, err := s.store.GetPasswordResetUser(tokenHash)
+	if err == db.ErrInvalidToken {
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading password reset: %v", err)
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error getting user by ID: %v", err)
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+	if msg := s.config.Passwords.Check(requestData.Password, user.Username, user.Email); msg != "" {
+		writeFieldErrors(w, FieldErrors{"password": msg})
+		return
+	}
+
+	encryptedPassword, err := bcrypt.GenerateFromPassword([]byte(requestData.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+	userID, err = s.store.ResetPassword(tokenHash, string(encryptedPassword))
+	if err == db.ErrInvalidToken {
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error resetting password: %v", err)
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := s.sessions.RevokeOthers(userID, "")
+	if err != nil {
+		log.Printf("Error ending sessions after password reset: %v", err)
+	}
+	s.hub.CloseSessions(tokens...)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Password has been reset"))
+}
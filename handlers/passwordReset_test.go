@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"forum/db"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetLink matches the reset link in a password reset email
+var resetLink = regexp.MustCompile(`https://forum\.example/reset-password\?token=(\S+)`)
+
+// resetPassword posts token and password to ResetPasswordHandler
+func resetPassword(srv *Server, token, password string) (int, string) {
+	body, _ := json.Marshal(map[string]string{"token": token, "password": password})
+	rec := callHandler(srv.ResetPasswordHandler, "", string(body))
+	return rec.Code, rec.Body.String()
+}
+
+// forgotPassword posts email to ForgotPasswordHandler and returns the reset
+// token of the last link mailed since, or "" when nothing was sent
+func forgotPassword(t *testing.T, srv *Server, email string) string {
+	t.Helper()
+	before := len(sentMail(t, srv))
+	body, _ := json.Marshal(map[string]string{"email": email})
+	if rec := callHandler(srv.ForgotPasswordHandler, "", string(body)); rec.Code != http.StatusOK {
+		t.Fatalf("forgot password for %q: status %d: %s", email, rec.Code, rec.Body)
+	}
+	links := resetLink.FindAllStringSubmatch(sentMail(t, srv)[before:], -1)
+	if len(links) == 0 {
+		return ""
+	}
+	token, err := url.QueryUnescape(links[len(links)-1][1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestForgotAndResetPassword(t *testing.T) {
+	srv, store := newTestServer(t, Config{BaseURL: "https://forum.example"})
+	addUser(t, store, "alice", true)
+	session, _ := newTestSession(t, srv, "alice")
+
+	// Unknown addresses get the same answer and no email
+	if token := forgotPassword(t, srv, "nobody@example.com"); token != "" {
+		t.Fatal("mailed a reset link for an unknown address")
+	}
+	token := forgotPassword(t, srv, "ALICE@example.com")
+	if token == "" {
+		t.Fatalf("no reset link mailed:\n%s", sentMail(t, srv))
+	}
+	if mail := sentMail(t, srv); !strings.Contains(mail, "To: alice@example.com") {
+		t.Fatalf("reset mail:\n%s", mail)
+	}
+
+	if code, body := resetPassword(srv, token, "Another-horse-7"); code != http.StatusOK {
+		t.Fatalf("reset: status %d: %s", code, body)
+	}
+	// The link works once
+	if code, _ := resetPassword(srv, token, "Third-horse-5"); code != http.StatusBadRequest {
+		t.Fatalf("reusing the link: status %d", code)
+	}
+	if rec := loginAs(srv, "alice", testPassword, "ua"); rec.Code != http.StatusForbidden {
+		t.Fatalf("login with the old password: status %d", rec.Code)
+	}
+	if rec := loginAs(srv, "alice", "Another-horse-7", "ua"); rec.Code != http.StatusOK {
+		t.Fatalf("login with the new password: status %d: %s", rec.Code, rec.Body)
+	}
+	// Sessions from before the reset are signed out
+	if _, err := srv.sessions.Get(session); err != ErrNoSession {
+		t.Fatalf("session from before the reset: %v", err)
+	}
+}
+
+func TestResetPasswordTokenExpires(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	userID := addUser(t, store, "alice", true)
+	if err := store.CreatePasswordReset(userID, hashSecretToken("expired"), time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := resetPassword(srv, "expired", "Another-horse-7"); code != http.StatusBadRequest {
+		t.Fatalf("expired token: status %d", code)
+	}
+	if _, err := store.GetPasswordResetUser(hashSecretToken("expired")); err != db.ErrInvalidToken {
+		t.Fatalf("expired token after the attempt: %v", err)
+	}
+	if rec := loginAs(srv, "alice", testPassword, "ua"); rec.Code != http.StatusOK {
+		t.Fatalf("password changed by an expired token: status %d", rec.Code)
+	}
+}
+
+func TestResetPasswordChecksAccount(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	userID := addUser(t, store, "alicewonder", true)
+	if err := store.CreatePasswordReset(userID, hashSecretToken("reset"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new password may not be the account's username or email
+	for _, password := range []string{"AliceWonder", "alicewonder@EXAMPLE.com"} {
+		code, body := resetPassword(srv, "reset", password)
+		var errs struct {
+			Errors FieldErrors `json:"errors"`
+		}
+		if code != http.StatusBadRequest || json.Unmarshal([]byte(body), &errs) != nil || errs.Errors["password"] == "" {
+			t.Fatalf("password %q: status %d: %s", password, code, body)
+		}
+	}
+	// A refused password leaves the token usable
+	if code, body := resetPassword(srv, "reset", "Another-horse-7"); code != http.StatusOK {
+		t.Fatalf("valid password: status %d: %s", code, body)
+	}
+	if code, _ := resetPassword(srv, "unknown", "Another-horse-7"); code != http.StatusBadRequest {
+		t.Fatalf("unknown token: status %d", code)
+	}
+}
@@ -2,13 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
-	"forum/db"
 	"net/http"
 	"strconv"
 )
 
 // CreateCategoryHandler handles category creation
-func CreateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) CreateCategoryHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
@@ -34,7 +33,7 @@ func CreateCategoryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.CreateCategory(requestData.Name)
+	err = s.store.CreateCategory(requestData.Name)
 	if err != nil {
 		http.Error(w, "Failed to create category", http.StatusInternalServerError)
 		return
@@ -43,7 +42,7 @@ func CreateCategoryHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetCategoryByIDHandler handles fetching a single category by ID
-func GetCategoryByIDHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetCategoryByIDHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
@@ -58,7 +57,7 @@ func GetCategoryByIDHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid category ID", http.StatusBadRequest)
 		return
 	}
-	category, err := db.GetCategoryByID(id)
+	category, err := s.store.GetCategoryByID(id)
 	if err != nil {
 		http.Error(w, "Category not found", http.StatusNotFound)
 		return
@@ -68,12 +67,12 @@ func GetCategoryByIDHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetCategoriesHandler handles fetching all categories
-func GetCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetCategoriesHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
-	categories, err := db.GetCategories()
+	categories, err := s.store.GetCategories()
 	if err != nil {
 		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
 		return
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"forum/db"
 	"log"
@@ -13,8 +14,8 @@ import (
 )
 
 // CreatePostHandler handles post creation
-func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := getUserIDFromSession(r)
+func (s *Server) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromSession(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -56,7 +57,7 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 		categoryIDInts = append(categoryIDInts, categoryID)
 	}
 
-	err = db.CreatePostDB(db.DB, userID, requestData.Title, requestData.Content, categoryIDInts, time.Now())
+	err = s.store.CreatePost(userID, requestData.Title, requestData.Content, categoryIDInts, time.Now())
 	if err != nil {
 		http.Error(w, "Failed to create post", http.StatusInternalServerError)
 		return
@@ -65,8 +66,8 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // CreateCommentHandler handles comment creation
-func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := getUserIDFromSession(r)
+func (s *Server) CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromSession(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -78,8 +79,9 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var requestData struct {
-		PostID  string `json:"post_id"`
-		Content string `json:"content"`
+		PostID   string `json:"post_id"`
+		ParentID string `json:"parent_id"`
+		Content  string `json:"content"`
 	}
 
 	err = json.NewDecoder(r.Body).Decode(&requestData)
@@ -88,140 +90,216 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if requestData.PostID == "" || requestData.Content == "" {
+	if requestData.Content == "" {
 		http.Error(w, "Post ID and content are required", http.StatusBadRequest)
 		return
 	}
 
-	postID, err := uuid.FromString(requestData.PostID)
-	if err != nil {
-		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+	post, ok := s.lookupPost(w, requestData.PostID)
+	if !ok {
+		return
+	}
+	if post.Deleted {
+		http.Error(w, "Cannot comment on a deleted post", http.StatusBadRequest)
 		return
 	}
+	postID := post.ID
+
+	var parent *db.Comment
+	if requestData.ParentID != "" {
+		parentID, err := uuid.FromString(requestData.ParentID)
+		if err != nil {
+			http.Error(w, "Invalid parent comment ID", http.StatusBadRequest)
+			return
+		}
+		parent, err = s.store.GetCommentByID(parentID)
+		if err != nil || parent.PostID != postID {
+			http.Error(w, "Parent comment does not belong to this post", http.StatusBadRequest)
+			return
+		}
+		if parent.Deleted {
+			http.Error(w, "Cannot reply to a deleted comment", http.StatusBadRequest)
+			return
+		}
+	}
 
-	err = db.CreateComment(postID, userID, requestData.Content)
+	var parentID *uuid.UUID
+	if parent != nil {
+		parentID = &parent.ID
+	}
+	commentID, err := s.store.CreateComment(postID, userID, parentID, requestData.Content)
 	if err != nil {
 		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
 		return
 	}
+	if parent != nil && parent.UserID != userID {
+		s.hub.SendToUser(parent.UserID, newFrame("reply", db.ReplyMessage{
+			Type:      "reply",
+			PostID:    postID,
+			CommentID: commentID,
+			ParentID:  parent.ID,
+			Sender:    userID,
+			Receiver:  parent.UserID,
+			Timestamp: time.Now().Format(time.RFC3339),
+		}))
+	}
 	w.WriteHeader(http.StatusCreated)
 }
 
-// GetPostsHandler handles fetching posts
-func GetPostsHandler(w http.ResponseWriter, r *http.Request) {
-	posts, err := db.GetPosts()
+// GetPostsHandler handles fetching a page of the post feed. Supported query
+// parameters are cursor, limit, category_id, author, from, to and reacted.
+func (s *Server) GetPostsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var filter db.PostFilter
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := db.DecodePostCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		filter.After = after
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+	if categoryStr := query.Get("category_id"); categoryStr != "" {
+		categoryID, err := strconv.Atoi(categoryStr)
+		if err != nil {
+			http.Error(w, "Invalid category ID", http.StatusBadRequest)
+			return
+		}
+		filter.CategoryID = categoryID
+	}
+	filter.Author = query.Get("author")
+	if fromStr := query.Get("from"); fromStr != "" {
+		from, err := parseDateParam(fromStr, false)
+		if err != nil {
+			http.Error(w, "Invalid from date", http.StatusBadRequest)
+			return
+		}
+		filter.From = from
+	}
+	if toStr := query.Get("to"); toStr != "" {
+		to, err := parseDateParam(toStr, true)
+		if err != nil {
+			http.Error(w, "Invalid to date", http.StatusBadRequest)
+			return
+		}
+		filter.To = to
+	}
+	if viewer, err := s.getUserIDFromSession(r); err == nil {
+		filter.Viewer = viewer
+	}
+	if query.Get("reacted") == "true" {
+		if filter.Viewer == uuid.Nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		filter.ReactedBy = filter.Viewer
+	}
+
+	posts, next, err := s.store.GetPosts(filter)
 	if err != nil {
 		log.Printf("Error getting posts: %v", err)
 		http.Error(w, "Failed to get posts", http.StatusInternalServerError)
 		return
 	}
+	page := db.PostPage{Posts: posts}
+	if page.Posts == nil {
+		page.Posts = []db.Post{}
+	}
+	if next != nil {
+		page.NextCursor = next.Encode()
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(posts)
+	json.NewEncoder(w).Encode(page)
 }
 
-// GetCommentsHandler handles fetching comments for a post
-func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
-	postIDStr := r.URL.Query().Get("post_id")
-	if postIDStr == "" {
-		http.Error(w, "Post ID is required", http.StatusBadRequest)
-		return
+// parseDateParam accepts either an RFC 3339 timestamp or a YYYY-MM-DD date.
+// A bare date used as an upper bound covers the whole day.
+func parseDateParam(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
 	}
-	postID, err := uuid.FromString(postIDStr)
+	t, err := time.Parse("2006-01-02", value)
 	if err != nil {
-		http.Error(w, "Invalid post ID", http.StatusBadRequest)
-		return
+		return time.Time{}, err
 	}
-	comments, err := db.GetComments(postID)
-	if err != nil {
-		http.Error(w, "Failed to get comments", http.StatusInternalServerError)
-		return
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments)
+	return t, nil
 }
 
-// AddPostReactionHandler handles adding reactions to posts
-func AddPostReactionHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := getUserIDFromSession(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	if r.Header.Get("Content-Type") != "application/json" {
-		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+// GetCommentsHandler handles fetching the comment thread of a post. Optional
+// query parameters: parent_id to fetch the replies of one comment, depth for
+// the number of nested levels, and limit and offset for paging each level.
+func (s *Server) GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	postIDStr := query.Get("post_id")
+	if postIDStr == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
 		return
 	}
-
-	var requestData struct {
-		PostID       string `json:"post_id"`
-		ReactionType string `json:"type"`
-	}
-
-	err = json.NewDecoder(r.Body).Decode(&requestData)
+	postID, err := uuid.FromString(postIDStr)
 	if err != nil {
-		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
 		return
 	}
 
-	if requestData.PostID == "" || requestData.ReactionType == "" {
-		http.Error(w, "Post ID and reaction type are required", http.StatusBadRequest)
-		return
+	opts := db.CommentTreeOptions{
+		MaxDepth: db.DefaultCommentDepth,
+		Limit:    db.DefaultRepliesPerLevel,
 	}
-
-	postID, err := uuid.FromString(requestData.PostID)
-	if err != nil {
-		http.Error(w, "Invalid post ID", http.StatusBadRequest)
-		return
+	if parentStr := query.Get("parent_id"); parentStr != "" {
+		parentID, err := uuid.FromString(parentStr)
+		if err != nil {
+			http.Error(w, "Invalid parent comment ID", http.StatusBadRequest)
+			return
+		}
+		opts.ParentID = &parentID
 	}
-
-	err = db.AddPostReaction(userID, postID, db.ReactionType(requestData.ReactionType))
-	if err != nil {
-		http.Error(w, "Failed to add reaction", http.StatusInternalServerError)
+	if opts.MaxDepth, err = intParam(query.Get("depth"), db.DefaultCommentDepth, 1, db.MaxCommentDepth); err != nil {
+		http.Error(w, "Invalid depth", http.StatusBadRequest)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-}
-
-// AddCommentReactionHandler handles adding reactions to comments
-func AddCommentReactionHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := getUserIDFromSession(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if opts.Limit, err = intParam(query.Get("limit"), db.DefaultRepliesPerLevel, 1, db.MaxRepliesPerLevel); err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
 		return
 	}
-
-	if r.Header.Get("Content-Type") != "application/json" {
-		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+	if opts.Offset, err = intParam(query.Get("offset"), 0, 0, -1); err != nil {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
 		return
 	}
 
-	var requestData struct {
-		CommentID    string `json:"comment_id"`
-		ReactionType string `json:"type"`
-	}
-
-	err = json.NewDecoder(r.Body).Decode(&requestData)
+	viewer, _ := s.getUserIDFromSession(r)
+	comments, err := s.store.GetComments(postID, viewer)
 	if err != nil {
-		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		http.Error(w, "Failed to get comments", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(db.BuildCommentTree(comments, opts))
+}
 
-	if requestData.CommentID == "" || requestData.ReactionType == "" {
-		http.Error(w, "Comment ID and reaction type are required", http.StatusBadRequest)
-		return
+// intParam parses an optional integer query parameter, returning def when it
+// is empty and clamping it to max when max is not negative
+func intParam(value string, def, min, max int) (int, error) {
+	if value == "" {
+		return def, nil
 	}
-
-	commentID, err := uuid.FromString(requestData.CommentID)
-	if err != nil {
-		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
-		return
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min {
+		return 0, errors.New("invalid integer parameter")
 	}
-
-	err = db.AddCommentReaction(userID, commentID, db.ReactionType(requestData.ReactionType))
-	if err != nil {
-		http.Error(w, "Failed to add reaction", http.StatusInternalServerError)
-		return
+	if max >= 0 && n > max {
+		n = max
 	}
-	w.WriteHeader(http.StatusOK)
+	return n, nil
 }
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"forum/db"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// addPost creates a post by userID in a new category and returns its ID
+func addPost(t testing.TB, store db.Store, userID uuid.UUID, subject string) uuid.UUID {
+	t.Helper()
+	name := subject + " category"
+	if err := store.CreateCategory(name); err != nil {
+		t.Fatal(err)
+	}
+	categories, err := store.GetCategories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var category int
+	for _, c := range categories {
+		if c.Name == name {
+			category = c.ID
+		}
+	}
+	if err := store.CreatePost(userID, subject, "content", []int{category}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	posts, _, err := store.GetPosts(db.PostFilter{Limit: 1, CategoryID: category})
+	if err != nil || len(posts) != 1 {
+		t.Fatalf("finding post %q: %v", subject, err)
+	}
+	return posts[0].ID
+}
+
+func TestCreateCommentChecksPost(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	alice := addUser(t, store, "alice", true)
+	session, _ := newTestSession(t, srv, "alice")
+	post := addPost(t, store, alice, "Hello")
+	deleted := addPost(t, store, alice, "Gone")
+	if err := store.DeletePost(deleted, alice); err != nil {
+		t.Fatal(err)
+	}
+	comment := func(postID string) int {
+		return callHandler(srv.CreateCommentHandler, session, `{"post_id": "`+postID+`", "content": "hi"}`).Code
+	}
+
+	tests := []struct {
+		name   string
+		postID string
+		want   int
+	}{
+		{"existing post", post.String(), http.StatusCreated},
+		{"unknown post", uuid.Must(uuid.NewV4()).String(), http.StatusNotFound},
+		{"deleted post", deleted.String(), http.StatusBadRequest},
+		{"invalid post ID", "not-a-uuid", http.StatusBadRequest},
+		{"no post ID", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code := comment(tt.postID); code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, code, tt.want)
+		}
+	}
+
+	for _, postID := range []uuid.UUID{post, deleted} {
+		comments, err := store.GetComments(postID, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[uuid.UUID]int{post: 1}[postID]; len(comments) != want {
+			t.Errorf("post %v has %d comments, want %d", postID, len(comments), want)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"encoding/json"
-	"forum/db"
 	"log"
 	"net/http"
 	"strconv"
@@ -11,8 +10,8 @@ import (
 )
 
 // SendMessageHandler handles sending a message
-func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
-	senderID, err := getUserIDFromSession(r)
+func (s *Server) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	senderID, err := s.getUserIDFromSession(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -43,7 +42,7 @@ func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.AddMessage(senderID, receiverID, requestData.Content)
+	err = s.store.AddMessage(senderID, receiverID, requestData.Content)
 	if err != nil {
 		log.Println("Failed to send message:", err)
 		http.Error(w, "Failed to send message", http.StatusInternalServerError)
@@ -53,8 +52,8 @@ func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetMessagesHandler handles fetching messages
-func GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := getUserIDFromSession(r)
+func (s *Server) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromSession(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -77,7 +76,7 @@ func GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		offset = 0
 	}
 
-	messages, err := db.GetMessages(userID, otherUserID, limit, offset)
+	messages, err := s.store.GetMessages(userID, otherUserID, limit, offset)
 	if err != nil {
 		log.Println("Failed to get messages:", err)
 		http.Error(w, "Failed to get messages", http.StatusInternalServerError)
@@ -89,8 +88,8 @@ func GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // UpdateStatusHandler handles updating user status
-func UpdateStatusHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := getUserIDFromSession(r)
+func (s *Server) UpdateStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromSession(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -111,7 +110,7 @@ func UpdateStatusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.UpdateUserStatus(userID, requestData.IsOnline)
+	err = s.store.UpdateUserStatus(userID, requestData.IsOnline)
 	if err != nil {
 		log.Println("Failed to update status:", err)
 		http.Error(w, "Failed to update status", http.StatusInternalServerError)
@@ -121,8 +120,8 @@ func UpdateStatusHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetUserStatusHandler handles fetching user statuses
-func GetUserStatusHandler(w http.ResponseWriter, r *http.Request) {
-	statuses, err := db.GetUserStatus()
+func (s *Server) GetUserStatusHandler(w http.ResponseWriter, r *http.Request) {
+	statuses, err := s.store.GetUserStatus()
 	if err != nil {
 		log.Println("Failed to get user statuses:", err)
 		http.Error(w, "Failed to get user statuses", http.StatusInternalServerError)
@@ -133,8 +132,8 @@ func GetUserStatusHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // MarkMessageAsReadHandler handles marking a message as read
-func MarkMessageAsReadHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := getUserIDFromSession(r)
+func (s *Server) MarkMessageAsReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromSession(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -161,7 +160,7 @@ func MarkMessageAsReadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.MarkMessageAsRead(messageID, userID)
+	err = s.store.MarkMessageAsRead(messageID, userID)
 	if err != nil {
 		http.Error(w, "Failed to mark message as read", http.StatusInternalServerError)
 		return
@@ -170,8 +169,8 @@ func MarkMessageAsReadHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetUsersHandler handles fetching users
-func GetUsersHandler(w http.ResponseWriter, r *http.Request) {
-	users, err := db.GetUsersOrderedByLastMessageOrAlphabetically()
+func (s *Server) GetUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := s.store.GetUsersOrderedByLastMessageOrAlphabetically()
 	if err != nil {
 		http.Error(w, "Failed to get users", http.StatusInternalServerError)
 		return
@@ -1,112 +1,200 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"forum/db"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
 
-// RateLimiter implements a simple in-memory rate limiter
-type RateLimiter struct {
-	requests map[string][]time.Time
-	mu       sync.RWMutex
-	limit    int           // max requests
-	window   time.Duration // time window
+// Rate allows Limit requests per Window, in bursts of up to Limit
+type Rate struct {
+	Limit  int
+	Window time.Duration
 }
 
-// NewRateLimiter creates a new rate limiter
-func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
-	rl := &RateLimiter{
-		requests: make(map[string][]time.Time),
-		limit:    limit,
-		window:   window,
-	}
-	// Start cleanup goroutine
-	go rl.cleanup()
-	return rl
+// interval is the time it takes for one request to be allowed again
+func (r Rate) interval() time.Duration {
+	return r.Window / time.Duration(r.Limit)
 }
 
-// Allow checks if the request should be allowed
-func (rl *RateLimiter) Allow(ip string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+// LimitResult is the outcome of a rate limit check
+type LimitResult struct {
+	Allowed bool
+	Limit   int
+	// Remaining is how many more requests are allowed right now
+	Remaining int
+	// Reset is how long until the limit has fully refilled
+	Reset time.Duration
+	// RetryAfter is how long a refused request has to wait
+	RetryAfter time.Duration
+}
 
-	now := time.Now()
-	windowStart := now.Add(-rl.window)
+// Limiter keeps rate limit buckets and decides whether the client
+// identified by key may make a request at rate
+type Limiter interface {
+	Allow(key string, rate Rate) (LimitResult, error)
+}
 
-	// Filter out old requests
-	var validRequests []time.Time
-	for _, t := range rl.requests[ip] {
-		if t.After(windowStart) {
-			validRequests = append(validRequests, t)
-		}
+// gcra applies a request at now to a bucket whose theoretical arrival time
+// is tat, using the generic cell rate algorithm. It returns the result and
+// the bucket's new TAT, which is only to be stored when the request was
+// allowed. The bucket is full whenever its TAT is not after now.
+func gcra(rate Rate, tat, now time.Time) (LimitResult, time.Time) {
+	interval := rate.interval()
+	if tat.Before(now) {
+		tat = now
 	}
-
-	if len(validRequests) >= rl.limit {
-		rl.requests[ip] = validRequests
-		return false
+	newTAT := tat.Add(interval)
+	allowAt := newTAT.Add(-rate.Window)
+	if now.Before(allowAt) {
+		return LimitResult{
+			Limit:      rate.Limit,
+			Reset:      tat.Sub(now),
+			RetryAfter: allowAt.Sub(now),
+		}, tat
 	}
+	return LimitResult{
+		Allowed:   true,
+		Limit:     rate.Limit,
+		Remaining: int(now.Sub(allowAt) / interval),
+		Reset:     newTAT.Sub(now),
+	}, newTAT
+}
 
-	rl.requests[ip] = append(validRequests, now)
-	return true
+// memoryLimiter keeps the buckets of a Limiter in process memory. Each key
+// costs a single timestamp whatever the limit.
+type memoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]time.Time
 }
 
-// cleanup removes old entries periodically
-func (rl *RateLimiter) cleanup() {
-	ticker := time.NewTicker(rl.window)
+// memoryCleanupInterval is how often full in-memory buckets are forgotten
+const memoryCleanupInterval = time.Minute
+
+// NewMemoryLimiter creates a Limiter local to this process and starts a
+// goroutine that forgets full buckets
+func NewMemoryLimiter() Limiter {
+	ml := &memoryLimiter{buckets: make(map[string]time.Time)}
+	go ml.cleanup()
+	return ml
+}
+
+func (ml *memoryLimiter) Allow(key string, rate Rate) (LimitResult, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	res, tat := gcra(rate, ml.buckets[key], time.Now())
+	if res.Allowed {
+		ml.buckets[key] = tat
+	}
+	return res, nil
+}
+
+// cleanup removes full buckets periodically, since they are the same as
+// missing ones
+func (ml *memoryLimiter) cleanup() {
+	ticker := time.NewTicker(memoryCleanupInterval)
+	defer ticker.Stop()
 	for range ticker.C {
-		rl.mu.Lock()
+		ml.mu.Lock()
 		now := time.Now()
-		windowStart := now.Add(-rl.window)
-		for ip, times := range rl.requests {
-			var validTimes []time.Time
-			for _, t := range times {
-				if t.After(windowStart) {
-					validTimes = append(validTimes, t)
-				}
-			}
-			if len(validTimes) == 0 {
-				delete(rl.requests, ip)
-			} else {
-				rl.requests[ip] = validTimes
+		for key, tat := range ml.buckets {
+			if !tat.After(now) {
+				delete(ml.buckets, key)
 			}
 		}
-		rl.mu.Unlock()
+		ml.mu.Unlock()
 	}
 }
 
-// Global rate limiters
-var (
-	// LoginLimiter: 5 attempts per minute per IP
-	LoginLimiter = NewRateLimiter(5, time.Minute)
-	// APILimiter: 100 requests per minute per IP
-	APILimiter = NewRateLimiter(100, time.Minute)
+const (
+	// rateLimitRetries bounds how often a SQL bucket update is retried when
+	// other instances keep changing it
+	rateLimitRetries = 5
+	// rateLimitSweepInterval is how often full SQL buckets are purged
+	rateLimitSweepInterval = 10 * time.Minute
 )
 
-// RateLimitMiddleware wraps a handler with rate limiting
-func RateLimitMiddleware(limiter *RateLimiter, next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getClientIP(r)
-		if !limiter.Allow(ip) {
-			http.Error(w, "Too many requests. Please try again later.", http.StatusTooManyRequests)
-			return
+// errRateLimitContention is returned when a bucket could not be updated
+// within rateLimitRetries attempts
+var errRateLimitContention = errors.New("rate limit bucket contention")
+
+// sqlLimiter keeps the buckets of a Limiter in the database so that every
+// instance of the forum shares them
+type sqlLimiter struct {
+	store db.Store
+}
+
+// NewSQLLimiter creates a Limiter whose buckets are stored in store and
+// starts a background sweeper that purges full buckets
+func NewSQLLimiter(store db.Store) Limiter {
+	sl := &sqlLimiter{store: store}
+	go sl.sweep()
+	return sl
+}
+
+func (sl *sqlLimiter) Allow(key string, rate Rate) (LimitResult, error) {
+	for i := 0; i < rateLimitRetries; i++ {
+		old, err := sl.store.GetRateLimit(key)
+		if err != nil && err != sql.ErrNoRows {
+			return LimitResult{}, err
+		}
+		var tat time.Time
+		if old != 0 {
+			tat = time.Unix(0, old)
 		}
-		next.ServeHTTP(w, r)
-	})
+		res, newTAT := gcra(rate, tat, time.Now())
+		if !res.Allowed {
+			return res, nil
+		}
+		ok, err := sl.store.SetRateLimit(key, old, newTAT.UnixNano())
+		if err != nil {
+			return LimitResult{}, err
+		}
+		if ok {
+			return res, nil
+		}
+	}
+	return LimitResult{}, errRateLimitContention
 }
 
-// getClientIP extracts the client IP from the request
-func getClientIP(r *http.Request) string {
-	// Check for X-Forwarded-For header (for proxies)
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		return forwarded
+// sweep periodically purges full buckets
+func (sl *sqlLimiter) sweep() {
+	ticker := time.NewTicker(rateLimitSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := sl.store.DeleteExpiredRateLimits(time.Now().UnixNano()); err != nil {
+			log.Printf("Failed to remove expired rate limits: %v", err)
+		}
 	}
-	// Check for X-Real-IP header
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
+}
+
+// checkLimit checks the bucket key of limiter at rate and sets the
+// RateLimit-* headers, and Retry-After when the request is refused. Errors
+// from the limiter are logged and let the request through rather than
+// taking the forum down with its backend.
+func checkLimit(w http.ResponseWriter, limiter Limiter, key string, rate Rate) bool {
+	res, err := limiter.Allow(key, rate)
+	if err != nil {
+		log.Printf("Error checking rate limit: %v", err)
+		return true
 	}
-	// Fall back to RemoteAddr
-	return r.RemoteAddr
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+	}
+	return res.Allowed
+}
+
+// ceilSeconds rounds d up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
 }
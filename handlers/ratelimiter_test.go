@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"forum/db"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+func TestGCRA(t *testing.T) {
+	rate := Rate{Limit: 4, Window: time.Minute}
+	now := time.Unix(1000, 0)
+	var tat time.Time
+
+	// A full bucket allows a burst of Limit requests
+	for i := 0; i < rate.Limit; i++ {
+		res, next := gcra(rate, tat, now)
+		if !res.Allowed || res.Remaining != rate.Limit-1-i {
+			t.Fatalf("request %d: %+v", i, res)
+		}
+		tat = next
+	}
+	res, next := gcra(rate, tat, now)
+	if res.Allowed || !next.Equal(tat) {
+		t.Fatalf("request past the burst: %+v", res)
+	}
+	if res.RetryAfter != rate.interval() || res.Reset != rate.Window {
+		t.Fatalf("retry after %v and reset %v, want %v and %v", res.RetryAfter, res.Reset, rate.interval(), rate.Window)
+	}
+
+	// One request is allowed again per interval
+	now = now.Add(rate.interval())
+	if res, tat = gcra(rate, tat, now); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("after one interval: %+v", res)
+	}
+	// A bucket that has been idle for the whole window is full again
+	now = now.Add(rate.Window)
+	if res, _ = gcra(rate, tat, now); !res.Allowed || res.Remaining != rate.Limit-1 {
+		t.Fatalf("after the window: %+v", res)
+	}
+}
+
+func TestCheckLimitHeaders(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	rate := Rate{Limit: 2, Window: time.Minute}
+	want := []struct {
+		allowed    bool
+		remaining  string
+		retryAfter string
+	}{
+		{true, "1", ""},
+		{true, "0", ""},
+		{false, "0", "30"},
+	}
+	for i, w := range want {
+		rec := httptest.NewRecorder()
+		allowed := checkLimit(rec, limiter, "key", rate)
+		h := rec.Header()
+		if allowed != w.allowed {
+			t.Fatalf("request %d allowed = %v", i, allowed)
+		}
+		if h.Get("RateLimit-Limit") != "2" || h.Get("RateLimit-Remaining") != w.remaining || h.Get("Retry-After") != w.retryAfter {
+			t.Errorf("request %d headers: %v", i, h)
+		}
+		reset, err := strconv.Atoi(h.Get("RateLimit-Reset"))
+		if err != nil || reset < 1 || reset > 60 {
+			t.Errorf("request %d RateLimit-Reset = %q", i, h.Get("RateLimit-Reset"))
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limits := DefaultRateLimits()
+	limits.Policies[LimitLogin] = Rate{Limit: 1, Window: time.Hour}
+	srv, _ := newTestServer(t, Config{RateLimits: limits})
+	h := srv.RateLimit(LimitLogin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := request("192.0.2.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", rec.Code)
+	}
+	rec := request("192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "3600" {
+		t.Fatalf("second request: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := request("192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("other client: status %d", rec.Code)
+	}
+}
+
+// contendedStore simulates other instances that update a bucket between
+// the read and the compare-and-set of sqlLimiter, conflicts times in a row
+type contendedStore struct {
+	db.Store
+	conflicts int
+	attempts  int
+}
+
+func (s *contendedStore) SetRateLimit(key string, old, tat int64) (bool, error) {
+	s.attempts++
+	if s.attempts <= s.conflicts {
+		if _, err := s.Store.SetRateLimit(key, old, old+1); err != nil {
+			return false, err
+		}
+	}
+	return s.Store.SetRateLimit(key, old, tat)
+}
+
+func TestSQLLimiterRetriesCompareAndSet(t *testing.T) {
+	rate := Rate{Limit: 100, Window: time.Minute}
+	for _, conflicts := range []int{0, 1, rateLimitRetries - 1} {
+		t.Run(fmt.Sprintf("%d conflicts", conflicts), func(t *testing.T) {
+			store := &contendedStore{Store: newTestStore(t), conflicts: conflicts}
+			res, err := (&sqlLimiter{store: store}).Allow("key", rate)
+			if err != nil || !res.Allowed {
+				t.Fatalf("Allow = %+v, %v", res, err)
+			}
+			if store.attempts != conflicts+1 {
+				t.Fatalf("%d attempts, want %d", store.attempts, conflicts+1)
+			}
+		})
+	}
+
+	t.Run("contention", func(t *testing.T) {
+		store := &contendedStore{Store: newTestStore(t), conflicts: rateLimitRetries}
+		limiter := &sqlLimiter{store: store}
+		if _, err := limiter.Allow("key", rate); err != errRateLimitContention {
+			t.Fatalf("err = %v, want errRateLimitContention", err)
+		}
+		if store.attempts != rateLimitRetries {
+			t.Fatalf("%d attempts, want %d", store.attempts, rateLimitRetries)
+		}
+		// The request goes through rather than failing on the limiter
+		store.attempts = 0
+		if !checkLimit(httptest.NewRecorder(), limiter, "key", rate) {
+			t.Fatal("request refused on contention")
+		}
+	})
+}
+
+func TestSQLLimiterSharedBucket(t *testing.T) {
+	store := newTestStore(t)
+	// Two instances sharing the store never allow more than the limit
+	instances := []Limiter{&sqlLimiter{store: store}, &sqlLimiter{store: store}}
+	rate := Rate{Limit: 20, Window: time.Hour}
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(limiter Limiter) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				res, err := limiter.Allow("shared", rate)
+				if err == nil && res.Allowed {
+					allowed.Add(1)
+				}
+			}
+		}(instances[i%2])
+	}
+	wg.Wait()
+	if n := allowed.Load(); n == 0 || n > int64(rate.Limit) {
+		t.Fatalf("%d requests allowed, want 1 to %d", n, rate.Limit)
+	}
+}
+
+// slidingLog is the limiter GCRA replaced, kept to compare the two. It
+// stores the time of every request in the window.
+type slidingLog struct {
+	mu       sync.Mutex
+	requests map[string][]time.Time
+}
+
+func (sl *slidingLog) Allow(key string, rate Rate) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	now := time.Now()
+	windowStart := now.Add(-rate.Window)
+	var valid []time.Time
+	for _, t := range sl.requests[key] {
+		if t.After(windowStart) {
+			valid = append(valid, t)
+		}
+	}
+	if len(valid) >= rate.Limit {
+		sl.requests[key] = valid
+		return false
+	}
+	sl.requests[key] = append(valid, now)
+	return true
+}
+
+// BenchmarkLimiter compares GCRA with the sliding log it replaced. Keys are
+// spread over keys clients; with one client every request after the first
+// Limit hits a full log.
+func BenchmarkLimiter(b *testing.B) {
+	rate := Rate{Limit: 100, Window: time.Minute}
+	for _, keys := range []int{1, 1000} {
+		names := make([]string, keys)
+		for i := range names {
+			names[i] = "api:ip:192.0.2." + strconv.Itoa(i)
+		}
+		b.Run(fmt.Sprintf("gcra/keys=%d", keys), func(b *testing.B) {
+			limiter := NewMemoryLimiter()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				limiter.Allow(names[i%keys], rate)
+			}
+		})
+		b.Run(fmt.Sprintf("slidinglog/keys=%d", keys), func(b *testing.B) {
+			limiter := &slidingLog{requests: make(map[string][]time.Time)}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				limiter.Allow(names[i%keys], rate)
+			}
+		})
+		b.Run(fmt.Sprintf("gcra-sql/keys=%d", keys), func(b *testing.B) {
+			limiter := &sqlLimiter{store: newTestStore(b)}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := limiter.Allow(names[i%keys], rate); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPasswordLoginIsRateLimited(t *testing.T) {
+	limits := DefaultRateLimits()
+	limits.Policies[LimitLogin] = Rate{Limit: 2, Window: time.Hour}
+	srv, store := newTestServer(t, Config{RateLimits: limits})
+	addUser(t, store, "alice", true)
+	h := srv.Handler()
+	for i := 0; i < 3; i++ {
+		// Distinct accounts, as in password spraying
+		code, _ := csrfRequest{}.withPreSession(t, h).form(h, "/", "username=nobody"+strconv.Itoa(i)+"&password=x")
+		want := http.StatusForbidden
+		if i == 2 {
+			want = http.StatusTooManyRequests
+		}
+		if code != want {
+			t.Fatalf("login %d: status %d, want %d", i, code, want)
+		}
+	}
+}
+
+// recordingLimiter allows everything and records the bucket and rate of
+// each request
+type recordingLimiter struct {
+	keys  []string
+	rates []Rate
+}
+
+func (l *recordingLimiter) Allow(key string, rate Rate) (LimitResult, error) {
+	l.keys = append(l.keys, key)
+	l.rates = append(l.rates, rate)
+	return LimitResult{Allowed: true, Limit: rate.Limit, Remaining: rate.Limit}, nil
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	limits := DefaultRateLimits()
+	limits.RoleMultipliers = map[string]float64{db.RoleModerator: 2.5, db.RoleUser: 0.001}
+	limiter := &recordingLimiter{}
+	srv, store := newTestServer(t, Config{RateLimits: limits, RateLimiter: limiter})
+	aliceID := addUser(t, store, "alice", true)
+	bobID := addUser(t, store, "bob", true)
+	if err := store.SetUserRole("bob", db.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	limited := srv.RateLimit(LimitAPI, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h := srv.RequireLogin(limited)
+	api := limits.Policies[LimitAPI].Limit
+
+	request := func(h http.Handler, r *http.Request) {
+		t.Helper()
+		r.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	withSession := func(username string) *http.Request {
+		session, _ := newTestSession(t, srv, username)
+		r := httptest.NewRequest("GET", "/api/posts", nil)
+		r.AddCookie(&http.Cookie{Name: "session_token", Value: session})
+		return r
+	}
+	// The role comes from the request context, without a lookup of the user
+	unknown := uuid.Must(uuid.NewV4())
+	roleOnly := httptest.NewRequest("GET", "/api/posts", nil)
+	roleOnly = roleOnly.WithContext(withUser(roleOnly.Context(), unknown, db.RoleModerator))
+
+	request(limited, httptest.NewRequest("GET", "/api/posts", nil))
+	request(h, withSession("alice"))
+	request(h, withSession("bob"))
+	request(limited, roleOnly)
+
+	want := []struct {
+		key   string
+		limit int
+	}{
+		{"api:ip:192.0.2.1", api},
+		{"api:user:" + aliceID.String(), 1}, // scaled below one, floored
+		{"api:user:" + bobID.String(), api * 5 / 2},
+		{"api:user:" + unknown.String(), api * 5 / 2},
+	}
+	if len(limiter.keys) != len(want) {
+		t.Fatalf("limiter called for %v", limiter.keys)
+	}
+	for i, w := range want {
+		if limiter.keys[i] != w.key || limiter.rates[i].Limit != w.limit {
+			t.Errorf("request %d: key %q limit %d, want %q and %d", i, limiter.keys[i], limiter.rates[i].Limit, w.key, w.limit)
+		}
+		if limiter.rates[i].Window != limits.Policies[LimitAPI].Window {
+			t.Errorf("request %d: window %v", i, limiter.rates[i].Window)
+		}
+	}
+}
+
+func TestUserRate(t *testing.T) {
+	srv := &Server{config: Config{RateLimits: RateLimits{RoleMultipliers: map[string]float64{
+		db.RoleAdmin:     10,
+		db.RoleModerator: 1.5,
+		db.RoleUser:      0.1,
+	}}}}
+	rate := Rate{Limit: 5, Window: time.Minute}
+	tests := []struct {
+		role string
+		want int
+	}{
+		{db.RoleAdmin, 50},
+		{db.RoleModerator, 7}, // rounded down
+		{db.RoleUser, 1},      // 0.5 is floored to one request
+		{"", 5},               // no multiplier
+		{"guest", 5},
+	}
+	for _, tt := range tests {
+		got := srv.userRate(tt.role, rate)
+		if got.Limit != tt.want || got.Window != rate.Window {
+			t.Errorf("userRate(%q) = %+v, want limit %d", tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	load := func(json string) (RateLimits, error) {
+		path := filepath.Join(t.TempDir(), "ratelimits.json")
+		if err := os.WriteFile(path, []byte(json), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return LoadRateLimits(path)
+	}
+
+	limits, err := load(`{
+		"policies": {"post": {"limit": 20, "window": "30m"}},
+		"role_multipliers": {"moderator": 3}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultRateLimits()
+	for name, rate := range defaults.Policies {
+		want := rate
+		if name == LimitPost {
+			want = Rate{Limit: 20, Window: 30 * time.Minute}
+		}
+		if limits.Policies[name] != want {
+			t.Errorf("policy %s = %+v, want %+v", name, limits.Policies[name], want)
+		}
+	}
+	if len(limits.RoleMultipliers) != 1 || limits.RoleMultipliers[db.RoleModerator] != 3 {
+		t.Errorf("multipliers = %v", limits.RoleMultipliers)
+	}
+
+	for _, tt := range []struct{ json, err string }{
+		{`{"policies": {"uploads": {"limit": 1, "window": "1m"}}}`, "unknown rate limit policy"},
+		{`{"policies": {"post": {"limit": 0, "window": "1m"}}}`, "positive limit and window"},
+		{`{"policies": {"post": {"limit": -1, "window": "1m"}}}`, "positive limit and window"},
+		{`{"policies": {"post": {"limit": 1, "window": "0s"}}}`, "positive limit and window"},
+		{`{"policies": {"post": {"limit": 1, "window": "-1m"}}}`, "positive limit and window"},
+		{`{"policies": {"post": {"limit": 1}}}`, "positive limit and window"},
+		{`{"role_multipliers": {"user": 0}}`, "must be positive"},
+		{`{"role_multipliers": {"user": -2}}`, "must be positive"},
+		{`{"policies": []}`, "parse"},
+	} {
+		if _, err := load(tt.json); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: err = %v, want %q", tt.json, err, tt.err)
+		}
+	}
+	if _, err := LoadRateLimits(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
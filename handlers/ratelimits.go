@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// Rate limit policies. Each route is limited by one policy.
+const (
+	LimitLogin        = "login"
+	LimitAPI          = "api"
+	LimitVerification = "verification"
+	LimitPost         = "post"
+	LimitComment      = "comment"
+	LimitMessage      = "message"
+	LimitCategory     = "category"
+)
+
+// RateLimits declares the rate limit of each policy and how much more
+// each role may do
+type RateLimits struct {
+	Policies map[string]Rate
+	// RoleMultipliers scale the limits of authenticated users by role
+	RoleMultipliers map[string]float64
+}
+
+// DefaultRateLimits returns the limits used without a config file
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		Policies: map[string]Rate{
+			LimitLogin:        {Limit: 5, Window: time.Minute},
+			LimitAPI:          {Limit: 100, Window: time.Minute},
+			LimitVerification: {Limit: 3, Window: time.Hour},
+			LimitPost:         {Limit: 10, Window: time.Hour},
+			LimitComment:      {Limit: 10, Window: time.Minute},
+			LimitMessage:      {Limit: 30, Window: time.Minute},
+			LimitCategory:     {Limit: 5, Window: 24 * time.Hour},
+		},
+		RoleMultipliers: map[string]float64{},
+	}
+}
+
+// rateLimitsFile is the JSON form of RateLimits, with windows written as
+// Go durations such as "1m" or "24h"
+type rateLimitsFile struct {
+	Policies map[string]struct {
+		Limit  int    `json:"limit"`
+		Window string `json:"window"`
+	} `json:"policies"`
+	RoleMultipliers map[string]float64 `json:"role_multipliers"`
+}
+
+// LoadRateLimits reads rate limits from a JSON file. Policies missing from
+// the file keep their defaults.
+func LoadRateLimits(path string) (RateLimits, error) {
+	limits := DefaultRateLimits()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return limits, err
+	}
+	var file rateLimitsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return limits, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for name, p := range file.Policies {
+		if _, ok := limits.Policies[name]; !ok {
+			return limits, fmt.Errorf("unknown rate limit policy %q", name)
+		}
+		window, err := time.ParseDuration(p.Window)
+		if err != nil || window <= 0 || p.Limit <= 0 {
+			return limits, fmt.Errorf("rate limit policy %s needs a positive limit and window", name)
+		}
+		limits.Policies[name] = Rate{Limit: p.Limit, Window: window}
+	}
+	for role, m := range file.RoleMultipliers {
+		if m <= 0 {
+			return limits, fmt.Errorf("multiplier of role %s must be positive", role)
+		}
+		limits.RoleMultipliers[role] = m
+	}
+	return limits, nil
+}
+
+// RateLimit is a middleware that limits requests by policy. Authenticated
+// requests are counted per user, so users behind a shared address do not
+// compete, and others per client address. It goes inside RequireLogin on
+// routes that need a login, which supplies the user and their role.
+func (s *Server) RateLimit(policy string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := policy + ":ip:" + clientKey(s.clientIP(r))
+		rate := s.config.RateLimits.Policies[policy]
+		if userID, ok := r.Context().Value(userIDKey).(uuid.UUID); ok {
+			key = policy + ":user:" + userID.String()
+			role, _ := r.Context().Value(userRoleKey).(string)
+			rate = s.userRate(role, rate)
+		}
+		if !checkLimit(w, s.config.RateLimiter, key, rate) {
+			http.Error(w, "Too many requests. Please try again later.", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimitPost is RateLimit for POST requests only, for routes that serve
+// a page on GET and act on POST
+func (s *Server) RateLimitPost(policy string, next http.Handler) http.Handler {
+	limited := s.RateLimit(policy, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			limited.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowUser reports whether the user, who has the given role, may act
+// under policy, for actions that do not come through RateLimit such as
+// websocket messages
+func (s *Server) allowUser(policy string, userID uuid.UUID, role string) bool {
+	rate := s.userRate(role, s.config.RateLimits.Policies[policy])
+	res, err := s.config.RateLimiter.Allow(policy+":user:"+userID.String(), rate)
+	if err != nil {
+		log.Printf("Error checking rate limit: %v", err)
+		return true
+	}
+	return res.Allowed
+}
+
+// userRate scales rate by the multiplier of role, never below one request
+// per window
+func (s *Server) userRate(role string, rate Rate) Rate {
+	if m, ok := s.config.RateLimits.RoleMultipliers[role]; ok {
+		rate.Limit = int(float64(rate.Limit) * m)
+		if rate.Limit < 1 {
+			rate.Limit = 1
+		}
+	}
+	return rate
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum/db"
+	"log"
+	"net/http"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// reactionRequest is the JSON body shared by the reaction endpoints. Exactly
+// one of PostID and CommentID identifies the target; Type is ignored when
+// removing a reaction.
+type reactionRequest struct {
+	PostID    string `json:"post_id"`
+	CommentID string `json:"comment_id"`
+	Type      string `json:"type"`
+}
+
+// AddPostReactionHandler handles reacting to posts. Repeating the current
+// reaction removes it and a different one replaces it.
+func (s *Server) AddPostReactionHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleReaction(w, r, "post", true)
+}
+
+// AddCommentReactionHandler handles reacting to comments
+func (s *Server) AddCommentReactionHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleReaction(w, r, "comment", true)
+}
+
+// RemovePostReactionHandler removes the user's reaction to a post
+func (s *Server) RemovePostReactionHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleReaction(w, r, "post", false)
+}
+
+// RemoveCommentReactionHandler removes the user's reaction to a comment
+func (s *Server) RemoveCommentReactionHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleReaction(w, r, "comment", false)
+}
+
+// handleReaction decodes a reactionRequest for target ("post" or "comment"),
+// applies or removes the reaction and responds with the resulting
+// ReactionMessage, which is also broadcast to every websocket client
+func (s *Server) handleReaction(w http.ResponseWriter, r *http.Request, target string, add bool) {
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var requestData reactionRequest
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+
+	idStr := requestData.PostID
+	if target == "comment" {
+		idStr = requestData.CommentID
+	}
+	if idStr == "" || (add && requestData.Type == "") {
+		http.Error(w, "Target ID and reaction type are required", http.StatusBadRequest)
+		return
+	}
+	targetID, err := uuid.FromString(idStr)
+	if err != nil {
+		http.Error(w, "Invalid "+target+" ID", http.StatusBadRequest)
+		return
+	}
+
+	msg := db.ReactionMessage{Type: "reaction", UserID: userID}
+	if target == "post" {
+		msg.PostID = targetID
+	} else {
+		msg.CommentID = targetID
+	}
+	if err := s.loadReactionCounts(&msg); err != nil {
+		http.Error(w, "Target not found", http.StatusNotFound)
+		return
+	}
+	if add {
+		err = s.applyReaction(&msg, db.ReactionType(requestData.Type))
+	} else {
+		err = s.removeReaction(&msg)
+	}
+	if errors.Is(err, db.ErrInvalidReaction) {
+		http.Error(w, "Invalid reaction type", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating reaction: %v", err)
+		http.Error(w, "Failed to update reaction", http.StatusInternalServerError)
+		return
+	}
+	s.hub.Broadcast(newFrame("reaction", msg))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
+
+// applyReaction toggles msg.UserID's reaction on the target of msg and fills
+// in the resulting reaction and counts
+func (s *Server) applyReaction(msg *db.ReactionMessage, reactionType db.ReactionType) error {
+	var err error
+	if msg.PostID != uuid.Nil {
+		msg.ReactionType, err = s.store.AddPostReaction(msg.UserID, msg.PostID, reactionType)
+	} else {
+		msg.ReactionType, err = s.store.AddCommentReaction(msg.UserID, msg.CommentID, reactionType)
+	}
+	if err != nil {
+		return err
+	}
+	return s.loadReactionCounts(msg)
+}
+
+// removeReaction removes msg.UserID's reaction on the target of msg and fills
+// in the resulting counts
+func (s *Server) removeReaction(msg *db.ReactionMessage) error {
+	var err error
+	if msg.PostID != uuid.Nil {
+		err = s.store.RemovePostReaction(msg.UserID, msg.PostID)
+	} else {
+		err = s.store.RemoveCommentReaction(msg.UserID, msg.CommentID)
+	}
+	if err != nil {
+		return err
+	}
+	msg.ReactionType = ""
+	return s.loadReactionCounts(msg)
+}
+
+// loadReactionCounts sets the aggregate counts of the target of msg
+func (s *Server) loadReactionCounts(msg *db.ReactionMessage) error {
+	if msg.PostID != uuid.Nil {
+		post, err := s.store.GetPostByID(msg.PostID)
+		if err != nil {
+			return err
+		}
+		msg.LikeCount, msg.DislikeCount, msg.Reactions = post.LikeCount, post.DislikeCount, post.Reactions
+		return nil
+	}
+	comment, err := s.store.GetCommentByID(msg.CommentID)
+	if err != nil {
+		return err
+	}
+	msg.LikeCount, msg.DislikeCount, msg.Reactions = comment.LikeCount, comment.DislikeCount, comment.Reactions
+	return nil
+}
+
+// isAdmin reports whether the user has the admin role and meets its 2FA
+// policy
+func (s *Server) isAdmin(userID uuid.UUID) bool {
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error getting user by ID: %v", err)
+		return false
+	}
+	return user.Role == db.RoleAdmin && s.twoFactorSatisfied(user)
+}
+
+// GetReactionTypesHandler lists the reactions users can choose from.
+// Admins may pass all=true to include disabled ones.
+func (s *Server) GetReactionTypesHandler(w http.ResponseWriter, r *http.Request) {
+	all := false
+	if r.URL.Query().Get("all") == "true" {
+		userID, err := s.getUserIDFromSession(r)
+		if err != nil || !s.isAdmin(userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		all = true
+	}
+	kinds, err := s.store.GetReactionKinds(all)
+	if err != nil {
+		log.Printf("Error getting reaction types: %v", err)
+		http.Error(w, "Failed to get reaction types", http.StatusInternalServerError)
+		return
+	}
+	if kinds == nil {
+		kinds = []db.ReactionKind{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(kinds)
+}
+
+// CreateReactionTypeHandler lets admins add a reaction type
+func (s *Server) CreateReactionTypeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.isAdmin(userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var requestData struct {
+		Name     string `json:"name"`
+		Emoji    string `json:"emoji"`
+		Position int    `json:"position"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+	if requestData.Name == "" || requestData.Emoji == "" {
+		http.Error(w, "Name and emoji are required", http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.CreateReactionKind(db.ReactionType(requestData.Name), requestData.Emoji, requestData.Position)
+	if err == db.ErrReactionExists {
+		http.Error(w, "Reaction type already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating reaction type: %v", err)
+		http.Error(w, "Failed to create reaction type", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// UpdateReactionTypeHandler lets admins change a reaction type's emoji and
+// position or disable it. Disabled types keep their existing reactions but
+// cannot be chosen any more.
+func (s *Server) UpdateReactionTypeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.isAdmin(userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var kind db.ReactionKind
+	err = json.NewDecoder(r.Body).Decode(&kind)
+	if err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+	if kind.ID == 0 || kind.Emoji == "" {
+		http.Error(w, "ID and emoji are required", http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.UpdateReactionKind(kind)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Reaction type not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating reaction type: %v", err)
+		http.Error(w, "Failed to update reaction type", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
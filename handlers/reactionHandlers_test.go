@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"forum/db"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// reactionKinds lists the reaction types as seen by session
+func reactionKinds(t *testing.T, srv *Server, session string, all bool) (int, []db.ReactionKind) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/reaction-types?all="+strconv.FormatBool(all), nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: session})
+	rec := httptest.NewRecorder()
+	srv.GetReactionTypesHandler(rec, req)
+	var kinds []db.ReactionKind
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&kinds); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, kinds
+}
+
+// reactionKind returns the registered reaction type called name
+func reactionKind(t *testing.T, store db.Store, name db.ReactionType) db.ReactionKind {
+	t.Helper()
+	kinds, err := store.GetReactionKinds(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range kinds {
+		if k.Name == name {
+			return k
+		}
+	}
+	t.Fatalf("no reaction type %q", name)
+	return db.ReactionKind{}
+}
+
+// react adds the reaction type to postID as session
+func react(srv *Server, session string, postID uuid.UUID, reactionType string) *httptest.ResponseRecorder {
+	return callHandler(srv.AddPostReactionHandler, session, `{"post_id": "`+postID.String()+`", "type": "`+reactionType+`"}`)
+}
+
+func TestReactionTypesNeedAdmin(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	addUser(t, store, "alice", true)
+	addUser(t, store, "root", true)
+	if err := store.SetUserRole("root", db.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	alice, _ := newTestSession(t, srv, "alice")
+	root, _ := newTestSession(t, srv, "root")
+	like := reactionKind(t, store, "like")
+	update := `{"id": ` + strconv.Itoa(like.ID) + `, "emoji": "X", "position": 1, "enabled": false}`
+
+	if rec := callHandler(srv.CreateReactionTypeHandler, alice, `{"name": "party", "emoji": "P"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("user creating a type: status %d", rec.Code)
+	}
+	if rec := callHandler(srv.UpdateReactionTypeHandler, alice, update); rec.Code != http.StatusForbidden {
+		t.Fatalf("user updating a type: status %d", rec.Code)
+	}
+	if code, _ := reactionKinds(t, srv, alice, true); code != http.StatusForbidden {
+		t.Fatalf("user listing disabled types: status %d", code)
+	}
+	if code, kinds := reactionKinds(t, srv, alice, false); code != http.StatusOK || len(kinds) == 0 {
+		t.Fatalf("user listing types: status %d, %d types", code, len(kinds))
+	}
+	if got := reactionKind(t, store, "like"); got != like {
+		t.Fatalf("like changed by a user: %+v", got)
+	}
+
+	if rec := callHandler(srv.CreateReactionTypeHandler, root, `{"name": "party", "emoji": "P"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("admin creating a type: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := callHandler(srv.UpdateReactionTypeHandler, root, update); rec.Code != http.StatusOK {
+		t.Fatalf("admin updating a type: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := callHandler(srv.UpdateReactionTypeHandler, root, `{"id": 9999, "emoji": "X"}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("admin updating an unknown type: status %d", rec.Code)
+	}
+	code, kinds := reactionKinds(t, srv, root, true)
+	if code != http.StatusOK {
+		t.Fatalf("admin listing all types: status %d", code)
+	}
+	found := map[db.ReactionType]bool{}
+	for _, k := range kinds {
+		found[k.Name] = k.Enabled
+	}
+	if enabled, ok := found["like"]; !ok || enabled {
+		t.Fatalf("disabled like missing from the full list: %+v", kinds)
+	}
+	if !found["party"] {
+		t.Fatalf("party missing from the full list: %+v", kinds)
+	}
+}
+
+func TestReactionTypeNamesAreUnique(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	addUser(t, store, "root", true)
+	if err := store.SetUserRole("root", db.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	root, _ := newTestSession(t, srv, "root")
+
+	if rec := callHandler(srv.CreateReactionTypeHandler, root, `{"name": "like", "emoji": "X"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("duplicating a built-in type: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := callHandler(srv.CreateReactionTypeHandler, root, `{"name": "party", "emoji": "P"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("new type: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := callHandler(srv.CreateReactionTypeHandler, root, `{"name": "party", "emoji": "Q"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("duplicating a new type: status %d: %s", rec.Code, rec.Body)
+	}
+	if got := reactionKind(t, store, "party"); got.Emoji != "P" {
+		t.Fatalf("party = %+v after the duplicate", got)
+	}
+}
+
+func TestDisabledReactionTypes(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	aliceID := addUser(t, store, "alice", true)
+	addUser(t, store, "bob", true)
+	alice, _ := newTestSession(t, srv, "alice")
+	bob, _ := newTestSession(t, srv, "bob")
+	post := addPost(t, store, aliceID, "Hello")
+	comment, err := store.CreateComment(post, aliceID, nil, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := react(srv, alice, post, "like"); rec.Code != http.StatusOK {
+		t.Fatalf("liking: status %d: %s", rec.Code, rec.Body)
+	}
+
+	like := reactionKind(t, store, "like")
+	like.Enabled = false
+	if err := store.UpdateReactionKind(like); err != nil {
+		t.Fatal(err)
+	}
+	if rec := react(srv, bob, post, "like"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("post reaction of a disabled type: status %d", rec.Code)
+	}
+	rec := callHandler(srv.AddCommentReactionHandler, bob, `{"comment_id": "`+comment.String()+`", "type": "like"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("comment reaction of a disabled type: status %d", rec.Code)
+	}
+	if rec := react(srv, bob, post, "no-such-type"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown type: status %d", rec.Code)
+	}
+
+	// Existing reactions of a disabled type still count
+	p, err := store.GetPostByID(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.LikeCount != 1 || len(p.Reactions) != 1 || p.Reactions[0].Type != "like" || p.Reactions[0].Count != 1 {
+		t.Fatalf("counts after disabling like: %d likes, %+v", p.LikeCount, p.Reactions)
+	}
+}
+
+func TestReactionCountsFollowTypeID(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	aliceID := addUser(t, store, "alice", true)
+	addUser(t, store, "bob", true)
+	addUser(t, store, "carol", true)
+	post := addPost(t, store, aliceID, "Hello")
+	if err := store.CreateReactionKind("party", "P", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg db.ReactionMessage
+	for username, reactionType := range map[string]string{"alice": "party", "bob": "party", "carol": "like"} {
+		session, _ := newTestSession(t, srv, username)
+		rec := react(srv, session, post, reactionType)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s reacting: status %d: %s", username, rec.Code, rec.Body)
+		}
+		msg = db.ReactionMessage{}
+		if err := json.NewDecoder(rec.Body).Decode(&msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts := map[db.ReactionType]db.ReactionCount{}
+	for _, c := range msg.Reactions {
+		counts[c.Type] = c
+	}
+	if counts["party"].Count != 2 || counts["like"].Count != 1 || len(counts) != 2 {
+		t.Fatalf("counts = %+v", msg.Reactions)
+	}
+
+	// Counts are joined on the type's ID, so they pick up a new emoji
+	party := reactionKind(t, store, "party")
+	party.Emoji = "Q"
+	if err := store.UpdateReactionKind(party); err != nil {
+		t.Fatal(err)
+	}
+	p, err := store.GetPostByID(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range p.Reactions {
+		if c.Type == "party" && (c.Emoji != "Q" || c.Count != 2) {
+			t.Fatalf("party after the emoji change: %+v", c)
+		}
+	}
+}
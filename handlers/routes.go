@@ -0,0 +1,76 @@
+package handlers
+
+import "net/http"
+
+// Handler returns the forum's routes behind the CSRF middleware
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Serve static files
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+
+	// Page routes (with login rate limiting)
+	mux.Handle("/", s.RateLimitPost(LimitLogin, http.HandlerFunc(s.MainPageHandler)))
+	mux.Handle("/registration", s.RateLimit(LimitLogin, http.HandlerFunc(s.SignupHandler)))
+	mux.HandleFunc("/homepage", s.HomepageHandler)
+	mux.HandleFunc("/logout", s.LogoutHandler)
+	mux.Handle("/api/forgot-password", s.RateLimit(LimitLogin, http.HandlerFunc(s.ForgotPasswordHandler)))
+	mux.HandleFunc("/reset-password", s.ResetPasswordPageHandler)
+	mux.Handle("/api/reset-password", s.RateLimit(LimitLogin, http.HandlerFunc(s.ResetPasswordHandler)))
+	mux.HandleFunc("/verify-email", s.VerifyEmailHandler)
+	mux.HandleFunc("/api/oidc-providers", s.GetOIDCProvidersHandler)
+	mux.Handle("/oidc/login", s.RateLimit(LimitLogin, http.HandlerFunc(s.OIDCLoginHandler)))
+	mux.HandleFunc("/oidc/callback", s.OIDCCallbackHandler)
+	mux.Handle("/api/login-2fa", s.RateLimit(LimitLogin, http.HandlerFunc(s.LoginTwoFactorHandler)))
+	mux.Handle("/api/resend-verification", s.RequireLogin(s.RateLimit(LimitVerification, http.HandlerFunc(s.ResendVerificationHandler))))
+
+	// API routes with rate limiting
+	mux.HandleFunc("/api/validate-session", s.ValidateSessionHandler)
+	mux.Handle("/api/csrf-token", s.RateLimit(LimitAPI, http.HandlerFunc(s.GetCSRFTokenHandler)))
+	mux.Handle("/api/sessions", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetSessionsHandler))))
+	mux.Handle("/api/revoke-session", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.RevokeSessionHandler))))
+	mux.Handle("/api/revoke-other-sessions", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.RevokeOtherSessionsHandler))))
+	mux.Handle("/api/api-tokens", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetAPITokensHandler))))
+	mux.Handle("/api/create-api-token", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.CreateAPITokenHandler))))
+	mux.Handle("/api/revoke-api-token", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.RevokeAPITokenHandler))))
+	mux.Handle("/api/login-history", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetLoginHistoryHandler))))
+	mux.Handle("/api/2fa-status", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.TwoFactorStatusHandler))))
+	mux.Handle("/api/setup-2fa", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.SetupTwoFactorHandler))))
+	mux.Handle("/api/confirm-2fa", s.RequireLogin(s.RateLimit(LimitLogin, http.HandlerFunc(s.ConfirmTwoFactorHandler))))
+	mux.Handle("/api/disable-2fa", s.RequireLogin(s.RateLimit(LimitLogin, http.HandlerFunc(s.DisableTwoFactorHandler))))
+	mux.Handle("/api/regenerate-recovery-codes", s.RequireLogin(s.RateLimit(LimitLogin, http.HandlerFunc(s.RegenerateRecoveryCodesHandler))))
+	mux.Handle("/api/set-2fa-policy", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.SetTwoFactorPolicyHandler))))
+
+	mux.Handle("/api/create-category", s.RequireLogin(s.RateLimit(LimitCategory, http.HandlerFunc(s.CreateCategoryHandler))))
+	mux.Handle("/api/get-categories", s.AllowToken(ScopeRead, s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetCategoriesHandler)))))
+	mux.Handle("/api/get-category", s.AllowToken(ScopeRead, s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetCategoryByIDHandler)))))
+
+	mux.Handle("/api/create-post", s.AllowToken(ScopePost, s.RequireLogin(s.RateLimit(LimitPost, s.RequireVerified(ActionPost, http.HandlerFunc(s.CreatePostHandler))))))
+	mux.Handle("/api/create-comment", s.AllowToken(ScopePost, s.RequireLogin(s.RateLimit(LimitComment, s.RequireVerified(ActionComment, http.HandlerFunc(s.CreateCommentHandler))))))
+	mux.Handle("/api/edit-post", s.AllowToken(ScopePost, s.RequireLogin(s.RateLimit(LimitAPI, s.RequireVerified(ActionPost, http.HandlerFunc(s.EditPostHandler))))))
+	mux.Handle("/api/delete-post", s.AllowToken(ScopePost, s.RequireLogin(s.RateLimit(LimitAPI, s.RequireVerified(ActionPost, http.HandlerFunc(s.DeletePostHandler))))))
+	mux.Handle("/api/edit-comment", s.AllowToken(ScopePost, s.RequireLogin(s.RateLimit(LimitAPI, s.RequireVerified(ActionComment, http.HandlerFunc(s.EditCommentHandler))))))
+	mux.Handle("/api/delete-comment", s.AllowToken(ScopePost, s.RequireLogin(s.RateLimit(LimitAPI, s.RequireVerified(ActionComment, http.HandlerFunc(s.DeleteCommentHandler))))))
+	mux.Handle("/api/get-revisions", s.AllowToken(ScopeRead, s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetRevisionsHandler)))))
+	mux.Handle("/api/get-posts", s.AllowToken(ScopeRead, s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetPostsHandler)))))
+	mux.Handle("/api/search", s.AllowToken(ScopeRead, s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.SearchHandler)))))
+	mux.Handle("/api/get-comments", s.AllowToken(ScopeRead, s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetCommentsHandler)))))
+	mux.Handle("/api/send-message", s.AllowToken(ScopeMessage, s.RequireLogin(s.RateLimit(LimitMessage, s.RequireVerified(ActionMessage, http.HandlerFunc(s.SendMessageHandler))))))
+	mux.Handle("/api/get-messages", s.AllowToken(ScopeMessage, s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetMessagesHandler)))))
+	mux.Handle("/api/update-status", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.UpdateStatusHandler))))
+	mux.Handle("/api/get-user-status", s.AllowToken(ScopeRead, s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetUserStatusHandler)))))
+	mux.Handle("/api/mark-message-read", s.AllowToken(ScopeMessage, s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.MarkMessageAsReadHandler)))))
+	mux.Handle("/api/get-users", s.AllowToken(ScopeRead, s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetUsersHandler)))))
+	mux.Handle("/api/add-post-reaction", s.AllowToken(ScopePost, s.RequireLogin(s.RateLimit(LimitAPI, s.RequireVerified(ActionReact, http.HandlerFunc(s.AddPostReactionHandler))))))
+	mux.Handle("/api/add-comment-reaction", s.AllowToken(ScopePost, s.RequireLogin(s.RateLimit(LimitAPI, s.RequireVerified(ActionReact, http.HandlerFunc(s.AddCommentReactionHandler))))))
+	mux.Handle("/api/remove-post-reaction", s.AllowToken(ScopePost, s.RequireLogin(s.RateLimit(LimitAPI, s.RequireVerified(ActionReact, http.HandlerFunc(s.RemovePostReactionHandler))))))
+	mux.Handle("/api/reaction-types", s.AllowToken(ScopeRead, s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.GetReactionTypesHandler)))))
+	mux.Handle("/api/create-reaction-type", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.CreateReactionTypeHandler))))
+	mux.Handle("/api/update-reaction-type", s.RequireLogin(s.RateLimit(LimitAPI, http.HandlerFunc(s.UpdateReactionTypeHandler))))
+	mux.Handle("/api/remove-comment-reaction", s.AllowToken(ScopePost, s.RequireLogin(s.RateLimit(LimitAPI, s.RequireVerified(ActionReact, http.HandlerFunc(s.RemoveCommentReactionHandler))))))
+
+	// WebSocket handler
+	mux.HandleFunc("/ws", s.handleConnections)
+
+	return s.CSRFMiddleware(mux)
+}
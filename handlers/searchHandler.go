@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"forum/db"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+// SearchHandler handles full-text search over posts, comments and the
+// user's own private messages. Query parameters: q, type, category_id,
+// author, limit and offset, which is at most db.MaxSearchOffset.
+func (s *Server) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	q := db.SearchQuery{
+		Text:   query.Get("q"),
+		Kind:   query.Get("type"),
+		Author: query.Get("author"),
+		UserID: userID,
+		Limit:  defaultSearchLimit,
+	}
+	if q.Text == "" {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+	switch q.Kind {
+	case "", db.SearchPosts, db.SearchComments, db.SearchMessages:
+	default:
+		http.Error(w, "Invalid search type", http.StatusBadRequest)
+		return
+	}
+	if categoryStr := query.Get("category_id"); categoryStr != "" {
+		q.CategoryID, err = strconv.Atoi(categoryStr)
+		if err != nil {
+			http.Error(w, "Invalid category ID", http.StatusBadRequest)
+			return
+		}
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		q.Limit, err = strconv.Atoi(limitStr)
+		if err != nil || q.Limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if q.Limit > maxSearchLimit {
+			q.Limit = maxSearchLimit
+		}
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		q.Offset, err = strconv.Atoi(offsetStr)
+		if err != nil || q.Offset < 0 || q.Offset > db.MaxSearchOffset {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Ask for one extra result to know whether another page follows
+	limit := q.Limit
+	q.Limit++
+	results, err := s.store.Search(q)
+	if err == db.ErrEmptySearch {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error searching: %v", err)
+		http.Error(w, "Failed to search", http.StatusInternalServerError)
+		return
+	}
+
+	var response struct {
+		Results    []db.SearchResult `json:"results"`
+		NextOffset int               `json:"next_offset,omitempty"`
+	}
+	response.Results = results
+	if len(results) > limit {
+		response.Results = results[:limit]
+		if next := q.Offset + limit; next <= db.MaxSearchOffset {
+			response.NextOffset = next
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
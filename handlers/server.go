@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"forum/db"
+	"forum/mail"
+	"forum/oidc"
+)
+
+// Config holds the settings of a Server
+type Config struct {
+	// BaseURL is the public address of the forum, used in links sent by email
+	BaseURL string
+	// Mailer delivers account email such as password resets
+	Mailer mail.Mailer
+	// Unverified lists what accounts may do before verifying their email
+	Unverified UnverifiedPolicy
+	// Passwords is the policy for new passwords
+	Passwords PasswordPolicy
+	// OIDCProviders are the identity providers users can sign in with
+	OIDCProviders []oidc.ProviderConfig
+	// RateLimits are the rate limit policies
+	RateLimits RateLimits
+	// RateLimiter keeps the rate limit buckets, in memory by default
+	RateLimiter Limiter
+	// TrustedProxies are the reverse proxies whose forwarding header gives
+	// the client address; by default none is trusted
+	TrustedProxies TrustedProxies
+	// ProxyHeader is the header the trusted proxies write the client
+	// address to, X-Forwarded-For by default
+	ProxyHeader string
+}
+
+// Server holds the dependencies shared by the HTTP and websocket handlers
+type Server struct {
+	store    db.Store
+	sessions SessionStore
+	csrf     CSRFStore
+	hub      *Hub
+	config   Config
+	oidc     map[string]*oidc.Client
+}
+
+// NewServer creates a new Server backed by the given store
+func NewServer(store db.Store, config Config) *Server {
+	if config.Mailer == nil {
+		config.Mailer = mail.NewFileMailer("noreply@localhost", "")
+	}
+	if config.Passwords.MinLength == 0 {
+		config.Passwords.MinLength = DefaultPasswordPolicy().MinLength
+	}
+	if config.RateLimits.Policies == nil {
+		config.RateLimits = DefaultRateLimits()
+	}
+	if config.RateLimiter == nil {
+		config.RateLimiter = NewMemoryLimiter()
+	}
+	if config.ProxyHeader == "" {
+		config.ProxyHeader = HeaderXForwardedFor
+	}
+	return &Server{
+		store:    store,
+		sessions: NewDBSessionStore(store),
+		csrf:     NewDBCSRFStore(store),
+		hub:      NewHub(),
+		config:   config,
+		oidc:     newOIDCClients(config.BaseURL, config.OIDCProviders),
+	}
+}
+
+// Close stops the background work of the session and CSRF stores. The
+// store passed to NewServer is left open.
+func (s *Server) Close() error {
+	if err := s.sessions.Close(); err != nil {
+		return err
+	}
+	return s.csrf.Close()
+}
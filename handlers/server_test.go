@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/db"
+	"forum/mail"
+
+	"github.com/gofrs/uuid/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPassword is the password of users created by addUser
+const testPassword = "Correct-horse-9"
+
+// newTestStore opens a migrated SQLite store in a temporary directory
+func newTestStore(t testing.TB) *db.SQLStore {
+	t.Helper()
+	store, err := db.Open(filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// newTestServer creates a Server over a fresh store. Email is written to a
+// temporary file.
+func newTestServer(t testing.TB, config Config) (*Server, *db.SQLStore) {
+	t.Helper()
+	store := newTestStore(t)
+	if config.Mailer == nil {
+		config.Mailer = mail.NewFileMailer("noreply@localhost", filepath.Join(t.TempDir(), "mail.txt"))
+	}
+	srv := NewServer(store, config)
+	t.Cleanup(func() { srv.Close() })
+	return srv, store
+}
+
+// sentMail returns everything the test server's file mailer has written,
+// with CRLF line endings turned into LF
+func sentMail(t testing.TB, srv *Server) string {
+	t.Helper()
+	data, err := os.ReadFile(srv.config.Mailer.(*mail.FileMailer).Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n")
+}
+
+// addUser registers username with testPassword, optionally with a verified
+// email address
+func addUser(t testing.TB, store db.Store, username string, verified bool) uuid.UUID {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := store.RegisterUser(db.User{
+		Username:  username,
+		FirstName: "Test",
+		LastName:  "User",
+		Age:       30,
+		Gender:    "other",
+		Email:     username + "@example.com",
+		Password:  string(hash),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified {
+		token := "verify-" + username
+		if err := store.CreateEmailVerification(userID, hashSecretToken(token), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.VerifyEmail(hashSecretToken(token)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return userID
+}
+
+// callHandler POSTs the JSON body to handler as the holder of session,
+// bypassing the middleware. An empty session sends no cookie.
+func callHandler(handler http.HandlerFunc, session, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if session != "" {
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: session})
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// memoryCategories is an in-memory db.Store that only knows categories.
+// Handlers depend on the db.Store interface rather than a database, so a
+// fake like this can stand in for SQLStore; the embedded nil Store makes
+// any other method panic, which shows a handler reaching further than
+// expected.
+type memoryCategories struct {
+	db.Store
+	categories []db.Category
+	fail       bool
+}
+
+func (m *memoryCategories) CreateCategory(name string) error {
+	if m.fail {
+		return errors.New("store is down")
+	}
+	m.categories = append(m.categories, db.Category{ID: len(m.categories) + 1, Name: name})
+	return nil
+}
+
+func (m *memoryCategories) GetCategories() ([]db.Category, error) {
+	if m.fail {
+		return nil, errors.New("store is down")
+	}
+	return m.categories, nil
+}
+
+func (m *memoryCategories) GetCategoryByID(id int) (*db.Category, error) {
+	if id < 1 || id > len(m.categories) {
+		return nil, errors.New("no such category")
+	}
+	return &m.categories[id-1], nil
+}
+
+func TestServerWithFakeStore(t *testing.T) {
+	store := &memoryCategories{}
+	srv := NewServer(store, Config{})
+	t.Cleanup(func() { srv.Close() })
+	get := func(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	for _, name := range []string{"General", "Help"} {
+		if rec := callHandler(srv.CreateCategoryHandler, "", `{"name": "`+name+`"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("creating %s: status %d: %s", name, rec.Code, rec.Body)
+		}
+	}
+	if len(store.categories) != 2 {
+		t.Fatalf("fake store holds %+v", store.categories)
+	}
+	if rec := get(srv.GetCategoriesHandler, "/api/get-categories"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"Help"`) {
+		t.Fatalf("listing: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := get(srv.GetCategoryByIDHandler, "/api/get-category?id=1"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"General"`) {
+		t.Fatalf("category 1: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := get(srv.GetCategoryByIDHandler, "/api/get-category?id=3"); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown category: status %d", rec.Code)
+	}
+
+	// Store errors surface as server errors
+	store.fail = true
+	if rec := callHandler(srv.CreateCategoryHandler, "", `{"name": "Off-topic"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("creating with a failing store: status %d", rec.Code)
+	}
+	if rec := get(srv.GetCategoriesHandler, "/api/get-categories"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("listing with a failing store: status %d", rec.Code)
+	}
+}
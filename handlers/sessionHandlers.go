@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"forum/db"
+	"log"
+	"net/http"
+)
+
+// GetSessionsHandler lists the user's active sessions, marking the one
+// making the request
+func (s *Server) GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current, err := s.currentSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sessions, err := s.sessions.List(current.UserID)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		http.Error(w, "Failed to get sessions", http.StatusInternalServerError)
+		return
+	}
+	if sessions == nil {
+		sessions = []db.Session{}
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionToken == current.SessionToken
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSessionHandler ends one of the user's sessions and closes its
+// websocket connections
+func (s *Server) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	current, err := s.currentSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var requestData struct {
+		SessionID int `json:"session_id"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil || requestData.SessionID == 0 {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.sessions.Revoke(current.UserID, requestData.SessionID)
+	if err == ErrNoSession {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking session: %v", err)
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	s.hub.CloseSessions(token)
+	w.WriteHeader(http.StatusOK)
+}
+
+// RevokeOtherSessionsHandler logs the user out everywhere except the
+// session making the request
+func (s *Server) RevokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	current, err := s.currentSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	tokens, err := s.sessions.RevokeOthers(current.UserID, current.SessionToken)
+	if err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	s.hub.CloseSessions(tokens...)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"revoked": len(tokens)})
+}
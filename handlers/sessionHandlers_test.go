@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"forum/db"
+)
+
+// listSessions returns the sessions GetSessionsHandler shows the holder of
+// session
+func listSessions(t *testing.T, srv *Server, session string) []db.Session {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/sessions", nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: session})
+	rec := httptest.NewRecorder()
+	srv.GetSessionsHandler(rec, req)
+	var sessions []db.Session
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&sessions) != nil {
+		t.Fatalf("sessions: status %d: %s", rec.Code, rec.Body)
+	}
+	return sessions
+}
+
+func TestConcurrentLogins(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	addUser(t, store, "alice", true)
+
+	recs := make([]*httptest.ResponseRecorder, 2)
+	var wg sync.WaitGroup
+	for i := range recs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recs[i] = loginAs(srv, "alice", testPassword, "device "+strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+
+	tokens := map[string]bool{}
+	for i, rec := range recs {
+		cookie := sessionCookie(rec)
+		if rec.Code != http.StatusOK || cookie == nil {
+			t.Fatalf("login %d: status %d: %s", i, rec.Code, rec.Body)
+		}
+		tokens[cookie.Value] = true
+	}
+	if len(tokens) != 2 {
+		t.Fatal("both logins got the same session")
+	}
+	// Neither login ended the other's session
+	for token := range tokens {
+		if sessions := listSessions(t, srv, token); len(sessions) != 2 {
+			t.Fatalf("%d sessions listed, want 2", len(sessions))
+		}
+	}
+}
+
+func TestSessionMetadata(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	addUser(t, store, "alice", true)
+	before := time.Now().Add(-time.Second)
+
+	rec := loginAs(srv, "alice", testPassword, "Firefox on Linux")
+	laptop := sessionCookie(rec).Value
+	rec = loginAs(srv, "alice", testPassword, "Safari on iOS")
+	phone := sessionCookie(rec).Value
+
+	sessions := listSessions(t, srv, laptop)
+	if len(sessions) != 2 {
+		t.Fatalf("%d sessions listed, want 2", len(sessions))
+	}
+	agents := map[string]bool{}
+	for _, s := range sessions {
+		agents[s.UserAgent] = true
+		if s.IP != "192.0.2.1" || s.Username != "alice" || s.ID == 0 {
+			t.Errorf("session %+v", s)
+		}
+		if s.LastSeen.Before(before) || s.CreatedAt.Before(before) || !s.ExpireTime.After(time.Now()) {
+			t.Errorf("session times: created %v, last seen %v, expires %v", s.CreatedAt, s.LastSeen, s.ExpireTime)
+		}
+		if s.Current != (s.UserAgent == "Firefox on Linux") {
+			t.Errorf("session from %q current = %v", s.UserAgent, s.Current)
+		}
+	}
+	if !agents["Firefox on Linux"] || !agents["Safari on iOS"] {
+		t.Fatalf("user agents %v", agents)
+	}
+	// The token itself is never listed
+	req := httptest.NewRequest("GET", "/api/sessions", nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: phone})
+	rec = httptest.NewRecorder()
+	srv.GetSessionsHandler(rec, req)
+	var raw []map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range raw {
+		if _, ok := s["session_token"]; ok {
+			t.Fatal("session token listed")
+		}
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	addUser(t, store, "alice", true)
+	addUser(t, store, "bob", true)
+	alice, _ := newTestSession(t, srv, "alice")
+	aliceOther, _ := newTestSession(t, srv, "alice")
+	bob, _ := newTestSession(t, srv, "bob")
+	sessionID := func(token string) int {
+		t.Helper()
+		s, err := srv.sessions.Get(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.ID
+	}
+	revoke := func(session string, id int) int {
+		return callHandler(srv.RevokeSessionHandler, session, `{"session_id": `+strconv.Itoa(id)+`}`).Code
+	}
+
+	// Another user's session is reported as not found and stays alive
+	if code := revoke(alice, sessionID(bob)); code != http.StatusNotFound {
+		t.Fatalf("revoking bob's session: status %d", code)
+	}
+	if _, err := srv.sessions.Get(bob); err != nil {
+		t.Fatalf("bob's session: %v", err)
+	}
+	if code := revoke(alice, 0); code != http.StatusBadRequest {
+		t.Fatalf("no session ID: status %d", code)
+	}
+	if code := revoke(alice, sessionID(aliceOther)); code != http.StatusOK {
+		t.Fatalf("revoking own session: status %d", code)
+	}
+	if _, err := srv.sessions.Get(aliceOther); err != ErrNoSession {
+		t.Fatalf("revoked session: %v", err)
+	}
+	if code := revoke("", sessionID(bob)); code != http.StatusUnauthorized {
+		t.Fatalf("without a session: status %d", code)
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	addUser(t, store, "alice", true)
+	addUser(t, store, "bob", true)
+	current, _ := newTestSession(t, srv, "alice")
+	others := make([]string, 2)
+	for i := range others {
+		others[i], _ = newTestSession(t, srv, "alice")
+	}
+	bob, _ := newTestSession(t, srv, "bob")
+
+	rec := callHandler(srv.RevokeOtherSessionsHandler, current, "")
+	var body struct {
+		Revoked int `json:"revoked"`
+	}
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&body) != nil || body.Revoked != 2 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := srv.sessions.Get(current); err != nil {
+		t.Fatalf("current session: %v", err)
+	}
+	for _, token := range others {
+		if _, err := srv.sessions.Get(token); err != ErrNoSession {
+			t.Fatalf("other session: %v", err)
+		}
+	}
+	if _, err := srv.sessions.Get(bob); err != nil {
+		t.Fatalf("another user's session: %v", err)
+	}
+	if sessions := listSessions(t, srv, current); len(sessions) != 1 || !sessions[0].Current {
+		t.Fatalf("sessions left: %+v", sessions)
+	}
+}
+
+func TestRevokeClosesWebsocket(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	addUser(t, store, "alice", true)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	current, _ := newTestSession(t, srv, "alice")
+
+	revoked, csrf := newTestSession(t, srv, "alice")
+	conn := dialSession(t, ts, revoked, csrf)
+	kept := dialForum(t, ts, srv, "alice")
+	session, err := srv.sessions.Get(revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := callHandler(srv.RevokeSessionHandler, current, `{"session_id": `+strconv.Itoa(session.ID)+`}`).Code; code != http.StatusOK {
+		t.Fatalf("revoke: status %d", code)
+	}
+	expectClosed(t, conn)
+	// Other connections of the user stay open
+	if frame := exchange(t, kept, "{}"); frame.Type != "error" {
+		t.Fatalf("got %s frame on the kept connection", frame.Type)
+	}
+
+	callHandler(srv.RevokeOtherSessionsHandler, current, "")
+	expectClosed(t, kept)
+}
@@ -1,144 +1,132 @@
 package handlers
 
 import (
+	"context"
 	"forum/db"
 	"log"
 	"net/http"
-	"time"
 
 	"github.com/gofrs/uuid/v5"
 )
 
-var sessions = map[string]db.Session{}
-
-// NewSession creates a new session for the user
-func NewSession(w http.ResponseWriter, username string, userID uuid.UUID) (string, error) {
-	if isSessionUp(username) {
-		return "", nil
-	}
-	token, err := uuid.NewV4()
+// NewSession creates a new session for the user and sets the session cookie
+func (s *Server) NewSession(w http.ResponseWriter, r *http.Request, username string, userID uuid.UUID) (string, error) {
+	session, err := s.sessions.Create(userID, username, r.UserAgent(), s.clientIP(r))
 	if err != nil {
-		log.Printf("Failed to generate UUID: %v", err)
+		log.Printf("Failed to create session: %v", err)
 		return "", err
 	}
-	session := db.Session{
-		Username:     username,
-		SessionToken: token.String(),
-		ExpireTime:   time.Now().Add(100 * time.Minute),
-	}
-	sessions[token.String()] = session
-	expiration := time.Now().Add(4 * time.Hour)
-	cookie := http.Cookie{
+	setSessionCookie(w, session)
+	log.Printf("New session created for user %s", username)
+	return session.SessionToken, nil
+}
+
+// setSessionCookie sends the session cookie, expiring with the session
+func setSessionCookie(w http.ResponseWriter, session *db.Session) {
+	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
 		Value:    session.SessionToken,
-		Expires:  expiration,
+		Expires:  session.ExpireTime,
 		Path:     "/",
 		HttpOnly: true,
 		SameSite: http.SameSiteStrictMode,
 		Secure:   false, // Set to true in production with HTTPS
-	}
-	http.SetCookie(w, &cookie)
-	err = db.SaveSession(token.String(), userID, expiration)
-	if err != nil {
-		log.Printf("Failed to save session to database: %v", err)
-		return "", err
-	}
-	log.Printf("New session created for user %s with token %s", username, token.String())
-	return token.String(), nil
-}
-
-// isSessionUp checks if a session is active for the user
-func isSessionUp(username string) bool {
-	for _, a := range sessions {
-		if a.Username == username {
-			return true
-		}
-	}
-	return false
+	})
 }
 
-// ValidateSession validates the session from the request
-func ValidateSession(r *http.Request) string {
+// currentSession returns the live session of the request
+func (s *Server) currentSession(r *http.Request) (*db.Session, error) {
 	token, err := getSessionToken(r)
 	if err != nil {
-		log.Println("Session token not found:", err)
-		return ""
-	}
-	key, ok := sessions[token]
-	if ok {
-		return key.Username
-	} else {
-		log.Printf("Invalid session token: %s", token)
-		return ""
+		return nil, ErrNoSession
 	}
+	return s.sessions.Get(token)
 }
 
-// SessionExpired checks if the session has expired
-func SessionExpired(r *http.Request) bool {
-	token, err := getSessionToken(r)
+// ValidateSession returns the username of the request's session, or an
+// empty string when it has none
+func (s *Server) ValidateSession(r *http.Request) string {
+	session, err := s.currentSession(r)
 	if err != nil {
-		log.Println("Session token not found:", err)
-		return true
-	}
-	key, ok := sessions[token]
-	if ok {
-		return key.ExpireTime.Before(time.Now())
+		return ""
 	}
-	return true
+	return session.Username
 }
 
 // CloseSession closes the session and deletes the cookie
-func CloseSession(w http.ResponseWriter, r *http.Request) {
+func (s *Server) CloseSession(w http.ResponseWriter, r *http.Request) {
 	token, err := getSessionToken(r)
 	if err != nil {
 		http.Error(w, "No session token found", http.StatusBadRequest)
 		return
 	}
-	log.Printf("Attempting to close session: %s", token)
-	_, ok := sessions[token]
-	if ok {
-		delete(sessions, token)
-		cookie := http.Cookie{
-			Name:   "session_token",
-			Value:  "",
-			MaxAge: -1,
-			Path:   "/",
-		}
-		http.SetCookie(w, &cookie)
-		err = db.DeleteSession(token)
-		if err != nil {
-			log.Printf("Failed to delete session from database: %v", err)
-		}
+	if err := s.sessions.Delete(token); err != nil {
+		log.Printf("Failed to delete session from database: %v", err)
+	}
+	if err := s.csrf.Revoke(token); err != nil {
+		log.Printf("Failed to revoke CSRF tokens: %v", err)
 	}
+	s.hub.CloseSessions(token)
+	http.SetCookie(w, &http.Cookie{
+		Name:   "session_token",
+		Value:  "",
+		MaxAge: -1,
+		Path:   "/",
+	})
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Logout successful"))
 }
 
-// RequireLogin is a middleware that checks for a valid session
-func RequireLogin(next http.Handler) http.Handler {
+// RequireLogin is a middleware that checks for a valid session and slides
+// its expiry forward on activity. Personal API tokens sent as bearer tokens
+// are accepted instead on routes wrapped in AllowToken.
+func (s *Server) RequireLogin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if SessionExpired(r) {
+		if token := bearerAPIToken(r); token != "" {
+			apiToken := s.authenticateAPIToken(w, r, token)
+			if apiToken == nil {
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withUser(r.Context(), apiToken.UserID, apiToken.Role)))
+			return
+		}
+		session, err := s.currentSession(r)
+		if err == ErrNoSession {
 			http.Error(w, "Session expired", http.StatusUnauthorized)
 			return
 		}
-		username := ValidateSession(r)
-		if username == "" {
+		if err != nil {
+			log.Printf("Error loading session: %v", err)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		next.ServeHTTP(w, r)
+		renewed, err := s.sessions.Renew(session)
+		if err != nil {
+			log.Printf("Failed to renew session: %v", err)
+		} else if renewed {
+			setSessionCookie(w, session)
+		}
+		next.ServeHTTP(w, r.WithContext(withUser(r.Context(), session.UserID, session.Role)))
 	})
 }
 
-func getUserIDFromSession(r *http.Request) (uuid.UUID, error) {
-	cookie, err := r.Cookie("session_token")
+// withUser returns ctx carrying the authenticated user and their role
+func withUser(ctx context.Context, userID uuid.UUID, role string) context.Context {
+	ctx = context.WithValue(ctx, userIDKey, userID)
+	return context.WithValue(ctx, userRoleKey, role)
+}
+
+// getUserIDFromSession returns the user RequireLogin authenticated, by
+// session or API token, or else the user of the request's session
+func (s *Server) getUserIDFromSession(r *http.Request) (uuid.UUID, error) {
+	if userID, ok := r.Context().Value(userIDKey).(uuid.UUID); ok {
+		return userID, nil
+	}
+	session, err := s.currentSession(r)
 	if err != nil {
-		log.Println("No session cookie found:", err)
 		return uuid.Nil, err
 	}
-	sessionToken := cookie.Value
-	log.Println("Session token received:", sessionToken)
-	return db.GetUserIDFromSession(sessionToken)
+	return session.UserID, nil
 }
 
 // getSessionToken extracts the session token from the request
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"container/list"
+	"database/sql"
+	"errors"
+	"forum/db"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// SessionTTL is how long a session lives without activity
+	SessionTTL = 4 * time.Hour
+	// sessionRenewInterval limits how often activity extends a session, so
+	// busy clients do not write to the database on every request
+	sessionRenewInterval = 5 * time.Minute
+	// sessionCacheSize bounds the number of sessions kept in memory
+	sessionCacheSize = 1024
+	// sessionCacheTTL is how long a cached session is trusted before it is
+	// re-read, so sessions deleted by another instance expire from the cache
+	sessionCacheTTL = 30 * time.Second
+	// sessionSweepInterval is how often expired sessions are purged
+	sessionSweepInterval = 10 * time.Minute
+)
+
+// ErrNoSession is returned when a session token is unknown or expired
+var ErrNoSession = errors.New("session not found or expired")
+
+// SessionStore keeps track of login sessions
+type SessionStore interface {
+	// Create starts a new session for the user on the device described by
+	// userAgent and ip
+	Create(userID uuid.UUID, username, userAgent, ip string) (*db.Session, error)
+	// Get returns the live session for token or ErrNoSession
+	Get(token string) (*db.Session, error)
+	// Renew records activity on a session, sliding its expiry forward, and
+	// reports whether it changed
+	Renew(session *db.Session) (bool, error)
+	// List returns the user's active sessions
+	List(userID uuid.UUID) ([]db.Session, error)
+	// Delete ends a session
+	Delete(token string) error
+	// Revoke ends one of the user's sessions by ID and returns its token
+	Revoke(userID uuid.UUID, sessionID int) (string, error)
+	// RevokeOthers ends all of the user's sessions except keepToken and
+	// returns the revoked tokens
+	RevokeOthers(userID uuid.UUID, keepToken string) ([]string, error)
+	// DeleteExpired purges expired sessions
+	DeleteExpired() error
+	// Close stops the background sweeper
+	Close() error
+}
+
+// dbSessionStore is a SessionStore backed by the sessions table, which is
+// the source of truth, with a bounded LRU cache in front of it
+type dbSessionStore struct {
+	store     db.Store
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int
+}
+
+type sessionCacheEntry struct {
+	session  db.Session
+	cachedAt time.Time
+}
+
+// NewDBSessionStore creates a SessionStore backed by store and starts a
+// background sweeper that purges expired sessions until it is closed
+func NewDBSessionStore(store db.Store) SessionStore {
+	return newDBSessionStore(store, sessionSweepInterval)
+}
+
+// newDBSessionStore is NewDBSessionStore with the given sweep interval
+func newDBSessionStore(store db.Store, sweepInterval time.Duration) *dbSessionStore {
+	ss := &dbSessionStore{
+		store:   store,
+		done:    make(chan struct{}),
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		size:    sessionCacheSize,
+	}
+	go ss.sweep(sweepInterval)
+	return ss
+}
+
+func (ss *dbSessionStore) Create(userID uuid.UUID, username, userAgent, ip string) (*db.Session, error) {
+	token, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &db.Session{
+		UserID:       userID,
+		Username:     username,
+		SessionToken: token.String(),
+		UserAgent:    userAgent,
+		IP:           ip,
+		CreatedAt:    now,
+		LastSeen:     now,
+		ExpireTime:   now.Add(SessionTTL),
+	}
+	// The session is not cached until Get loads it with the user's role
+	if err := ss.store.SaveSession(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (ss *dbSessionStore) Get(token string) (*db.Session, error) {
+	if token == "" {
+		return nil, ErrNoSession
+	}
+	if session, ok := ss.cached(token); ok {
+		return &session, nil
+	}
+	session, err := ss.store.GetSession(token)
+	if err == sql.ErrNoRows {
+		ss.remove(token)
+		return nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	ss.put(*session)
+	return session, nil
+}
+
+func (ss *dbSessionStore) Renew(session *db.Session) (bool, error) {
+	now := time.Now()
+	if now.Sub(session.LastSeen) < sessionRenewInterval {
+		return false, nil
+	}
+	expiration := now.Add(SessionTTL)
+	if err := ss.store.TouchSession(session.SessionToken, now, expiration); err != nil {
+		return false, err
+	}
+	session.LastSeen = now
+	session.ExpireTime = expiration
+	ss.put(*session)
+	return true, nil
+}
+
+func (ss *dbSessionStore) List(userID uuid.UUID) ([]db.Session, error) {
+	return ss.store.GetUserSessions(userID)
+}
+
+func (ss *dbSessionStore) Delete(token string) error {
+	ss.remove(token)
+	return ss.store.DeleteSession(token)
+}
+
+func (ss *dbSessionStore) Revoke(userID uuid.UUID, sessionID int) (string, error) {
+	token, err := ss.store.DeleteUserSession(userID, sessionID)
+	if err == sql.ErrNoRows {
+		return "", ErrNoSession
+	}
+	if err != nil {
+		return "", err
+	}
+	ss.remove(token)
+	return token, nil
+}
+
+func (ss *dbSessionStore) RevokeOthers(userID uuid.UUID, keepToken string) ([]string, error) {
+	tokens, err := ss.store.DeleteOtherSessions(userID, keepToken)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		ss.remove(token)
+	}
+	return tokens, nil
+}
+
+func (ss *dbSessionStore) DeleteExpired() error {
+	now := time.Now()
+	ss.mu.Lock()
+	for token, el := range ss.entries {
+		if !el.Value.(*sessionCacheEntry).session.ExpireTime.After(now) {
+			ss.lru.Remove(el)
+			delete(ss.entries, token)
+		}
+	}
+	ss.mu.Unlock()
+	n, err := ss.store.DeleteExpiredSessions()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Removed %d expired sessions", n)
+	}
+	return nil
+}
+
+func (ss *dbSessionStore) Close() error {
+	ss.closeOnce.Do(func() { close(ss.done) })
+	return nil
+}
+
+// sweep periodically purges expired sessions until the store is closed
+func (ss *dbSessionStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ss.DeleteExpired(); err != nil {
+				log.Printf("Failed to remove expired sessions: %v", err)
+			}
+		case <-ss.done:
+			return
+		}
+	}
+}
+
+// cached returns a fresh, unexpired cached session
+func (ss *dbSessionStore) cached(token string) (db.Session, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	el, ok := ss.entries[token]
+	if !ok {
+		return db.Session{}, false
+	}
+	entry := el.Value.(*sessionCacheEntry)
+	now := time.Now()
+	if now.Sub(entry.cachedAt) > sessionCacheTTL || !entry.session.ExpireTime.After(now) {
+		ss.lru.Remove(el)
+		delete(ss.entries, token)
+		return db.Session{}, false
+	}
+	ss.lru.MoveToFront(el)
+	return entry.session, true
+}
+
+// put caches a session, evicting the least recently used one when full
+func (ss *dbSessionStore) put(session db.Session) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	entry := &sessionCacheEntry{session: session, cachedAt: time.Now()}
+	if el, ok := ss.entries[session.SessionToken]; ok {
+		el.Value = entry
+		ss.lru.MoveToFront(el)
+		return
+	}
+	ss.entries[session.SessionToken] = ss.lru.PushFront(entry)
+	if ss.lru.Len() > ss.size {
+		oldest := ss.lru.Back()
+		ss.lru.Remove(oldest)
+		delete(ss.entries, oldest.Value.(*sessionCacheEntry).session.SessionToken)
+	}
+}
+
+// remove drops a session from the cache
+func (ss *dbSessionStore) remove(token string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if el, ok := ss.entries[token]; ok {
+		ss.lru.Remove(el)
+		delete(ss.entries, token)
+	}
+}
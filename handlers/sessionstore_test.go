@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"forum/db"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// observedStore counts the session reads and writes that reach the
+// database and reports what each sweep deleted
+type observedStore struct {
+	db.Store
+	gets    atomic.Int32
+	touches atomic.Int32
+	swept   chan int64
+}
+
+func (s *observedStore) GetSession(token string) (*db.Session, error) {
+	s.gets.Add(1)
+	return s.Store.GetSession(token)
+}
+
+func (s *observedStore) TouchSession(token string, lastSeen, expires time.Time) error {
+	s.touches.Add(1)
+	return s.Store.TouchSession(token, lastSeen, expires)
+}
+
+func (s *observedStore) DeleteExpiredSessions() (int64, error) {
+	n, err := s.Store.DeleteExpiredSessions()
+	if s.swept != nil {
+		s.swept <- n
+	}
+	return n, err
+}
+
+// newObservedSessionStore returns a session store over a fresh database
+// with one user, whose ID it returns, and a sweeper that does not run
+// during the test
+func newObservedSessionStore(t *testing.T) (*dbSessionStore, *observedStore, uuid.UUID) {
+	t.Helper()
+	store := &observedStore{Store: newTestStore(t)}
+	userID := addUser(t, store, "alice", true)
+	ss := newDBSessionStore(store, time.Hour)
+	t.Cleanup(func() { ss.Close() })
+	return ss, store, userID
+}
+
+// expireCacheEntry makes the cached copy of the session older than
+// sessionCacheTTL
+func expireCacheEntry(ss *dbSessionStore, token string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if el, ok := ss.entries[token]; ok {
+		el.Value.(*sessionCacheEntry).cachedAt = time.Now().Add(-sessionCacheTTL - time.Second)
+	}
+}
+
+func TestSessionStoreCache(t *testing.T) {
+	ss, store, userID := newObservedSessionStore(t)
+	session, err := ss.Create(userID, "alice", "ua", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first Get loads the session with the user's role, later ones are
+	// served from the cache
+	for i := 0; i < 3; i++ {
+		got, err := ss.Get(session.SessionToken)
+		if err != nil || got.UserID != userID || got.Role != db.RoleUser {
+			t.Fatalf("Get %d = %+v, %v", i, got, err)
+		}
+	}
+	if n := store.gets.Load(); n != 1 {
+		t.Fatalf("%d database reads, want 1", n)
+	}
+
+	// An entry past the cache TTL is read again
+	expireCacheEntry(ss, session.SessionToken)
+	if _, err := ss.Get(session.SessionToken); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.gets.Load(); n != 2 {
+		t.Fatalf("%d database reads after the TTL, want 2", n)
+	}
+
+	if _, err := ss.Get(""); err != ErrNoSession {
+		t.Fatalf("empty token: %v", err)
+	}
+	if _, err := ss.Get("unknown"); err != ErrNoSession {
+		t.Fatalf("unknown token: %v", err)
+	}
+}
+
+func TestSessionStoreLRU(t *testing.T) {
+	ss, store, userID := newObservedSessionStore(t)
+	ss.size = 2
+	var tokens []string
+	for i := 0; i < 3; i++ {
+		session, err := ss.Create(userID, "alice", "ua", "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, session.SessionToken)
+	}
+	get := func(token string) {
+		t.Helper()
+		if _, err := ss.Get(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get(tokens[0])
+	get(tokens[1])
+	get(tokens[0]) // now the most recently used
+	get(tokens[2]) // evicts tokens[1]
+	if n := store.gets.Load(); n != 3 {
+		t.Fatalf("%d database reads, want 3", n)
+	}
+	if len(ss.entries) != 2 || ss.lru.Len() != 2 {
+		t.Fatalf("%d entries cached, want 2", len(ss.entries))
+	}
+	get(tokens[0])
+	if n := store.gets.Load(); n != 3 {
+		t.Fatal("recently used session was evicted")
+	}
+	get(tokens[1])
+	if n := store.gets.Load(); n != 4 {
+		t.Fatal("least recently used session was not evicted")
+	}
+}
+
+func TestSessionStoreRenew(t *testing.T) {
+	ss, store, userID := newObservedSessionStore(t)
+	session, err := ss.Create(userID, "alice", "ua", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed, err := ss.Renew(session); err != nil || renewed {
+		t.Fatalf("Renew of a fresh session = %v, %v", renewed, err)
+	}
+	session.LastSeen = time.Now().Add(-sessionRenewInterval + time.Second)
+	if renewed, err := ss.Renew(session); err != nil || renewed {
+		t.Fatalf("Renew within the interval = %v, %v", renewed, err)
+	}
+	if n := store.touches.Load(); n != 0 {
+		t.Fatalf("%d writes within the renewal interval", n)
+	}
+
+	stale := time.Now().Add(-sessionRenewInterval - time.Second)
+	session.LastSeen = stale
+	session.ExpireTime = stale.Add(SessionTTL)
+	renewed, err := ss.Renew(session)
+	if err != nil || !renewed || store.touches.Load() != 1 {
+		t.Fatalf("Renew after the interval = %v, %v", renewed, err)
+	}
+	if !session.LastSeen.After(stale) || time.Until(session.ExpireTime) < SessionTTL-time.Minute {
+		t.Fatalf("renewed session: last seen %v, expires %v", session.LastSeen, session.ExpireTime)
+	}
+	stored, err := store.Store.GetSession(session.SessionToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ExpireTime.Unix() != session.ExpireTime.Unix() {
+		t.Fatalf("stored expiry %v, want %v", stored.ExpireTime, session.ExpireTime)
+	}
+	// The cache holds the renewed session
+	if cached, err := ss.Get(session.SessionToken); err != nil || !cached.LastSeen.Equal(session.LastSeen) {
+		t.Fatalf("cached session: %+v, %v", cached, err)
+	}
+}
+
+func TestSessionStoreSweeper(t *testing.T) {
+	store := &observedStore{Store: newTestStore(t), swept: make(chan int64)}
+	userID := addUser(t, store, "alice", true)
+	past := time.Now().Add(-time.Hour)
+	expired := &db.Session{UserID: userID, SessionToken: "expired", CreatedAt: past, LastSeen: past, ExpireTime: past}
+	if err := store.SaveSession(expired); err != nil {
+		t.Fatal(err)
+	}
+	ss := newDBSessionStore(store, 10*time.Millisecond)
+	live, err := ss.Create(userID, "alice", "ua", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case n := <-store.swept:
+		if n != 1 {
+			t.Fatalf("first sweep deleted %d sessions, want 1", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the sweeper did not run")
+	}
+	if _, err := ss.Get(live.SessionToken); err != nil {
+		t.Fatalf("live session swept: %v", err)
+	}
+
+	// Once closed, the sweeper stops
+	ss.Close()
+	ss.Close()
+	select {
+	case <-store.swept:
+		// A sweep that was already due may finish
+	case <-time.After(50 * time.Millisecond):
+	}
+	select {
+	case <-store.swept:
+		t.Fatal("the sweeper kept running after Close")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSessionStoreSeesRevocation(t *testing.T) {
+	ss, _, userID := newObservedSessionStore(t)
+	// other is a second instance sharing the database
+	other := newDBSessionStore(ss.store, time.Hour)
+	t.Cleanup(func() { other.Close() })
+	session, err := ss.Create(userID, "alice", "ua", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.Get(session.SessionToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Revoke(userID, session.ID); err != nil {
+		t.Fatal(err)
+	}
+	// The revoking instance drops the session at once, the other once its
+	// cached copy is older than sessionCacheTTL
+	if _, err := other.Get(session.SessionToken); err != ErrNoSession {
+		t.Fatalf("revoking instance: %v", err)
+	}
+	if _, err := ss.Get(session.SessionToken); err != nil {
+		t.Fatalf("cached copy within the TTL: %v", err)
+	}
+	expireCacheEntry(ss, session.SessionToken)
+	if _, err := ss.Get(session.SessionToken); err != ErrNoSession {
+		t.Fatalf("after the cache TTL: %v", err)
+	}
+	if _, ok := ss.entries[session.SessionToken]; ok {
+		t.Fatal("revoked session still cached")
+	}
+
+	// Deleting and revoking through the same instance take effect at once
+	for _, end := range []func(s *db.Session) error{
+		func(s *db.Session) error { return ss.Delete(s.SessionToken) },
+		func(s *db.Session) error { _, err := ss.Revoke(userID, s.ID); return err },
+		func(s *db.Session) error { _, err := ss.RevokeOthers(userID, "keep"); return err },
+	} {
+		s, err := ss.Create(userID, "alice", "ua", "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ss.Get(s.SessionToken); err != nil {
+			t.Fatal(err)
+		}
+		if err := end(s); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ss.Get(s.SessionToken); err != ErrNoSession {
+			t.Fatalf("ended session: %v", err)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"forum/db"
+	"forum/totp"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// twoFactorIssuer names the forum in authenticator apps
+	twoFactorIssuer = "Forum"
+	// pendingLoginTTL is how long a user has to enter their code after a
+	// correct password
+	pendingLoginTTL = 5 * time.Minute
+	// pendingLoginAttempts is how many codes may be tried per pending login
+	pendingLoginAttempts = 5
+	// recoveryCodeCount is how many recovery codes are issued at a time
+	recoveryCodeCount = 10
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns a fresh set of one-time recovery codes along with
+// the hashes that are stored in their place
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashSecretToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode undoes the formatting of a recovery code
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code for an enabled enrollment. Each code is only accepted once.
+func (s *Server) checkSecondFactor(tf *db.TwoFactor, code string) (bool, error) {
+	if step, ok := totp.Validate(tf.Secret, code, time.Now()); ok {
+		return s.store.UseTOTPStep(tf.UserID, step)
+	}
+	normalized := normalizeRecoveryCode(code)
+	if len(normalized) != 10 {
+		return false, nil
+	}
+	return s.store.UseRecoveryCode(tf.UserID, hashSecretToken(normalized))
+}
+
+// enabledTwoFactor returns the user's confirmed enrollment, or nil if they
+// have not turned 2FA on
+func (s *Server) enabledTwoFactor(userID uuid.UUID) (*db.TwoFactor, error) {
+	tf, err := s.store.GetTwoFactor(userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tf.EnabledAt == nil {
+		return nil, nil
+	}
+	return tf, nil
+}
+
+// twoFactorSatisfied reports whether the user meets the 2FA policy of their
+// role. Privileged roles only take effect once it is met.
+func (s *Server) twoFactorSatisfied(user *db.User) bool {
+	required, err := s.store.RoleRequiresTwoFactor(user.Role)
+	if err != nil {
+		log.Printf("Error getting 2FA policy: %v", err)
+		return false
+	}
+	if !required {
+		return true
+	}
+	tf, err := s.enabledTwoFactor(user.Id)
+	if err != nil {
+		log.Printf("Error getting 2FA enrollment: %v", err)
+		return false
+	}
+	return tf != nil
+}
+
+// newPendingLogin issues the short-lived token that LoginTwoFactorHandler
+// exchanges for a session once the second factor is given
+func (s *Server) newPendingLogin(userID uuid.UUID) (string, error) {
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+	if err := s.store.CreatePendingLogin(userID, hash, time.Now().Add(pendingLoginTTL)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// startPendingLogin answers a login whose second factor is still missing
+// with a pending login token
+func (s *Server) startPendingLogin(w http.ResponseWriter, userID uuid.UUID) {
+	token, err := s.newPendingLogin(userID)
+	if err != nil {
+		log.Printf("Error creating pending login: %v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"two_factor_required": true,
+		"token":               token,
+	})
+}
+
+// LoginTwoFactorHandler completes a login started by LoginProcess with a
+// TOTP or recovery code
+func (s *Server) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var requestData struct {
+		Token string `json:"token"`
+		Code  string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil || requestData.Token == "" || requestData.Code == "" {
+		http.Error(w, "Token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	hash := hashSecretToken(requestData.Token)
+	userID, err := s.store.AttemptPendingLogin(hash, pendingLoginAttempts)
+	if err == db.ErrInvalidToken {
+		http.Error(w, "Login expired, please sign in again", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading pending login: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if !s.checkAccountLock(w, r, userID) {
+		return
+	}
+	tf, err := s.enabledTwoFactor(userID)
+	if err != nil || tf == nil {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	ok, err := s.checkSecondFactor(tf, requestData.Code)
+	if err != nil {
+		log.Printf("Error checking 2FA code: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		s.recordLoginFailure(r, userID, loginReasonCode)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	if err := s.store.DeletePendingLogin(hash); err != nil {
+		log.Printf("Error deleting pending login: %v", err)
+	}
+
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	if err := s.completeLogin(w, r, userID, user.Username); err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Login successful"))
+}
+
+// TwoFactorStatusHandler reports the user's 2FA state and whether their role
+// requires it
+func (s *Server) TwoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	required, err := s.store.RoleRequiresTwoFactor(user.Role)
+	if err != nil {
+		http.Error(w, "Failed to get 2FA policy", http.StatusInternalServerError)
+		return
+	}
+	status := struct {
+		Enabled           bool `json:"enabled"`
+		Required          bool `json:"required"`
+		RecoveryCodesLeft int  `json:"recovery_codes_left"`
+	}{Required: required}
+	tf, err := s.enabledTwoFactor(userID)
+	if err != nil {
+		http.Error(w, "Failed to get 2FA status", http.StatusInternalServerError)
+		return
+	}
+	if tf != nil {
+		status.Enabled = true
+		status.RecoveryCodesLeft, err = s.store.CountRecoveryCodes(userID)
+		if err != nil {
+			http.Error(w, "Failed to get 2FA status", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// SetupTwoFactorHandler starts 2FA enrollment by generating a secret. The
+// otpauth URI can be shown as a QR code for authenticator apps; 2FA is only
+// turned on once ConfirmTwoFactorHandler accepts a code.
+func (s *Server) SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	tf, err := s.enabledTwoFactor(userID)
+	if err != nil {
+		http.Error(w, "Failed to get 2FA status", http.StatusInternalServerError)
+		return
+	}
+	if tf != nil {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	if err := s.store.SetTwoFactorSecret(userID, secret); err != nil {
+		log.Printf("Error saving 2FA secret: %v", err)
+		http.Error(w, "Failed to start 2FA setup", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(twoFactorIssuer, user.Username, secret),
+	})
+}
+
+// ConfirmTwoFactorHandler turns 2FA on with a code from the authenticator
+// app and responds with the user's recovery codes, which are only shown once
+func (s *Server) ConfirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var requestData struct {
+		Code string `json:"code"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil || requestData.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	tf, err := s.store.GetTwoFactor(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Two-factor setup has not been started", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get 2FA status", http.StatusInternalServerError)
+		return
+	}
+	if tf.EnabledAt != nil {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	step, ok := totp.Validate(tf.Secret, requestData.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	err = s.store.EnableTwoFactor(userID, step, hashes)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error enabling 2FA: %v", err)
+		http.Error(w, "Failed to enable 2FA", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTwoFactorHandler turns 2FA off after checking a current code.
+// Users whose role requires 2FA cannot turn it off.
+func (s *Server) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	tf, user, ok := s.verifySecondFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	required, err := s.store.RoleRequiresTwoFactor(user.Role)
+	if err != nil {
+		http.Error(w, "Failed to get 2FA policy", http.StatusInternalServerError)
+		return
+	}
+	if required {
+		http.Error(w, "Two-factor authentication is required for your role", http.StatusForbidden)
+		return
+	}
+	if err := s.store.DisableTwoFactor(tf.UserID); err != nil {
+		log.Printf("Error disabling 2FA: %v", err)
+		http.Error(w, "Failed to disable 2FA", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// RegenerateRecoveryCodesHandler replaces the user's recovery codes after
+// checking a current code
+func (s *Server) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	tf, _, ok := s.verifySecondFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	if err := s.store.ReplaceRecoveryCodes(tf.UserID, hashes); err != nil {
+		log.Printf("Error replacing recovery codes: %v", err)
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// verifySecondFactorRequest checks the {code} body of a POST that changes
+// an enabled 2FA enrollment. It writes the error response and returns false
+// when the request should not go ahead.
+func (s *Server) verifySecondFactorRequest(w http.ResponseWriter, r *http.Request) (*db.TwoFactor, *db.User, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	var requestData struct {
+		Code string `json:"code"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil || requestData.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	tf, err := s.enabledTwoFactor(userID)
+	if err != nil {
+		http.Error(w, "Failed to get 2FA status", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if tf == nil {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	ok, err := s.checkSecondFactor(tf, requestData.Code)
+	if err != nil {
+		log.Printf("Error checking 2FA code: %v", err)
+		http.Error(w, "Failed to check code", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	return tf, user, true
+}
+
+// SetTwoFactorPolicyHandler lets admins make 2FA mandatory for a privileged
+// role. Until a user with that role enables 2FA, the role grants them no
+// extra permissions.
+func (s *Server) SetTwoFactorPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.isAdmin(userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var requestData struct {
+		Role     string `json:"role"`
+		Required bool   `json:"required"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+		return
+	}
+	if requestData.Role != db.RoleModerator && requestData.Role != db.RoleAdmin {
+		http.Error(w, "Role must be moderator or admin", http.StatusBadRequest)
+		return
+	}
+	if err := s.store.SetRoleRequiresTwoFactor(requestData.Role, requestData.Required); err != nil {
+		log.Printf("Error setting 2FA policy: %v", err)
+		http.Error(w, "Failed to set 2FA policy", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
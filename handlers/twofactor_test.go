@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/db"
+	"forum/totp"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// enrollTwoFactor turns 2FA on for the user and returns the secret and the
+// recovery codes
+func enrollTwoFactor(t *testing.T, store db.Store, userID uuid.UUID) (string, []string) {
+	t.Helper()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTwoFactorSecret(userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The enrollment step lies before the skew window, so every code that
+	// is currently valid is still unused
+	if err := store.EnableTwoFactor(userID, totp.Step(time.Now())-totp.Skew-1, hashes); err != nil {
+		t.Fatal(err)
+	}
+	return secret, codes
+}
+
+// currentCode returns the TOTP code for secret right now
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// pendingLogin logs in with the password and returns the pending login
+// token
+func pendingLogin(t *testing.T, srv *Server, username string) string {
+	t.Helper()
+	rec := loginAs(srv, username, testPassword, "ua")
+	var body struct {
+		Required bool   `json:"two_factor_required"`
+		Token    string `json:"token"`
+	}
+	if rec.Code != http.StatusAccepted || json.NewDecoder(rec.Body).Decode(&body) != nil || !body.Required || body.Token == "" {
+		t.Fatalf("password login: status %d", rec.Code)
+	}
+	return body.Token
+}
+
+// secondFactor completes a pending login with code
+func secondFactor(srv *Server, token, code string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"token": token, "code": code})
+	return callHandler(srv.LoginTwoFactorHandler, "", string(body))
+}
+
+func TestTwoFactorLoginRejectsReplay(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	userID := addUser(t, store, "alice", true)
+	secret, _ := enrollTwoFactor(t, store, userID)
+	step := totp.Step(time.Now())
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := secondFactor(srv, pendingLogin(t, srv, "alice"), code); rec.Code != http.StatusOK || sessionCookie(rec) == nil {
+		t.Fatalf("first use: status %d: %s", rec.Code, rec.Body)
+	}
+	// The same code is refused for a new login within its validity
+	if rec := secondFactor(srv, pendingLogin(t, srv, "alice"), code); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed code: status %d", rec.Code)
+	}
+	// and so is the code of an earlier step
+	earlier, err := totp.Code(secret, step-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := secondFactor(srv, pendingLogin(t, srv, "alice"), earlier); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("code of an earlier step: status %d", rec.Code)
+	}
+	if ok, err := store.UseTOTPStep(userID, step); err != nil || ok {
+		t.Fatalf("UseTOTPStep of the used step = %v, %v", ok, err)
+	}
+	if ok, err := store.UseTOTPStep(userID, step+1); err != nil || !ok {
+		t.Fatalf("UseTOTPStep of the next step = %v, %v", ok, err)
+	}
+}
+
+func TestTwoFactorRecoveryCodes(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	userID := addUser(t, store, "alice", true)
+	_, codes := enrollTwoFactor(t, store, userID)
+
+	// Codes are accepted in any case, with or without the dash and spaces
+	for i, code := range []string{
+		codes[0],
+		strings.ToUpper(codes[1]),
+		strings.ReplaceAll(codes[2], "-", ""),
+		" " + strings.ReplaceAll(codes[3], "-", " ") + " ",
+	} {
+		if rec := secondFactor(srv, pendingLogin(t, srv, "alice"), code); rec.Code != http.StatusOK {
+			t.Fatalf("recovery code %d (%q): status %d: %s", i, code, rec.Code, rec.Body)
+		}
+	}
+	// Each code only works once, in any form
+	if rec := secondFactor(srv, pendingLogin(t, srv, "alice"), strings.ToUpper(codes[0])); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("reused recovery code: status %d", rec.Code)
+	}
+	if n, err := store.CountRecoveryCodes(userID); err != nil || n != recoveryCodeCount-4 {
+		t.Fatalf("%d recovery codes left, %v", n, err)
+	}
+}
+
+func TestTwoFactorPendingLogin(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	userID := addUser(t, store, "alice", true)
+	secret, _ := enrollTwoFactor(t, store, userID)
+
+	t.Run("expired", func(t *testing.T) {
+		if err := store.CreatePendingLogin(userID, hashSecretToken("expired"), time.Now().Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		rec := secondFactor(srv, "expired", currentCode(t, secret))
+		if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "expired") {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("attempts", func(t *testing.T) {
+		token := pendingLogin(t, srv, "alice")
+		for i := 0; i < pendingLoginAttempts; i++ {
+			rec := secondFactor(srv, token, "000000")
+			if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "Invalid code") {
+				t.Fatalf("attempt %d: status %d: %s", i, rec.Code, rec.Body)
+			}
+		}
+		// The token is spent, so even the right code is refused
+		rec := secondFactor(srv, token, currentCode(t, secret))
+		if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "expired") {
+			t.Fatalf("after %d attempts: status %d: %s", pendingLoginAttempts, rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		if rec := secondFactor(srv, "forged", currentCode(t, secret)); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status %d", rec.Code)
+		}
+	})
+}
+
+func TestDisableTwoFactorRequiredByRole(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	modID := addUser(t, store, "mod", true)
+	userID := addUser(t, store, "alice", true)
+	if err := store.SetUserRole("mod", db.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetRoleRequiresTwoFactor(db.RoleModerator, true); err != nil {
+		t.Fatal(err)
+	}
+	modSecret, _ := enrollTwoFactor(t, store, modID)
+	userSecret, _ := enrollTwoFactor(t, store, userID)
+
+	disable := func(username, code string) int {
+		session, _ := newTestSession(t, srv, username)
+		return callHandler(srv.DisableTwoFactorHandler, session, `{"code": "`+code+`"}`).Code
+	}
+	if code := disable("mod", currentCode(t, modSecret)); code != http.StatusForbidden {
+		t.Fatalf("moderator: status %d", code)
+	}
+	if tf, err := srv.enabledTwoFactor(modID); err != nil || tf == nil {
+		t.Fatalf("moderator's 2FA turned off: %v", err)
+	}
+	if code := disable("alice", "000000"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong code: status %d", code)
+	}
+	if code := disable("alice", currentCode(t, userSecret)); code != http.StatusOK {
+		t.Fatalf("user: status %d", code)
+	}
+	if tf, err := srv.enabledTwoFactor(userID); err != nil || tf != nil {
+		t.Fatalf("user's 2FA still on: %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	netmail "net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FieldErrors maps form field names to what is wrong with their value
+type FieldErrors map[string]string
+
+// add records the first error for field
+func (e FieldErrors) add(field, message string) {
+	if _, ok := e[field]; !ok {
+		e[field] = message
+	}
+}
+
+// writeFieldErrors responds with {"errors": {field: message}} so forms can
+// show each message next to its input
+func writeFieldErrors(w http.ResponseWriter, errs FieldErrors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]FieldErrors{"errors": errs})
+}
+
+// PasswordPolicy describes what passwords are accepted
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters
+	MinLength int
+	// RequireMixed requires at least one letter and one digit
+	RequireMixed bool
+	// Breached holds known breached passwords, lower-cased
+	Breached map[string]bool
+}
+
+// maxPasswordBytes is the longest password bcrypt can hash without
+// silently ignoring the rest
+const maxPasswordBytes = 72
+
+// DefaultPasswordPolicy is used when the config does not set one
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinLength: 8}
+}
+
+// LoadBreachedPasswords reads a breached password list with one password
+// per line. Blank lines and lines starting with # are ignored.
+func LoadBreachedPasswords(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	breached := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breached[strings.ToLower(line)] = true
+	}
+	return breached, scanner.Err()
+}
+
+// Check returns why password is not acceptable for the account, or "" if
+// it is
+func (p PasswordPolicy) Check(password, username, email string) string {
+	if strings.TrimSpace(password) == "" {
+		return "Password is required"
+	}
+	if len([]rune(password)) < p.MinLength {
+		return "Password must be at least " + strconv.Itoa(p.MinLength) + " characters"
+	}
+	if len(password) > maxPasswordBytes {
+		return "Password must be at most " + strconv.Itoa(maxPasswordBytes) + " bytes"
+	}
+	if p.RequireMixed {
+		var letter, digit bool
+		for _, r := range password {
+			letter = letter || unicode.IsLetter(r)
+			digit = digit || unicode.IsDigit(r)
+		}
+		if !letter || !digit {
+			return "Password must contain both letters and digits"
+		}
+	}
+	lower := strings.ToLower(password)
+	if (username != "" && lower == strings.ToLower(username)) || (email != "" && lower == strings.ToLower(email)) {
+		return "Password must not match your username or email"
+	}
+	if p.Breached[lower] {
+		return "This password has appeared in a data breach, please choose another"
+	}
+	return ""
+}
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 20
+	maxNameLength     = 50
+	minAge            = 18
+	maxAge            = 120
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// reservedUsernames cannot be registered since they could be mistaken for
+// staff or clash with routes. Compared case-insensitively.
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "moderator": true, "mod": true,
+	"root": true, "system": true, "support": true, "staff": true,
+	"forum": true, "api": true, "static": true, "registration": true,
+	"homepage": true, "logout": true, "null": true, "undefined": true,
+	"anonymous": true, "deleted": true,
+}
+
+var genders = map[string]bool{"male": true, "female": true, "other": true}
+
+// signupForm holds the fields of the registration form
+type signupForm struct {
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	Age       int
+	Gender    string
+	Password  string
+}
+
+// parseSignupForm reads and validates the registration form. Field errors
+// are keyed by the form's input names.
+func parseSignupForm(r *http.Request, policy PasswordPolicy) (signupForm, FieldErrors) {
+	form := signupForm{
+		Username:  strings.TrimSpace(r.FormValue("signupUsername")),
+		Email:     strings.TrimSpace(r.FormValue("email")),
+		FirstName: strings.TrimSpace(r.FormValue("firstname")),
+		LastName:  strings.TrimSpace(r.FormValue("lastname")),
+		Gender:    strings.ToLower(strings.TrimSpace(r.FormValue("gender"))),
+		Password:  r.FormValue("signupPassword"),
+	}
+	errs := FieldErrors{}
+
+	switch {
+	case form.Username == "":
+		errs.add("signupUsername", "Username is required")
+	case len(form.Username) < minUsernameLength || len(form.Username) > maxUsernameLength:
+		errs.add("signupUsername", "Username must be "+strconv.Itoa(minUsernameLength)+" to "+strconv.Itoa(maxUsernameLength)+" characters")
+	case !usernamePattern.MatchString(form.Username):
+		errs.add("signupUsername", "Username may only contain letters, digits, '_', '.' and '-' and must start with a letter or digit")
+	case reservedUsernames[strings.ToLower(form.Username)]:
+		errs.add("signupUsername", "This username is reserved")
+	}
+
+	if form.Email == "" {
+		errs.add("email", "Email is required")
+	} else if addr, err := netmail.ParseAddress(form.Email); err != nil || addr.Address != form.Email || !strings.Contains(form.Email[strings.LastIndex(form.Email, "@")+1:], ".") {
+		errs.add("email", "Email address is not valid")
+	}
+
+	for field, value := range map[string]string{"firstname": form.FirstName, "lastname": form.LastName} {
+		if value == "" {
+			errs.add(field, "Name is required")
+		} else if len([]rune(value)) > maxNameLength {
+			errs.add(field, "Name must be at most "+strconv.Itoa(maxNameLength)+" characters")
+		}
+	}
+
+	age, err := strconv.Atoi(strings.TrimSpace(r.FormValue("age")))
+	if err != nil {
+		errs.add("age", "Age must be a number")
+	} else if age < minAge {
+		errs.add("age", "You must be at least "+strconv.Itoa(minAge)+" years old")
+	} else if age > maxAge {
+		errs.add("age", "Age is not valid")
+	}
+	form.Age = age
+
+	if !genders[form.Gender] {
+		errs.add("gender", "Please choose male, female or other")
+	}
+
+	if msg := policy.Check(form.Password, form.Username, form.Email); msg != "" {
+		errs.add("signupPassword", msg)
+	}
+	return form, errs
+}
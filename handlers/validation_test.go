@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"forum/db"
+)
+
+// signupValues is a valid registration form
+func signupValues() url.Values {
+	return url.Values{
+		"signupUsername": {"alice"},
+		"email":          {"alice@example.com"},
+		"firstname":      {"Alice"},
+		"lastname":       {"Liddell"},
+		"age":            {"30"},
+		"gender":         {"female"},
+		"signupPassword": {testPassword},
+	}
+}
+
+func signupRequest(values url.Values) *http.Request {
+	req := httptest.NewRequest("POST", "/registration", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestParseSignupForm(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, Breached: map[string]bool{"password123": true}}
+	tests := []struct {
+		name   string
+		field  string
+		value  string
+		errors string // the field with an error, or "" when the form is valid
+	}{
+		{"valid", "", "", ""},
+		{"username with dot, dash and underscore", "signupUsername", "a.l-i_ce", ""},
+		{"username of 3 characters", "signupUsername", "ali", ""},
+		{"username of 20 characters", "signupUsername", strings.Repeat("a", 20), ""},
+		{"missing username", "signupUsername", "  ", "signupUsername"},
+		{"short username", "signupUsername", "al", "signupUsername"},
+		{"long username", "signupUsername", strings.Repeat("a", 21), "signupUsername"},
+		{"username with a space", "signupUsername", "al ice", "signupUsername"},
+		{"username with a symbol", "signupUsername", "alice!", "signupUsername"},
+		{"username starting with underscore", "signupUsername", "_alice", "signupUsername"},
+		{"username with non-ASCII letters", "signupUsername", "alicé", "signupUsername"},
+		{"reserved username", "signupUsername", "admin", "signupUsername"},
+		{"reserved username in capitals", "signupUsername", "Moderator", "signupUsername"},
+		{"missing email", "email", "", "email"},
+		{"email without @", "email", "alice.example.com", "email"},
+		{"email without a dot in the domain", "email", "alice@localhost", "email"},
+		{"email with a display name", "email", "Alice <alice@example.com>", "email"},
+		{"missing first name", "firstname", "", "firstname"},
+		{"long last name", "lastname", strings.Repeat("n", 51), "lastname"},
+		{"age 18", "age", "18", ""},
+		{"age 120", "age", "120", ""},
+		{"age 17", "age", "17", "age"},
+		{"age 121", "age", "121", "age"},
+		{"age not a number", "age", "thirty", "age"},
+		{"negative age", "age", "-1", "age"},
+		{"gender in capitals", "gender", "Other", ""},
+		{"unknown gender", "gender", "unknown", "gender"},
+		{"missing gender", "gender", "", "gender"},
+		{"short password", "signupPassword", "short1", "signupPassword"},
+		{"breached password", "signupPassword", "PASSWORD123", "signupPassword"},
+		{"password equal to the email", "signupPassword", "ALICE@example.com", "signupPassword"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := signupValues()
+			if tt.field != "" {
+				values.Set(tt.field, tt.value)
+			}
+			_, errs := parseSignupForm(signupRequest(values), policy)
+			if tt.errors == "" {
+				if len(errs) != 0 {
+					t.Fatalf("errors %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[tt.errors] == "" {
+				t.Fatalf("errors %v, want one for %s", errs, tt.errors)
+			}
+		})
+	}
+
+	form, errs := parseSignupForm(signupRequest(url.Values{
+		"signupUsername": {" bob "},
+		"email":          {" bob@example.com "},
+		"firstname":      {" Bob "},
+		"lastname":       {"Builder"},
+		"age":            {" 42 "},
+		"gender":         {"MALE"},
+		"signupPassword": {" spaced password "},
+	}), policy)
+	if len(errs) != 0 {
+		t.Fatalf("errors %v", errs)
+	}
+	want := signupForm{"bob", "bob@example.com", "Bob", "Builder", 42, "male", " spaced password "}
+	if form != want {
+		t.Fatalf("form = %+v, want %+v", form, want)
+	}
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 10, RequireMixed: true, Breached: map[string]bool{"letmein2024": true}}
+	tests := []struct {
+		password string
+		ok       bool
+	}{
+		{"horse-battery-9", true},
+		{"   ", false},
+		{"short-1", false},
+		{"ääääääääää1", true}, // length counts characters, not bytes
+		{strings.Repeat("a", maxPasswordBytes-1) + "1", true},
+		{strings.Repeat("a", maxPasswordBytes) + "1", false},
+		{strings.Repeat("ä", 36) + "1", false}, // 73 bytes
+		{"onlyletters", false},
+		{"1234567890", false},
+		{"LetMeIn2024", false},
+		{"Alice12345", false},             // the username
+		{"ALICE12345@EXAMPLE.COM", false}, // the email
+		{"alice12345@example.com.au", true},
+	}
+	for _, tt := range tests {
+		msg := policy.Check(tt.password, "alice12345", "alice12345@example.com")
+		if (msg == "") != tt.ok {
+			t.Errorf("Check(%q) = %q, want ok %v", tt.password, msg, tt.ok)
+		}
+	}
+	if msg := (PasswordPolicy{MinLength: 8}).Check("12345678", "", ""); msg != "" {
+		t.Errorf("digits refused without RequireMixed: %s", msg)
+	}
+}
+
+func TestLoadBreachedPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte("# top passwords\nPassword1\n\n  qwerty123  \n#comment\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	breached, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(breached) != 2 || !breached["password1"] || !breached["qwerty123"] {
+		t.Fatalf("breached = %v", breached)
+	}
+	policy := PasswordPolicy{MinLength: 8, Breached: breached}
+	if msg := policy.Check("PASSWORD1", "", ""); !strings.Contains(msg, "breach") {
+		t.Fatalf("breached password: %q", msg)
+	}
+	if _, err := LoadBreachedPasswords(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Fatal("missing file accepted")
+	}
+}
+
+// signup posts the registration form to SignupProcess and returns the
+// status and field errors
+func signup(t *testing.T, srv *Server, values url.Values) (int, FieldErrors) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	srv.SignupProcess(rec, signupRequest(values))
+	if rec.Code != http.StatusBadRequest {
+		return rec.Code, nil
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("error response of type %q", ct)
+	}
+	var body struct {
+		Errors FieldErrors `json:"errors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, body.Errors
+}
+
+func TestSignupFieldErrors(t *testing.T) {
+	srv, _ := newTestServer(t, Config{})
+	values := url.Values{"signupUsername": {"x"}, "email": {"nope"}, "age": {"12"}, "signupPassword": {"pw"}}
+	code, errs := signup(t, srv, values)
+	if code != http.StatusBadRequest {
+		t.Fatalf("status %d", code)
+	}
+	for _, field := range []string{"signupUsername", "email", "firstname", "lastname", "age", "gender", "signupPassword"} {
+		if errs[field] == "" {
+			t.Errorf("no error for %s in %v", field, errs)
+		}
+	}
+	if len(errs) != 7 {
+		t.Errorf("errors %v", errs)
+	}
+}
+
+func TestSignupDuplicates(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	if code, errs := signup(t, srv, signupValues()); code != http.StatusOK {
+		t.Fatalf("first signup: status %d, %v", code, errs)
+	}
+
+	tests := []struct {
+		name, username, email string
+		fields                []string
+	}{
+		{"same username in capitals", "ALICE", "other@example.com", []string{"signupUsername"}},
+		{"same email in capitals", "other", "Alice@Example.COM", []string{"email"}},
+		{"both", "Alice", "ALICE@example.com", []string{"email", "signupUsername"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := signupValues()
+			values.Set("signupUsername", tt.username)
+			values.Set("email", tt.email)
+			code, errs := signup(t, srv, values)
+			if code != http.StatusBadRequest || len(errs) != len(tt.fields) {
+				t.Fatalf("status %d, errors %v", code, errs)
+			}
+			for _, field := range tt.fields {
+				if !strings.Contains(errs[field], "already") {
+					t.Errorf("%s: %q", field, errs[field])
+				}
+			}
+		})
+	}
+
+	// The unique indexes catch duplicates the availability check missed
+	user := db.User{Username: "ALICE", Email: "new@example.com", FirstName: "A", LastName: "B", Age: 30, Gender: "female", Password: "x"}
+	if _, err := store.RegisterUser(user); err != db.ErrUsernameTaken {
+		t.Fatalf("RegisterUser with the username in capitals: %v", err)
+	}
+	user.Username, user.Email = "new", "ALICE@EXAMPLE.COM"
+	if _, err := store.RegisterUser(user); err != db.ErrEmailTaken {
+		t.Fatalf("RegisterUser with the email in capitals: %v", err)
+	}
+}
+
+// racedStore reports every name and email as available, as when another
+// signup wins the race between the check and the insert
+type racedStore struct {
+	db.Store
+}
+
+func (racedStore) CheckUserAvailability(username, email string) (bool, bool, error) {
+	return false, false, nil
+}
+
+func TestSignupLosesRace(t *testing.T) {
+	store := newTestStore(t)
+	addUser(t, store, "alice", true)
+	srv := NewServer(racedStore{store}, Config{})
+	t.Cleanup(func() { srv.Close() })
+
+	values := signupValues()
+	values.Set("signupUsername", "Alice")
+	values.Set("email", "other@example.com")
+	if code, errs := signup(t, srv, values); code != http.StatusBadRequest || errs["signupUsername"] == "" {
+		t.Fatalf("duplicate username: status %d, errors %v", code, errs)
+	}
+	values = signupValues()
+	values.Set("signupUsername", "other")
+	values.Set("email", "ALICE@example.com")
+	if code, errs := signup(t, srv, values); code != http.StatusBadRequest || errs["email"] == "" {
+		t.Fatalf("duplicate email: status %d, errors %v", code, errs)
+	}
+	if _, err := store.GetUserIDByUsernameOrEmail("other"); err == nil {
+		t.Fatal("duplicate account created")
+	}
+}
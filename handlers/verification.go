@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"forum/db"
+	"forum/mail"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// emailVerificationTTL is how long an email verification link stays valid
+const emailVerificationTTL = 48 * time.Hour
+
+// Actions that the unverified account policy can allow or deny
+const (
+	ActionPost    = "post"
+	ActionComment = "comment"
+	ActionReact   = "react"
+	ActionMessage = "message"
+)
+
+var policyActions = []string{ActionPost, ActionComment, ActionReact, ActionMessage}
+
+// UnverifiedPolicy is the set of actions accounts may perform before their
+// email address is verified. Reading is always allowed.
+type UnverifiedPolicy map[string]bool
+
+// ParseUnverifiedPolicy parses a policy from a comma separated list of
+// actions. "all" allows everything, and "" or "read-only" allows nothing.
+func ParseUnverifiedPolicy(value string) (UnverifiedPolicy, error) {
+	policy := UnverifiedPolicy{}
+	value = strings.TrimSpace(value)
+	switch value {
+	case "", "read-only":
+		return policy, nil
+	case "all":
+		for _, action := range policyActions {
+			policy[action] = true
+		}
+		return policy, nil
+	}
+	for _, action := range strings.Split(value, ",") {
+		action = strings.TrimSpace(action)
+		known := false
+		for _, a := range policyActions {
+			known = known || a == action
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown action %q in unverified policy", action)
+		}
+		policy[action] = true
+	}
+	return policy, nil
+}
+
+// mayPerform reports whether the user may perform action under the
+// unverified account policy
+func (s *Server) mayPerform(userID uuid.UUID, action string) bool {
+	if s.config.Unverified[action] {
+		return true
+	}
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error getting user by ID: %v", err)
+		return false
+	}
+	return user.EmailVerifiedAt != nil
+}
+
+// RequireVerified is a middleware that rejects action for accounts whose
+// email is not verified, unless the unverified policy allows it
+func (s *Server) RequireVerified(action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := s.getUserIDFromSession(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !s.mayPerform(userID, action) {
+			http.Error(w, "Please verify your email address first", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sendVerificationEmail creates a verification token for the user and
+// mails the link
+func (s *Server) sendVerificationEmail(user *db.User) error {
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	if err := s.store.CreateEmailVerification(user.Id, hash, time.Now().Add(emailVerificationTTL)); err != nil {
+		return err
+	}
+	link := s.config.BaseURL + "/verify-email?token=" + url.QueryEscape(token)
+	return s.config.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your forum email address",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Welcome to the forum! Please confirm your email address by opening the link below:\n\n" +
+			link + "\n\n" +
+			"If you did not sign up you can ignore this email.\n",
+	})
+}
+
+// VerifyEmailHandler handles the link from the verification email
+func (s *Server) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Verification token is required", http.StatusBadRequest)
+		return
+	}
+	_, err := s.store.VerifyEmail(hashSecretToken(token))
+	if err == db.ErrInvalidToken {
+		http.Error(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error verifying email: %v", err)
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/homepage", http.StatusFound)
+}
+
+// ResendVerificationHandler sends a new verification email to the user
+func (s *Server) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := s.getUserIDFromSession(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, err := s.store.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		http.Error(w, "Email is already verified", http.StatusConflict)
+		return
+	}
+	if err := s.sendVerificationEmail(user); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Verification email sent"))
+}
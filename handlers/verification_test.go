@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyLink matches the link in an email verification message
+var verifyLink = regexp.MustCompile(`/verify-email\?token=(\S+)`)
+
+// verifyEmail opens the verification link with token
+func verifyEmail(srv *Server, token string) int {
+	rec := httptest.NewRecorder()
+	srv.VerifyEmailHandler(rec, httptest.NewRequest("GET", "/verify-email?token="+url.QueryEscape(token), nil))
+	return rec.Code
+}
+
+// isVerified reports whether username has verified their email address
+func isVerified(t *testing.T, srv *Server, username string) bool {
+	t.Helper()
+	userID, err := srv.store.GetUserIDByUsernameOrEmail(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := srv.store.GetUserByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.EmailVerifiedAt != nil
+}
+
+func TestParseUnverifiedPolicy(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"read-only", nil},
+		{"all", policyActions},
+		{"comment", []string{ActionComment}},
+		{" react, message ", []string{ActionReact, ActionMessage}},
+	}
+	for _, tt := range tests {
+		policy, err := ParseUnverifiedPolicy(tt.value)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.value, err)
+		}
+		if len(policy) != len(tt.want) {
+			t.Errorf("%q = %v, want %v", tt.value, policy, tt.want)
+		}
+		for _, action := range tt.want {
+			if !policy[action] {
+				t.Errorf("%q does not allow %s", tt.value, action)
+			}
+		}
+	}
+	for _, value := range []string{"delete", "post,", "all,post"} {
+		if _, err := ParseUnverifiedPolicy(value); err == nil {
+			t.Errorf("%q parsed without an error", value)
+		}
+	}
+}
+
+func TestVerifyEmailByToken(t *testing.T) {
+	srv, store := newTestServer(t, Config{BaseURL: "http://forum.test"})
+	addUser(t, store, "alice", false)
+	session, _ := newTestSession(t, srv, "alice")
+
+	if rec := callHandler(srv.ResendVerificationHandler, session, ""); rec.Code != http.StatusOK {
+		t.Fatalf("resend: status %d: %s", rec.Code, rec.Body)
+	}
+	mail := sentMail(t, srv)
+	links := verifyLink.FindStringSubmatch(mail)
+	if links == nil || !strings.Contains(mail, "To: alice@example.com") {
+		t.Fatalf("verification mail:\n%s", mail)
+	}
+	token, err := url.QueryUnescape(links[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []string{"", "forged"} {
+		if code := verifyEmail(srv, bad); code != http.StatusBadRequest {
+			t.Fatalf("token %q: status %d", bad, code)
+		}
+	}
+	if isVerified(t, srv, "alice") {
+		t.Fatal("verified before opening the link")
+	}
+	if code := verifyEmail(srv, token); code != http.StatusFound {
+		t.Fatalf("verify: status %d", code)
+	}
+	if !isVerified(t, srv, "alice") {
+		t.Fatal("not verified after opening the link")
+	}
+	// The link works once, and verified accounts get no new one
+	if code := verifyEmail(srv, token); code != http.StatusBadRequest {
+		t.Fatalf("reusing the link: status %d", code)
+	}
+	if rec := callHandler(srv.ResendVerificationHandler, session, ""); rec.Code != http.StatusConflict {
+		t.Fatalf("resend when verified: status %d", rec.Code)
+	}
+}
+
+func TestVerifyEmailTokenExpires(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	userID := addUser(t, store, "alice", false)
+	if err := store.CreateEmailVerification(userID, hashSecretToken("expired"), time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if code := verifyEmail(srv, "expired"); code != http.StatusBadRequest {
+		t.Fatalf("expired token: status %d", code)
+	}
+	if isVerified(t, srv, "alice") {
+		t.Fatal("verified by an expired token")
+	}
+}
+
+func TestResendVerificationNeedsSession(t *testing.T) {
+	srv, _ := newTestServer(t, Config{})
+	if rec := callHandler(srv.ResendVerificationHandler, "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without a session: status %d", rec.Code)
+	}
+	if sentMail(t, srv) != "" {
+		t.Fatal("mail sent without a session")
+	}
+}
+
+func TestRequireVerifiedPolicy(t *testing.T) {
+	routes := []struct{ action, path string }{
+		{ActionPost, "/api/create-post"},
+		{ActionPost, "/api/edit-post"},
+		{ActionPost, "/api/delete-post"},
+		{ActionComment, "/api/create-comment"},
+		{ActionComment, "/api/edit-comment"},
+		{ActionComment, "/api/delete-comment"},
+		{ActionMessage, "/api/send-message"},
+		{ActionReact, "/api/add-post-reaction"},
+	}
+	refused := func(code int, body string) bool {
+		return code == http.StatusForbidden && strings.Contains(body, "verify your email")
+	}
+
+	for _, route := range routes {
+		action, path := route.action, route.path
+		t.Run(path, func(t *testing.T) {
+			h, srv, store := csrfTestServer(t)
+			addUser(t, store, "newbie", false)
+			newbie := csrfRequest{}
+			newbie.session, newbie.token = newTestSession(t, srv, "newbie")
+			alice := csrfRequest{}
+			alice.session, alice.token = newTestSession(t, srv, "alice")
+
+			// Requests that get through reach the handler, which refuses
+			// the empty body
+			srv.config.Unverified = UnverifiedPolicy{}
+			if code, body := newbie.do(h, path); !refused(code, body) {
+				t.Fatalf("unverified under read-only: status %d: %s", code, body)
+			}
+			if code, body := alice.do(h, path); code != http.StatusBadRequest {
+				t.Fatalf("verified under read-only: status %d: %s", code, body)
+			}
+
+			// Allowing every other action does not allow this one
+			others := UnverifiedPolicy{}
+			for _, a := range policyActions {
+				others[a] = a != action
+			}
+			srv.config.Unverified = others
+			if code, body := newbie.do(h, path); !refused(code, body) {
+				t.Fatalf("unverified with %s not allowed: status %d: %s", action, code, body)
+			}
+
+			srv.config.Unverified = UnverifiedPolicy{action: true}
+			if code, body := newbie.do(h, path); code != http.StatusBadRequest {
+				t.Fatalf("unverified with %s allowed: status %d: %s", action, code, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum/db"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// wsProtocolVersion is the version of the websocket envelope. Frames with
+// another version are refused.
+const wsProtocolVersion = 1
+
+// maxChatMessageLength bounds the length of direct messages in characters
+const maxChatMessageLength = 2000
+
+// inboundFrame is the envelope of every frame a client sends. ID is chosen
+// by the client and echoed in the ack or error frame answering it.
+type inboundFrame struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// outboundFrame is the envelope of every frame the server sends
+type outboundFrame struct {
+	Version int         `json:"v"`
+	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// newFrame wraps payload in an envelope of the given type
+func newFrame(frameType string, payload interface{}) outboundFrame {
+	return outboundFrame{Version: wsProtocolVersion, Type: frameType, Payload: payload}
+}
+
+// wsError is sent back to the client in an error frame
+type wsError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *wsError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Error codes of error frames
+const (
+	wsBadRequest  = "bad_request"
+	wsUnsupported = "unsupported_version"
+	wsUnknownType = "unknown_type"
+	wsForbidden   = "forbidden"
+	wsRateLimited = "rate_limited"
+	wsNotFound    = "not_found"
+	wsInternal    = "internal_error"
+)
+
+// wsHandler handles the payload of one type of inbound frame for the
+// authenticated client. The result is sent back in an ack frame. Errors
+// that are not a *wsError are logged and reported as internal errors.
+type wsHandler func(s *Server, c *client, payload json.RawMessage) (interface{}, error)
+
+// wsHandlers maps inbound frame types to their handlers
+var wsHandlers = map[string]wsHandler{
+	"message":  (*Server).wsSendMessage,
+	"reaction": (*Server).wsReact,
+}
+
+// handleFrame decodes a frame read from c, dispatches it to the handler of
+// its type and answers with an ack or error frame
+func (s *Server) handleFrame(c *client, data []byte) {
+	var frame inboundFrame
+	var result interface{}
+	err := json.Unmarshal(data, &frame)
+	if err != nil {
+		err = &wsError{Code: wsBadRequest, Message: "Invalid JSON frame"}
+	} else {
+		result, err = s.dispatchFrame(c, frame)
+	}
+	reply := outboundFrame{Version: wsProtocolVersion, Type: "ack", ID: frame.ID}
+	if err != nil {
+		var we *wsError
+		if !errors.As(err, &we) {
+			log.Printf("Error handling %s frame from user %s: %v", frame.Type, c.userID, err)
+			we = &wsError{Code: wsInternal, Message: "Something went wrong"}
+		}
+		reply.Type = "error"
+		result = we
+	}
+	reply.Payload = result
+	s.hub.sendToClient(c, reply)
+}
+
+func (s *Server) dispatchFrame(c *client, frame inboundFrame) (interface{}, error) {
+	if frame.Version != wsProtocolVersion {
+		return nil, &wsError{Code: wsUnsupported, Message: "Unsupported protocol version"}
+	}
+	handler, ok := wsHandlers[frame.Type]
+	if !ok {
+		return nil, &wsError{Code: wsUnknownType, Message: "Unknown frame type " + frame.Type}
+	}
+	return handler(s, c, frame.Payload)
+}
+
+// decodePayload strictly decodes a frame payload into v
+func decodePayload(payload json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return &wsError{Code: wsBadRequest, Message: "Invalid payload"}
+	}
+	return nil
+}
+
+// wsSendMessage stores a direct message from the client's user and delivers
+// it to the receiver's connections
+func (s *Server) wsSendMessage(c *client, payload json.RawMessage) (interface{}, error) {
+	var req struct {
+		Receiver uuid.UUID `json:"receiver"`
+		Content  string    `json:"content"`
+	}
+	if err := decodePayload(payload, &req); err != nil {
+		return nil, err
+	}
+	req.Content = strings.TrimSpace(req.Content)
+	if req.Receiver == uuid.Nil || req.Receiver == c.userID {
+		return nil, &wsError{Code: wsBadRequest, Message: "A receiver other than yourself is required"}
+	}
+	if req.Content == "" || utf8.RuneCountInString(req.Content) > maxChatMessageLength {
+		return nil, &wsError{Code: wsBadRequest, Message: "Message must be between 1 and 2000 characters"}
+	}
+	if !s.mayPerform(c.userID, ActionMessage) {
+		return nil, &wsError{Code: wsForbidden, Message: "Please verify your email address first"}
+	}
+	if !s.allowUser(LimitMessage, c.userID, c.role) {
+		return nil, &wsError{Code: wsRateLimited, Message: "Too many messages. Please slow down."}
+	}
+	if _, err := s.store.GetUserByID(req.Receiver); err == sql.ErrNoRows {
+		return nil, &wsError{Code: wsNotFound, Message: "Receiver not found"}
+	} else if err != nil {
+		return nil, err
+	}
+	if err := s.store.AddMessage(c.userID, req.Receiver, req.Content); err != nil {
+		return nil, err
+	}
+	msg := db.WebSocketMessage{
+		Type:      "message",
+		Content:   req.Content,
+		Sender:    c.userID,
+		Receiver:  req.Receiver,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	s.hub.SendToUser(req.Receiver, newFrame("message", msg))
+	return msg, nil
+}
+
+// wsReact applies a reaction of the client's user, as the reaction
+// endpoints do, and broadcasts the new counts
+func (s *Server) wsReact(c *client, payload json.RawMessage) (interface{}, error) {
+	var req reactionRequest
+	if err := decodePayload(payload, &req); err != nil {
+		return nil, err
+	}
+	if (req.PostID == "") == (req.CommentID == "") || req.Type == "" {
+		return nil, &wsError{Code: wsBadRequest, Message: "One of post_id and comment_id and a reaction type are required"}
+	}
+	msg := db.ReactionMessage{Type: "reaction", UserID: c.userID}
+	var err error
+	if req.PostID != "" {
+		msg.PostID, err = uuid.FromString(req.PostID)
+	} else {
+		msg.CommentID, err = uuid.FromString(req.CommentID)
+	}
+	if err != nil {
+		return nil, &wsError{Code: wsBadRequest, Message: "Invalid target ID"}
+	}
+	if !s.mayPerform(c.userID, ActionReact) {
+		return nil, &wsError{Code: wsForbidden, Message: "Please verify your email address first"}
+	}
+	if !s.allowUser(LimitAPI, c.userID, c.role) {
+		return nil, &wsError{Code: wsRateLimited, Message: "Too many requests. Please slow down."}
+	}
+	if err := s.loadReactionCounts(&msg); err != nil {
+		return nil, &wsError{Code: wsNotFound, Message: "Target not found"}
+	}
+	err = s.applyReaction(&msg, db.ReactionType(req.Type))
+	if errors.Is(err, db.ErrInvalidReaction) {
+		return nil, &wsError{Code: wsBadRequest, Message: "Invalid reaction type"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.hub.Broadcast(newFrame("reaction", msg))
+	return msg, nil
+}
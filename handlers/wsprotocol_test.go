@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/db"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/websocket"
+)
+
+// wsFrame is an outbound frame with its payload left undecoded
+type wsFrame struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// dialForum opens a websocket to the forum served by ts as username
+func dialForum(t *testing.T, ts *httptest.Server, srv *Server, username string) *websocket.Conn {
+	t.Helper()
+	session, token := newTestSession(t, srv, username)
+	return dialSession(t, ts, session, token)
+}
+
+// dialSession opens a websocket to the forum served by ts with the given
+// session and CSRF token
+func dialSession(t *testing.T, ts *httptest.Server, session, token string) *websocket.Conn {
+	t.Helper()
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?session_token=" + url.QueryEscape(session) + "&csrf_token=" + url.QueryEscape(token)
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	// The server answers frames only once the connection is registered
+	exchange(t, conn, "{}")
+	return conn
+}
+
+// exchange sends data and returns the next frame
+func exchange(t *testing.T, conn *websocket.Conn, data string) wsFrame {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	return nextFrame(t, conn)
+}
+
+func nextFrame(t *testing.T, conn *websocket.Conn) wsFrame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frame wsFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		t.Fatalf("invalid frame %s: %v", data, err)
+	}
+	if frame.Version != wsProtocolVersion {
+		t.Fatalf("frame version %d", frame.Version)
+	}
+	return frame
+}
+
+// expectWSError checks that frame is an error frame for id with code
+func expectWSError(t *testing.T, frame wsFrame, id, code string) {
+	t.Helper()
+	var e wsError
+	if err := json.Unmarshal(frame.Payload, &e); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Type != "error" || frame.ID != id || e.Code != code {
+		t.Fatalf("got %s frame %q with %+v, want error %q with code %s", frame.Type, frame.ID, e, id, code)
+	}
+}
+
+func TestWSEnvelopeErrors(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	addUser(t, store, "alice", true)
+	bobID := addUser(t, store, "bob", true)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	conn := dialForum(t, ts, srv, "alice")
+
+	message := `{"receiver": "` + bobID.String() + `", "content": "hi"}`
+	tests := []struct {
+		name, frame, id, code string
+	}{
+		{"malformed JSON", `{"v": 1, "type": "message", "id": "m1"`, "", wsBadRequest},
+		{"not an object", `[1, 2]`, "", wsBadRequest},
+		{"missing version", `{"type": "message", "id": "m2", "payload": ` + message + `}`, "m2", wsUnsupported},
+		{"wrong version", `{"v": 2, "type": "message", "id": "m3", "payload": ` + message + `}`, "m3", wsUnsupported},
+		{"unknown type", `{"v": 1, "type": "typing", "id": "m4", "payload": {}}`, "m4", wsUnknownType},
+		{"unknown payload field", `{"v": 1, "type": "message", "id": "m5", "payload": {"receiver": "` + bobID.String() + `", "content": "hi", "urgent": true}}`, "m5", wsBadRequest},
+		{"unknown reaction field", `{"v": 1, "type": "reaction", "id": "m6", "payload": {"post_id": "x", "type": "like", "extra": 1}}`, "m6", wsBadRequest},
+		{"payload of the wrong type", `{"v": 1, "type": "message", "id": "m7", "payload": "hi"}`, "m7", wsBadRequest},
+		{"missing payload", `{"v": 1, "type": "message", "id": "m8"}`, "m8", wsBadRequest},
+		{"invalid message", `{"v": 1, "type": "message", "id": "m9", "payload": {"receiver": "` + bobID.String() + `", "content": "  "}}`, "m9", wsBadRequest},
+		{"unknown receiver", `{"v": 1, "type": "message", "id": "m10", "payload": {"receiver": "` + uuid.Must(uuid.NewV4()).String() + `", "content": "hi"}}`, "m10", wsNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectWSError(t, exchange(t, conn, tt.frame), tt.id, tt.code)
+		})
+	}
+	// The connection survives every bad frame
+	frame := exchange(t, conn, `{"v": 1, "type": "message", "id": "ok", "payload": `+message+`}`)
+	if frame.Type != "ack" || frame.ID != "ok" {
+		t.Fatalf("got %s frame %q after the errors", frame.Type, frame.ID)
+	}
+}
+
+func TestWSMessageSender(t *testing.T) {
+	srv, store := newTestServer(t, Config{})
+	aliceID := addUser(t, store, "alice", true)
+	bobID := addUser(t, store, "bob", true)
+	carolID := addUser(t, store, "carol", true)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	alice := dialForum(t, ts, srv, "alice")
+	bob := dialForum(t, ts, srv, "bob")
+
+	// A sender in the payload is refused rather than trusted
+	spoofed := `{"v": 1, "type": "message", "id": "s1", "payload": {"sender": "` + carolID.String() +
+		`", "receiver": "` + bobID.String() + `", "content": "from carol"}}`
+	expectWSError(t, exchange(t, alice, spoofed), "s1", wsBadRequest)
+
+	frame := exchange(t, alice, `{"v": 1, "type": "message", "id": "s2", "payload": {"receiver": "`+bobID.String()+`", "content": "from alice"}}`)
+	var ack db.WebSocketMessage
+	if err := json.Unmarshal(frame.Payload, &ack); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Type != "ack" || frame.ID != "s2" || ack.Sender != aliceID || ack.Receiver != bobID {
+		t.Fatalf("ack: %s %q %+v", frame.Type, frame.ID, ack)
+	}
+
+	// Bob only ever receives the message as sent by Alice
+	frame = nextFrame(t, bob)
+	var got db.WebSocketMessage
+	if err := json.Unmarshal(frame.Payload, &got); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Type != "message" || frame.ID != "" || got.Sender != aliceID || got.Content != "from alice" {
+		t.Fatalf("delivered: %s %q %+v", frame.Type, frame.ID, got)
+	}
+	messages, err := store.GetMessages(aliceID, bobID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].SenderID != aliceID {
+		t.Fatalf("stored messages: %+v", messages)
+	}
+}
@@ -0,0 +1,110 @@
+package mail
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTPMailer sends email through an SMTP server
+type SMTPMailer struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+// NewSMTPMailer creates a mailer for the SMTP server at host:port. Auth is
+// only used when username is set.
+func NewSMTPMailer(host, port, from, username, password string) *SMTPMailer {
+	return &SMTPMailer{
+		Addr:     host + ":" + port,
+		From:     from,
+		Username: username,
+		Password: password,
+	}
+}
+
+// Send delivers msg with net/smtp
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, format(m.From, msg))
+}
+
+// FileMailer writes email to a file, or to the log when no path is given,
+// instead of sending it. It is meant for local development and tests.
+type FileMailer struct {
+	From string
+	Path string
+	mu   sync.Mutex
+}
+
+// NewFileMailer creates a mailer that appends messages to path, or logs
+// them when path is empty
+func NewFileMailer(from, path string) *FileMailer {
+	return &FileMailer{From: from, Path: path}
+}
+
+// Send writes msg to the file or log
+func (m *FileMailer) Send(msg Message) error {
+	data := format(m.From, msg)
+	if m.Path == "" {
+		log.Printf("Mail to %s:\n%s", msg.To, data)
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, "\r\n")
+	return err
+}
+
+// format renders msg as an RFC 5322 message
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// headerValue strips line breaks so values cannot inject extra headers
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
@@ -4,54 +4,161 @@ import (
 	"fmt"
 	"forum/db"
 	"forum/handlers"
+	"forum/mail"
+	"forum/oidc"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 )
 
 func main() {
-	err := db.ConnectDatabase()
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		dsn = "./db/database.db"
+	}
+	store, err := db.Open(dsn)
 	if err != nil {
 		fmt.Println("failed to connect to database in main.go")
 		log.Fatal(err)
 	}
+	defer store.Close()
 
-	// Serve static files
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-
-	// Page routes (with login rate limiting)
-	http.HandleFunc("/", handlers.MainPageHandler)
-	http.Handle("/registration", handlers.RateLimitMiddleware(handlers.LoginLimiter, http.HandlerFunc(handlers.SignupHandler)))
-	http.HandleFunc("/homepage", handlers.HomepageHandler)
-	http.HandleFunc("/logout", handlers.LogoutHandler)
-
-	// API routes with rate limiting
-	http.HandleFunc("/api/validate-session", handlers.ValidateSessionHandler)
-	http.HandleFunc("/api/csrf-token", handlers.GetCSRFTokenHandler)
-
-	http.Handle("/api/create-category", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.CreateCategoryHandler))))
-	http.Handle("/api/get-categories", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.GetCategoriesHandler))))
-	http.Handle("/api/get-category", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.GetCategoryByIDHandler))))
-
-	http.Handle("/api/create-post", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.CreatePostHandler))))
-	http.Handle("/api/create-comment", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.CreateCommentHandler))))
-	http.Handle("/api/get-posts", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.GetPostsHandler))))
-	http.Handle("/api/get-comments", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.GetCommentsHandler))))
-	http.Handle("/api/send-message", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.SendMessageHandler))))
-	http.Handle("/api/get-messages", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.GetMessagesHandler))))
-	http.Handle("/api/update-status", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.UpdateStatusHandler))))
-	http.Handle("/api/get-user-status", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.GetUserStatusHandler))))
-	http.Handle("/api/mark-message-read", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.MarkMessageAsReadHandler))))
-	http.Handle("/api/get-users", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.GetUsersHandler))))
-	http.Handle("/api/add-post-reaction", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.AddPostReactionHandler))))
-	http.Handle("/api/add-comment-reaction", handlers.RateLimitMiddleware(handlers.APILimiter, handlers.RequireLogin(http.HandlerFunc(handlers.AddCommentReactionHandler))))
-
-	// WebSocket handler
-	handlers.WebSocketHandler()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(store, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "set-role" {
+		if err := runSetRoleCommand(store, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := store.Migrate(); err != nil {
+		fmt.Println("failed to migrate database in main.go")
+		log.Fatal(err)
+	}
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	// UNVERIFIED_ACTIONS lists what accounts may do before verifying their
+	// email: a comma separated list of post, comment, react and message,
+	// "all", or "read-only" (the default)
+	unverified, err := handlers.ParseUnverifiedPolicy(os.Getenv("UNVERIFIED_ACTIONS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	passwords, err := newPasswordPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// OIDC_PROVIDERS_FILE is a JSON list of identity providers users can
+	// sign in with
+	var providers []oidc.ProviderConfig
+	if path := os.Getenv("OIDC_PROVIDERS_FILE"); path != "" {
+		providers, err = oidc.LoadProviders(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// TRUSTED_PROXIES lists the CIDRs of reverse proxies whose forwarding
+	// header gives the client address. TRUSTED_PROXY_HEADER names the one
+	// header they write: X-Forwarded-For (the default), Forwarded or
+	// X-Real-IP.
+	proxies, err := handlers.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	proxyHeader, err := handlers.ParseProxyHeader(os.Getenv("TRUSTED_PROXY_HEADER"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// RATE_LIMITS_FILE is a JSON file declaring the rate limit policies and
+	// role multipliers; see assets/rate-limits.json
+	limits := handlers.DefaultRateLimits()
+	if path := os.Getenv("RATE_LIMITS_FILE"); path != "" {
+		limits, err = handlers.LoadRateLimits(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// RATE_LIMIT_BACKEND=sql keeps rate limits in the database, shared by
+	// every instance, instead of in memory
+	var limiter handlers.Limiter
+	switch backend := os.Getenv("RATE_LIMIT_BACKEND"); backend {
+	case "", "memory":
+	case "sql":
+		limiter = handlers.NewSQLLimiter(store)
+	default:
+		log.Fatalf("unknown RATE_LIMIT_BACKEND %q", backend)
+	}
+	srv := handlers.NewServer(store, handlers.Config{
+		BaseURL:        baseURL,
+		Mailer:         newMailer(),
+		Unverified:     unverified,
+		Passwords:      passwords,
+		OIDCProviders:  providers,
+		RateLimits:     limits,
+		RateLimiter:    limiter,
+		TrustedProxies: proxies,
+		ProxyHeader:    proxyHeader,
+	})
+	defer srv.Close()
 
 	fmt.Printf("Starting server at port 8080\n")
 	fmt.Printf("Go to http://localhost:8080/\n")
 	fmt.Printf("Ctrl + C to close the server\n")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := http.ListenAndServe(":8080", srv.Handler()); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// newMailer sends email over SMTP when SMTP_HOST is set. Otherwise mail is
+// written to MAIL_FILE, or to the log when that is unset too.
+func newMailer() mail.Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "noreply@localhost"
+	}
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return mail.NewFileMailer(from, os.Getenv("MAIL_FILE"))
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return mail.NewSMTPMailer(host, port, from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+}
+
+// newPasswordPolicy reads the password rules: PASSWORD_MIN_LENGTH (default
+// 8), PASSWORD_REQUIRE_MIXED=true to require letters and digits, and
+// BREACHED_PASSWORDS_FILE, the list of rejected passwords.
+func newPasswordPolicy() (handlers.PasswordPolicy, error) {
+	policy := handlers.DefaultPasswordPolicy()
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return policy, fmt.Errorf("invalid PASSWORD_MIN_LENGTH: %s", v)
+		}
+		policy.MinLength = n
+	}
+	policy.RequireMixed = os.Getenv("PASSWORD_REQUIRE_MIXED") == "true"
+	path := os.Getenv("BREACHED_PASSWORDS_FILE")
+	if path == "" {
+		path = "assets/breached-passwords.txt"
+	}
+	breached, err := handlers.LoadBreachedPasswords(path)
+	if os.IsNotExist(err) && os.Getenv("BREACHED_PASSWORDS_FILE") == "" {
+		log.Printf("No breached password list at %s", path)
+		return policy, nil
+	}
+	if err != nil {
+		return policy, err
+	}
+	policy.Breached = breached
+	return policy, nil
+}
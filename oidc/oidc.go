@@ -0,0 +1,287 @@
+// Package oidc implements the OpenID Connect authorization code flow with
+// PKCE for logging in through external identity providers.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProviderConfig describes an identity provider
+type ProviderConfig struct {
+	// Name identifies the provider in URLs and linked identities, and must
+	// not change once users have signed in with it
+	Name string `json:"name"`
+	// DisplayName is shown on the login button
+	DisplayName  string   `json:"display_name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+var providerName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// LoadProviders reads a JSON list of ProviderConfig from path
+func LoadProviders(path string) ([]ProviderConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var providers []ProviderConfig
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for _, p := range providers {
+		if !providerName.MatchString(p.Name) || seen[p.Name] {
+			return nil, fmt.Errorf("provider name %q must be unique and use only a-z, 0-9, _ and -", p.Name)
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("provider %s needs an issuer and a client_id", p.Name)
+		}
+		seen[p.Name] = true
+	}
+	return providers, nil
+}
+
+// Claims are the ID token claims used to sign users in
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// audience accepts the aud claim as a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// discovery is the subset of the provider metadata the client uses
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Client runs the login flow against one provider. Provider metadata and
+// signing keys are fetched on first use and cached.
+type Client struct {
+	Config      ProviderConfig
+	RedirectURL string
+	// HTTPClient is used for all requests to the provider
+	HTTPClient *http.Client
+
+	mu   sync.Mutex
+	meta *discovery
+	keys *keySet
+}
+
+// NewClient creates a client for provider that returns users to redirectURL
+func NewClient(provider ProviderConfig, redirectURL string) *Client {
+	return &Client{
+		Config:      provider,
+		RedirectURL: redirectURL,
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// clockSkew is how far the provider's clock may be off from ours
+const clockSkew = time.Minute
+
+// Errors returned while completing a login
+var (
+	ErrInvalidToken = errors.New("oidc: invalid id token")
+	ErrNonce        = errors.New("oidc: nonce mismatch")
+)
+
+// NewPKCE returns a random code verifier and its S256 challenge
+func NewPKCE() (verifier, challenge string, err error) {
+	verifier, err = randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// NewNonce returns a random value for the state or nonce parameters
+func NewNonce() (string, error) {
+	return randomString(24)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL returns the provider URL that starts a login
+func (c *Client) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+	meta, err := c.discover()
+	if err != nil {
+		return "", err
+	}
+	scopes := c.Config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", c.Config.ClientID)
+	v.Set("redirect_uri", c.RedirectURL)
+	v.Set("scope", strings.Join(scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", codeChallenge)
+	v.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims of
+// the ID token
+func (c *Client) Exchange(code, codeVerifier, nonce string) (*Claims, error) {
+	meta, err := c.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.RedirectURL)
+	form.Set("client_id", c.Config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	if c.Config.ClientSecret != "" {
+		form.Set("client_secret", c.Config.ClientSecret)
+	}
+	resp, err := c.HTTPClient.PostForm(meta.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint returned %s", resp.Status)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+	return c.Verify(tokens.IDToken, nonce)
+}
+
+// Verify checks the signature and claims of an ID token
+func (c *Client) Verify(idToken, nonce string) (*Claims, error) {
+	meta, err := c.discover()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := c.verifySignature(idToken, meta.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != meta.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidToken, claims.Issuer)
+	case !claims.Audience.contains(c.Config.ClientID):
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	case claims.Expiry == 0 || now.Add(-clockSkew).Unix() >= claims.Expiry:
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.IssuedAt > now.Add(clockSkew).Unix():
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case claims.Nonce != nonce:
+		return nil, ErrNonce
+	}
+	return &claims, nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// discover fetches and caches the provider metadata
+func (c *Client) discover() (*discovery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.meta != nil {
+		return c.meta, nil
+	}
+	var meta discovery
+	wellKnown := strings.TrimSuffix(c.Config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := c.getJSON(wellKnown, &meta); err != nil {
+		return nil, err
+	}
+	if meta.Issuer != c.Config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", meta.Issuer, c.Config.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("oidc: incomplete provider metadata")
+	}
+	c.meta = &meta
+	return c.meta, nil
+}
+
+func (c *Client) getJSON(url string, v interface{}) error {
+	resp, err := c.HTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,193 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"forum/oidc/oidctest"
+)
+
+func newTestClient(t *testing.T) (*Client, *oidctest.Provider) {
+	t.Helper()
+	p := oidctest.NewProvider("forum")
+	t.Cleanup(p.Close)
+	c := NewClient(ProviderConfig{Name: "test", Issuer: p.Issuer, ClientID: "forum"}, "http://forum.test/oidc/callback")
+	return c, p
+}
+
+func TestDiscoveryAndAuthCodeURL(t *testing.T) {
+	c, p := newTestClient(t)
+	authURL, err := c.AuthCodeURL("state1", "nonce1", "challenge1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != p.Issuer+"/authorize" {
+		t.Errorf("authorization endpoint = %s", got)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "forum",
+		"redirect_uri":          "http://forum.test/oidc/callback",
+		"state":                 "state1",
+		"nonce":                 "nonce1",
+		"code_challenge":        "challenge1",
+		"code_challenge_method": "S256",
+		"scope":                 "openid email profile",
+	}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	p := oidctest.NewProvider("forum")
+	defer p.Close()
+	c := NewClient(ProviderConfig{Name: "test", Issuer: p.Issuer + "/other", ClientID: "forum"}, "")
+	if _, err := c.AuthCodeURL("s", "n", "c"); err == nil {
+		t.Fatal("expected an error for a provider at another issuer")
+	}
+}
+
+func TestExchangeWithPKCE(t *testing.T) {
+	c, p := newTestClient(t)
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := p.Claims("user-1", "nonce1")
+	claims["email"] = "a@example.com"
+	claims["email_verified"] = true
+	code := p.IssueCode(challenge, p.Sign(claims))
+
+	got, err := c.Exchange(code, verifier, "nonce1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != "user-1" || got.Email != "a@example.com" || !got.EmailVerified {
+		t.Errorf("claims = %+v", got)
+	}
+	if p.JWKSFetches() != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", p.JWKSFetches())
+	}
+	// Codes are single use
+	if _, err := c.Exchange(code, verifier, "nonce1"); err == nil {
+		t.Error("expected a redeemed code to be refused")
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	c, p := newTestClient(t)
+	_, challenge, _ := NewPKCE()
+	other, _, _ := NewPKCE()
+	code := p.IssueCode(challenge, p.Sign(p.Claims("user-1", "n")))
+	if _, err := c.Exchange(code, other, "n"); err == nil {
+		t.Fatal("expected the token endpoint to refuse a wrong code verifier")
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	c, p := newTestClient(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := func() map[string]interface{} { return p.Claims("user-1", "nonce1") }
+	with := func(k string, v interface{}) map[string]interface{} {
+		claims := valid()
+		claims[k] = v
+		return claims
+	}
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"bad signature", oidctest.SignWith(otherKey, "RS256", "key-1", valid()), ErrInvalidToken},
+		{"wrong alg", oidctest.SignWith(otherKey, "HS256", "key-1", valid()), ErrInvalidToken},
+		{"alg none", unsignedToken(t, valid()), ErrInvalidToken},
+		{"nonce mismatch", p.Sign(with("nonce", "other")), ErrNonce},
+		{"wrong audience", p.Sign(with("aud", "someone-else")), ErrInvalidToken},
+		{"wrong issuer", p.Sign(with("iss", "https://evil.example")), ErrInvalidToken},
+		{"expired", p.Sign(with("exp", time.Now().Add(-2*clockSkew).Unix())), ErrInvalidToken},
+		{"issued in the future", p.Sign(with("iat", time.Now().Add(2*clockSkew).Unix())), ErrInvalidToken},
+		{"no subject", p.Sign(with("sub", "")), ErrInvalidToken},
+		{"malformed", "not.a-token", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.Verify(tt.token, "nonce1"); !errors.Is(err, tt.err) {
+				t.Errorf("Verify error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+	if _, err := c.Verify(p.Sign(valid()), "nonce1"); err != nil {
+		t.Errorf("valid token refused: %v", err)
+	}
+	if _, err := c.Verify(p.Sign(with("aud", []string{"other", "forum"})), "nonce1"); err != nil {
+		t.Errorf("token with a list audience refused: %v", err)
+	}
+}
+
+// unsignedToken returns a token with alg "none" and no signature
+func unsignedToken(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"key-1"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func TestKeyRotation(t *testing.T) {
+	c, p := newTestClient(t)
+	if _, err := c.Verify(p.Sign(p.Claims("user-1", "n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A token signed with a new key is refused until the key set may be
+	// refetched, so unknown key IDs cannot hammer the provider
+	p.RotateKey(true)
+	rotated := p.Sign(p.Claims("user-1", "n"))
+	if _, err := c.Verify(rotated, "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify error = %v, want ErrInvalidToken", err)
+	}
+	if p.JWKSFetches() != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", p.JWKSFetches())
+	}
+
+	c.mu.Lock()
+	c.keys.fetchedAt = time.Now().Add(-keyRefreshInterval)
+	c.mu.Unlock()
+	if _, err := c.Verify(rotated, "n"); err != nil {
+		t.Fatalf("token signed with the rotated key refused: %v", err)
+	}
+	if p.JWKSFetches() != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", p.JWKSFetches())
+	}
+
+	// Keys that are no longer published stop being accepted after a refresh
+	p.RotateKey(false)
+	c.mu.Lock()
+	c.keys.fetchedAt = time.Now().Add(-keyRefreshInterval)
+	c.mu.Unlock()
+	if _, err := c.Verify(p.Sign(p.Claims("user-1", "n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Verify(rotated, "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token signed with a retired key: error = %v, want ErrInvalidToken", err)
+	}
+}
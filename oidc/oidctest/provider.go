@@ -0,0 +1,229 @@
+// Package oidctest runs a fake OpenID Connect provider for tests.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Provider is an identity provider serving discovery, keys, authorization
+// and token endpoints. It signs ID tokens with RS256.
+type Provider struct {
+	Server   *httptest.Server
+	Issuer   string
+	ClientID string
+
+	mu   sync.Mutex
+	keys []signingKey
+	// codes maps issued authorization codes to their pending logins
+	codes map[string]pendingCode
+	// user is who signs in at the authorization endpoint
+	user User
+	// jwksFetches counts requests for the key set
+	jwksFetches int
+}
+
+// User is the account that signs in at the provider
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type signingKey struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+type pendingCode struct {
+	challenge string
+	idToken   string
+}
+
+// NewProvider starts a provider for clientID. Close it when done.
+func NewProvider(clientID string) *Provider {
+	p := &Provider{ClientID: clientID, codes: map[string]pendingCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("/jwks", p.handleJWKS)
+	mux.HandleFunc("/authorize", p.handleAuthorize)
+	mux.HandleFunc("/token", p.handleToken)
+	p.Server = httptest.NewServer(mux)
+	p.Issuer = p.Server.URL
+	p.RotateKey(false)
+	return p
+}
+
+// Close shuts the provider down
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+// SetUser sets the account that signs in at the authorization endpoint
+func (p *Provider) SetUser(u User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = u
+}
+
+// RotateKey starts signing with a new key. The old keys stay published
+// when keepOld is set.
+func (p *Provider) RotateKey(keepOld bool) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	k := signingKey{kid: fmt.Sprintf("key-%d", len(p.keys)+1), key: key}
+	if !keepOld {
+		p.keys = nil
+	}
+	p.keys = append(p.keys, k)
+}
+
+// JWKSFetches returns how often the key set was requested
+func (p *Provider) JWKSFetches() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jwksFetches
+}
+
+// Claims returns valid ID token claims for subject and nonce
+func (p *Provider) Claims(subject, nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":   p.Issuer,
+		"sub":   subject,
+		"aud":   p.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": nonce,
+	}
+}
+
+// Sign returns an RS256 ID token with claims signed by the current key
+func (p *Provider) Sign(claims map[string]interface{}) string {
+	p.mu.Lock()
+	k := p.keys[len(p.keys)-1]
+	p.mu.Unlock()
+	return SignWith(k.key, "RS256", k.kid, claims)
+}
+
+// SignWith returns an ID token with claims signed by key, with alg and kid
+// written into the header as given
+func SignWith(key *rsa.PrivateKey, alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := encode(header) + "." + encode(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + encode(sig)
+}
+
+// IssueCode registers an authorization code that redeems to idToken for
+// the holder of the PKCE verifier matching challenge
+func (p *Provider) IssueCode(challenge, idToken string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	code := encode(b)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[code] = pendingCode{challenge: challenge, idToken: idToken}
+	return code
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{
+		"issuer":                 p.Issuer,
+		"authorization_endpoint": p.Issuer + "/authorize",
+		"token_endpoint":         p.Issuer + "/token",
+		"jwks_uri":               p.Issuer + "/jwks",
+	})
+}
+
+func (p *Provider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jwksFetches++
+	var keys []map[string]string
+	for _, k := range p.keys {
+		keys = append(keys, map[string]string{
+			"kid": k.kid,
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encode(k.key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	writeJSON(w, map[string]interface{}{"keys": keys})
+}
+
+// handleAuthorize signs the current user in without asking and sends them
+// back to the client with a code
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.ClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	p.mu.Lock()
+	u := p.user
+	p.mu.Unlock()
+	claims := p.Claims(u.Subject, q.Get("nonce"))
+	claims["email"] = u.Email
+	claims["email_verified"] = u.EmailVerified
+	code := p.IssueCode(q.Get("code_challenge"), p.Sign(claims))
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// handleToken redeems a code once, checking the PKCE verifier
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.FormValue("grant_type") != "authorization_code" || r.FormValue("client_id") != p.ClientID {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	p.mu.Lock()
+	pending, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || encode(sum[:]) != pending.challenge {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]string{"id_token": pending.idToken, "token_type": "Bearer"})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,5 +1,49 @@
 import { navigateTo } from './router.js';
 
+// Every request that is not a GET must carry a CSRF token bound to the
+// session, or before login to the pre-session cookie set by /api/csrf-token
+let csrfToken = null;
+
+export const getCSRFToken = async () => {
+    if (!csrfToken) {
+        const response = await fetch("/api/csrf-token");
+        if (!response.ok) {
+            throw new Error("Failed to fetch CSRF token");
+        }
+        csrfToken = await response.text();
+    }
+    return csrfToken;
+};
+
+// setCSRFToken keeps the token the server rotated to on login
+export const setCSRFToken = (token) => {
+    csrfToken = token;
+};
+
+export const clearCSRFToken = () => {
+    csrfToken = null;
+};
+
+// csrfFetch is fetch with the CSRF header added. A rejected token, for
+// example after it expired, is refreshed and the request retried once.
+export const csrfFetch = async (url, options = {}) => {
+    const send = async () => {
+        const headers = new Headers(options.headers);
+        headers.set('X-CSRF-Token', await getCSRFToken());
+        return fetch(url, { ...options, headers });
+    };
+    let response = await send();
+    if (response.status === 403 && (await response.clone().text()).startsWith("Invalid CSRF token")) {
+        clearCSRFToken();
+        response = await send();
+    }
+    const rotated = response.headers.get('X-CSRF-Token');
+    if (rotated) {
+        setCSRFToken(rotated);
+    }
+    return response;
+};
+
 export const sendRequest = async (url, method, body = null) => {
     const headers = new Headers();
     headers.append('Content-Type', 'application/json');
@@ -10,11 +54,12 @@ export const sendRequest = async (url, method, body = null) => {
         headers.append('Authorization', `Bearer ${sessionToken.split('=')[1]}`);
     }
 
-    const response = await fetch(url, {
+    const options = {
         method: method,
         headers: headers,
         body: body ? JSON.stringify(body) : null
-    });
+    };
+    const response = method === "GET" ? await fetch(url, options) : await csrfFetch(url, options);
 
     // If unauthorized, redirect to login page
     if (response.status === 401) {
@@ -48,11 +93,13 @@ export const sendMessage = async (body) => {
     return response;
 };
 
-export const getPosts = async () => {
-    const response = await sendRequest("/api/get-posts", "GET");
+export const getPosts = async (cursor = "") => {
+    const url = cursor ? `/api/get-posts?cursor=${encodeURIComponent(cursor)}` : "/api/get-posts";
+    const response = await sendRequest(url, "GET");
 
     if (!response.ok) {
         throw new Error("Failed to fetch posts");
     }
-    return await response.json();
+    const page = await response.json();
+    return page.posts;
 };
\ No newline at end of file
@@ -1,5 +1,6 @@
-import { sendRequest } from './api.js';
+import { sendRequest, clearCSRFToken } from './api.js';
 import { navigateTo } from './router.js';
+import { connectWebSocket } from './websocket.js';
 
 export const isAuthenticated = async () => {
     const response = await sendRequest("/api/validate-session", "GET");
@@ -7,8 +8,9 @@ export const isAuthenticated = async () => {
 };
 
 export const logout = async () => {
-    const response = await sendRequest("/logout", "GET");
+    const response = await sendRequest("/logout", "POST");
     if (response.ok) {
+        clearCSRFToken();
         document.cookie = 'session_token=; Max-Age=0; path=/;';
         navigateTo("/");
     } else {
@@ -1,7 +1,7 @@
 import { navigateTo } from './router.js';
-import { createPost, createCategory, getCategories, getPosts, sendMessage } from './api.js';
+import { createPost, createCategory, getCategories, getPosts, sendMessage, csrfFetch } from './api.js';
 import { logout } from './auth.js';
-import { setFormMessage } from './formHandler.js';
+import { setFormMessage, setInputError } from './formHandler.js';
 import { showError, clearError } from './errorHandler.js';
 
 // Escape HTML to prevent XSS attacks
@@ -23,21 +23,124 @@ export const handleLoginFormSubmit = () => {
         clearError();
 
         const formData = new FormData(e.target);
-        const response = await fetch("/api/login", {
+        let response = await csrfFetch("/api/login", {
             method: "POST",
             body: formData,
         });
 
-        if (response.ok) {
-            const token = await response.text();
-            document.cookie = `session_token=${token}; path=/;`;
+        if (response.status === 202) {
+            const { token } = await response.json();
+            response = await submitTwoFactor(token);
+            if (!response) {
+                return;
+            }
+        }
+        await finishLogin(response);
+    }
+};
+
+// Two-factor authentication: exchange the pending token and a code from the
+// authenticator app (or a recovery code) for a session
+async function submitTwoFactor(token) {
+    const code = prompt("Enter the code from your authenticator app or a recovery code");
+    if (!code) {
+        showError("Login cancelled.");
+        return null;
+    }
+    return csrfFetch("/api/login-2fa", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ token, code }),
+    });
+}
+
+async function finishLogin(response) {
+    if (response.ok) {
+        const token = await response.text();
+        document.cookie = `session_token=${token}; path=/;`;
+
+        clearError();
+        navigateTo("/homepage");
+    } else {
+        const errorText = await response.text();
+        showError(errorText || "Login failed. Please try again.");
+    }
+}
+
+// handlePendingTwoFactor finishes a provider sign-in that still needs the
+// second factor. The callback passes the pending token in the URL fragment.
+export const handlePendingTwoFactor = async () => {
+    const match = location.hash.match(/^#two_factor=([\w-]+)$/);
+    if (!match) {
+        return;
+    }
+    history.replaceState(null, null, location.pathname);
+    const response = await submitTwoFactor(match[1]);
+    if (response) {
+        await finishLogin(response);
+    }
+};
 
+// handleResetPasswordFormSubmit wires the reset password page: it either
+// asks for a reset link or sets the new password with the token from the
+// link in the email
+export const handleResetPasswordFormSubmit = () => {
+    const forgotForm = document.getElementById("forgotPassword");
+    if (forgotForm) {
+        forgotForm.addEventListener("submit", async e => {
+            e.preventDefault();
             clearError();
-            navigateTo("/homepage");
-        } else {
-            const errorText = await response.text();
-            showError(errorText || "Login failed. Please try again.");
-        }
+            const email = new FormData(e.target).get("email");
+            const response = await csrfFetch("/api/forgot-password", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ email }),
+            });
+            if (response.ok) {
+                const { message } = await response.json();
+                setFormMessage(document.getElementById("reset-message"), "success", message);
+            } else {
+                const errorText = await response.text();
+                showError(errorText || "Failed to send the reset link. Please try again.");
+            }
+        });
+    }
+
+    const resetForm = document.getElementById("resetPassword");
+    if (resetForm) {
+        resetForm.addEventListener("submit", async e => {
+            e.preventDefault();
+            clearError();
+            const formData = new FormData(e.target);
+            const password = formData.get("password");
+            if (password !== formData.get("confirm")) {
+                setInputError(resetForm.querySelector('[name="confirm"]'), "Passwords do not match");
+                return;
+            }
+            const token = new URLSearchParams(location.search).get("token");
+            const response = await csrfFetch("/api/reset-password", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ token, password }),
+            });
+            if (response.ok) {
+                // The token is spent; keep it out of the history
+                history.replaceState(null, null, location.pathname);
+                resetForm.querySelectorAll("input").forEach(input => input.disabled = true);
+                setFormMessage(document.getElementById("reset-message"), "success", "Your password has been reset. You can now log in.");
+            } else if (response.headers.get("Content-Type") === "application/json") {
+                const { errors } = await response.json();
+                for (const [field, message] of Object.entries(errors)) {
+                    const input = resetForm.querySelector(`[name="${field}"]`);
+                    if (input) {
+                        setInputError(input, message);
+                    }
+                }
+            } else {
+                const errorText = await response.text();
+                showError(errorText || "Failed to reset the password. Please try again.");
+            }
+        });
     }
 };
 
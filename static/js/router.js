@@ -4,9 +4,11 @@ import Registration from "./views/Registration.js";
 import CreatePost from "./views/CreatePost.js";
 import Messages from "./views/Messages.js";
 import CreatePostCategory from "./views/CreatePostCategory.js";
+import ResetPassword from "./views/ResetPassword.js";
 import { isAuthenticated } from './auth.js';
-import { handleLoginFormSubmit, handleLogout, handleCreatePostFormSubmit, setupMessageForm, handleCreateCategoryFormSubmit } from './eventHandlers.js';
+import { handleLoginFormSubmit, handlePendingTwoFactor, handleResetPasswordFormSubmit, handleLogout, handleCreatePostFormSubmit, setupMessageForm, handleCreateCategoryFormSubmit } from './eventHandlers.js';
 import { showError, clearError } from './errorHandler.js';
+import { csrfFetch } from './api.js';
 import { setInputError, clearInputError, setupFormSwitching, setupFormValidation } from './formHandler.js';
 
 // Function to convert path to regex for route matching
@@ -34,6 +36,7 @@ export const router = async () => {
         { path: "/registration", view: Registration },
         { path: "/homepage", view: Homepage, protected: true },
         { path: "/logout", view: Login },
+        { path: "/reset-password", view: ResetPassword },
         { path: "/create-post", view: CreatePost, protected: true },
         { path: "/messages", view: Messages, protected: true },
         { path: "/create-category", view: CreatePostCategory, protected: true }
@@ -73,6 +76,12 @@ export const router = async () => {
 
     // Call setup functions after view is loaded
     handleLoginFormSubmit(clearError, showError);
+    if (location.pathname === "/") {
+        handlePendingTwoFactor();
+    }
+    if (location.pathname === "/reset-password") {
+        handleResetPasswordFormSubmit();
+    }
     handleLogout();
     setupFormSwitching();
     setupFormValidation();
@@ -132,12 +141,21 @@ export const router = async () => {
         createAccount.addEventListener("submit", async e => {
             e.preventDefault();
             const formData = new FormData(e.target);
-            let response = await fetch("/registration", {
+            let response = await csrfFetch("/registration", {
                 method: "POST",
                 body: formData,
             });
             if (response.ok) {
                 navigateTo("/");
+            } else if (response.headers.get("Content-Type") === "application/json") {
+                // Per-field validation errors keyed by input name
+                const { errors } = await response.json();
+                for (const [field, message] of Object.entries(errors)) {
+                    const input = createAccount.querySelector(`[name="${field}"]`);
+                    if (input) {
+                        setInputError(input, message);
+                    }
+                }
             } else {
                 const errorText = await response.text();
                 showError(errorText || "Registration failed. Please try again.");
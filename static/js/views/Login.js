@@ -5,6 +5,18 @@ export default class extends AbstractView {
     this.setTitle("Login");
   }
   async getHtml() {
+    // "Sign in with ..." buttons for the configured identity providers
+    let providerLinks = "";
+    try {
+      const response = await fetch("/api/oidc-providers");
+      const providers = response.ok ? await response.json() : [];
+      providerLinks = providers.map(p => `
+          <p class="form__text">
+              <a class="form__button" href="${encodeURI(p.login_url)}">Sign in with ${p.display_name.replace(/[&<>"']/g, "")}</a>
+          </p>`).join("");
+    } catch (e) {
+      console.error("Failed to load identity providers", e);
+    }
     return `
       <form class="form" id="login">
       <div class="container-login">
@@ -19,8 +31,9 @@ export default class extends AbstractView {
               <div class="form__input-error-message"></div>
           </div>
           <button class="form__button" type="submit">Continue</button>
+          ${providerLinks}
           <p class="form__text">
-              <a href="#" class="form__link">Forgot your password?</a>
+              <a href="/reset-password" class="form__link" data-link>Forgot your password?</a>
           </p>
           <p class="form__text">
               <a class="form__link" href="./registration" id="linkCreateAccount" data-link>Don't have an account? Create account</a>
@@ -0,0 +1,52 @@
+import AbstractView from "./AbstractView.js";
+
+// The page opened from the password reset email. Without a token in the
+// URL it asks for the email address to send the link to instead.
+export default class extends AbstractView {
+  constructor(params) {
+    super(params);
+    this.setTitle("Reset password");
+  }
+  async getHtml() {
+    if (!new URLSearchParams(location.search).get("token")) {
+      return `
+      <form class="form" id="forgotPassword">
+      <div class="container-login">
+          <h1 class="form__title">Forgot password</h1>
+          <div id="error-message" class="form__message form__message--error"></div>
+          <div id="reset-message" class="form__message"></div>
+          <div class="form__input-group">
+              <input type="email" class="form__input" name="email" required autofocus placeholder="Email">
+              <div class="form__input-error-message"></div>
+          </div>
+          <button class="form__button" type="submit">Send reset link</button>
+          <p class="form__text">
+              <a class="form__link" href="/" data-link>Back to login</a>
+          </p>
+      </div>
+      </form>
+      `;
+    }
+    return `
+      <form class="form" id="resetPassword">
+      <div class="container-login">
+          <h1 class="form__title">Choose a new password</h1>
+          <div id="error-message" class="form__message form__message--error"></div>
+          <div id="reset-message" class="form__message"></div>
+          <div class="form__input-group">
+              <input type="password" class="form__input" name="password" required autofocus placeholder="New password">
+              <div class="form__input-error-message"></div>
+          </div>
+          <div class="form__input-group">
+              <input type="password" class="form__input" name="confirm" required placeholder="Confirm new password">
+              <div class="form__input-error-message"></div>
+          </div>
+          <button class="form__button" type="submit">Reset password</button>
+          <p class="form__text">
+              <a class="form__link" href="/" data-link>Back to login</a>
+          </p>
+      </div>
+      </form>
+      `;
+  }
+}
@@ -1,20 +1,44 @@
+import { getCSRFToken } from './api.js';
+
 let socket;
 let messageHandler = () => {};
 let reactionHandler = () => {};
+let replyHandler = () => {};
+
+const PROTOCOL_VERSION = 1;
+let lastFrameID = 0;
+const pendingFrames = new Map();
 
-export const connectWebSocket = (sessionToken) => {
-    socket = new WebSocket(`ws://localhost:8080/ws?session_token=${sessionToken}`);
+export const connectWebSocket = async (sessionToken) => {
+    // The handshake cannot carry headers, so the CSRF token goes in the URL
+    const csrfToken = await getCSRFToken();
+    const scheme = location.protocol === "https:" ? "wss:" : "ws:";
+    socket = new WebSocket(`${scheme}//${location.host}/ws?session_token=${encodeURIComponent(sessionToken)}&csrf_token=${encodeURIComponent(csrfToken)}`);
 
     socket.onopen = () => {
         console.log("Connected to WebSocket server");
     };
 
+    // Every frame is an envelope { v, type, id, payload }. Pushed events
+    // carry their data in payload; ack and error frames answer the frame
+    // with the same id.
     socket.onmessage = (event) => {
-        const message = JSON.parse(event.data);
-        if (message.type === "message") {
-            messageHandler(message);
-        } else if (message.type === "reaction") {
-            reactionHandler(message);
+        const frame = JSON.parse(event.data);
+        if (frame.type === "message") {
+            messageHandler(frame.payload);
+        } else if (frame.type === "reaction") {
+            reactionHandler(frame.payload);
+        } else if (frame.type === "reply") {
+            replyHandler(frame.payload);
+        } else if (frame.type === "ack" || frame.type === "error") {
+            const pending = pendingFrames.get(frame.id);
+            pendingFrames.delete(frame.id);
+            if (frame.type === "error") {
+                console.error("WebSocket error frame:", frame.payload);
+            }
+            if (pending) {
+                pending(frame);
+            }
         }
     };
 
@@ -27,12 +51,17 @@ export const connectWebSocket = (sessionToken) => {
     };
 };
 
-export const sendMessage = (message) => {
-    if (socket && socket.readyState === WebSocket.OPEN) {
-        socket.send(JSON.stringify(message));
-    } else {
-        console.error("WebSocket is not connected");
+// sendFrame sends a frame of the given type and resolves with the ack or
+// error frame answering it
+export const sendFrame = (type, payload) => {
+    if (!socket || socket.readyState !== WebSocket.OPEN) {
+        return Promise.reject(new Error("WebSocket is not connected"));
     }
+    const id = String(++lastFrameID);
+    return new Promise((resolve) => {
+        pendingFrames.set(id, resolve);
+        socket.send(JSON.stringify({ v: PROTOCOL_VERSION, type, id, payload }));
+    });
 };
 
 export const setMessageHandler = (handler) => {
@@ -42,3 +71,7 @@ export const setMessageHandler = (handler) => {
 export const setReactionHandler = (handler) => {
     reactionHandler = handler;
 };
+
+export const setReplyHandler = (handler) => {
+    replyHandler = handler;
+};
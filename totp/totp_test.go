@@ -0,0 +1,87 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of RFC 6238 appendix B, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil || got != tt.want {
+			t.Errorf("code at %d = %q, %v; want %q", tt.unix, got, err, tt.want)
+		}
+	}
+	if lower, _ := Code(strings.ToLower(rfcSecret), 1); lower != "287082" {
+		t.Errorf("lower case secret gave %q", lower)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	for offset := int64(-Skew - 1); offset <= Skew+1; offset++ {
+		code, err := Code(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now)
+		inWindow := offset >= -Skew && offset <= Skew
+		if ok != inWindow || (ok && step != current+offset) {
+			t.Errorf("code of step %+d: step %d, ok %v", offset, step-current, ok)
+		}
+	}
+
+	for _, code := range []string{" 050471 ", "050 471"} {
+		if _, ok := Validate(rfcSecret, code, now); !ok {
+			t.Errorf("%q refused", code)
+		}
+	}
+	for _, code := range []string{"", "50471", "0504710", "050472", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("%q accepted", code)
+		}
+	}
+}
+
+func TestGenerateSecretAndURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Fatalf("secret %q is not 160 bits", secret)
+	}
+	if other, _ := GenerateSecret(); other == secret {
+		t.Fatal("secrets repeat")
+	}
+	u, err := url.Parse(URI("My Forum", "alice@example.com", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/My Forum:alice@example.com" ||
+		q.Get("secret") != secret || q.Get("issuer") != "My Forum" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Fatalf("URI = %s", u)
+	}
+}